@@ -0,0 +1,15 @@
+package goscp
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Last access time of a file, its modification time when unknown.
+func fileAtime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Unix())
+	}
+	return info.ModTime()
+}
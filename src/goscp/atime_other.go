@@ -0,0 +1,13 @@
+//go:build !linux
+
+package goscp
+
+import (
+	"os"
+	"time"
+)
+
+// Last access time of a file, its modification time as it isn't portable.
+func fileAtime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
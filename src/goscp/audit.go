@@ -0,0 +1,256 @@
+package goscp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuditEntry is an existing file that a transfer would overwrite.
+type AuditEntry struct {
+	// Existing file, local for downloads and remote for uploads
+	Path string
+
+	// File that would replace it, remote for downloads and local for uploads
+	Source string
+}
+
+// AuditDownload reports the local files that downloading remotePath to
+// DestinationPath would overwrite. Nothing is transferred, the remote tree is
+// listed with find and compared with the local one.
+func (c *Client) AuditDownload(ctx context.Context, remotePath string) ([]AuditEntry, error) {
+	return c.AuditDownloadWithOptions(ctx, remotePath, c.TransferOptions)
+}
+
+// AuditDownloadWithOptions works like AuditDownload but uses opts instead of
+// the Client's own options. The remote commands run with the Env, Sudo and
+// RemoteCharset options, and fail with ErrRefused when ProbeRemote or Quirks
+// tell of a shell other than POSIX.
+func (c *Client) AuditDownloadWithOptions(ctx context.Context, remotePath string, opts TransferOptions) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := c.withTransfer(ctx, opts, func(t *transfer) (err error) {
+		entries, err = t.auditDownload(ctx, remotePath)
+		return err
+	})
+	return entries, err
+}
+
+// AuditUpload reports the remote files that uploading localPath to
+// DestinationPath would overwrite. Nothing is transferred, the remote host
+// is only asked which of the files the upload would write already exist.
+// Directories with one of the ExcludeMarkers are left out, like the upload
+// leaves them out.
+func (c *Client) AuditUpload(ctx context.Context, localPath string) ([]AuditEntry, error) {
+	return c.AuditUploadWithOptions(ctx, localPath, c.TransferOptions)
+}
+
+// AuditUploadWithOptions works like AuditUpload but uses opts instead of the
+// Client's own options, with remote commands run like AuditDownloadWithOptions
+// runs them.
+func (c *Client) AuditUploadWithOptions(ctx context.Context, localPath string, opts TransferOptions) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := c.withTransfer(ctx, opts, func(t *transfer) (err error) {
+		entries, err = t.auditUpload(ctx, localPath)
+		return err
+	})
+	return entries, err
+}
+
+// Run fn with a transfer for opts that moves no files, probing the remote
+// first with the ProbeRemote option.
+func (c *Client) withTransfer(ctx context.Context, opts TransferOptions, fn func(*transfer) error) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	t := c.startTransfer(opts)
+	defer c.endTransfer(t)
+
+	t.probe(ctx)
+	return fn(t)
+}
+
+// List the files below remotePath and find the local ones a download would
+// replace.
+func (t *transfer) auditDownload(ctx context.Context, remotePath string) ([]AuditEntry, error) {
+	root := path.Clean(remotePath)
+	encoded, err := t.encodeRemote(root)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := t.shellOutput(ctx, "find -L "+QuotePOSIX(encoded)+" -type f -print0")
+	if err != nil {
+		return nil, fmt.Errorf("Could not list [%q]: %w", remotePath, err)
+	}
+
+	// Local names are decoded and normalized like downloaded ones
+	var remoteFiles []string
+	for _, f := range splitNul(out) {
+		decoded, err := t.decodeRemote(f)
+		if err != nil {
+			return nil, err
+		}
+		remoteFiles = append(remoteFiles, t.normalizeName(decoded))
+	}
+
+	return auditDownload(t.opts.DestinationPath, t.normalizeName(root), remoteFiles), nil
+}
+
+// Walk localPath and ask the remote which of the files an upload would
+// write exist.
+func (t *transfer) auditUpload(ctx context.Context, localPath string) ([]AuditEntry, error) {
+	remoteDest := filepath.ToSlash(filepath.Join(t.opts.DestinationPath...))
+	destIsDir, err := t.remoteIsDir(ctx, remoteDest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries by the encoded remote path the remote reports
+	sources := make(map[string]AuditEntry)
+	var targets []string
+	err = walkStream(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Reported by the upload walk itself
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(p); err == nil {
+				info = target
+			}
+		}
+
+		if info.IsDir() {
+			if _, ok := findMarker(p, t.opts.ExcludeMarkers); ok {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := remoteUploadPath(remoteDest, destIsDir, t.normalizeName(localPath), t.normalizeName(p))
+		encoded, err := t.encodeRemote(target)
+		if err != nil {
+			return err
+		}
+		sources[encoded] = AuditEntry{Path: target, Source: p}
+		targets = append(targets, encoded)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AuditEntry
+	for _, batch := range batchCommands(existsCommands(targets)) {
+		out, err := t.shellOutput(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("Could not check [%q]: %w", remoteDest, err)
+		}
+
+		for _, existing := range splitNul(out) {
+			entries = append(entries, sources[existing])
+		}
+	}
+
+	return entries, nil
+}
+
+// Map the files a download of root lists to the local files they would
+// replace, keeping those that exist and aren't directories.
+func auditDownload(dest []string, root string, remoteFiles []string) []AuditEntry {
+	var entries []AuditEntry
+	for _, f := range remoteFiles {
+		if f != root && !strings.HasPrefix(f, strings.TrimSuffix(root, "/")+"/") {
+			continue
+		}
+
+		// The download creates root under its base name
+		rel := path.Join(path.Base(root), strings.TrimPrefix(f, root))
+		local := filepath.Join(append(append([]string(nil), dest...), filepath.FromSlash(rel))...)
+
+		if info, err := os.Stat(local); err == nil && !info.IsDir() {
+			entries = append(entries, AuditEntry{Path: local, Source: f})
+		}
+	}
+
+	return entries
+}
+
+// Build one shell test per remote path that prints the path, followed by a
+// NUL, when something exists there.
+func existsCommands(paths []string) []string {
+	commands := make([]string, 0, len(paths))
+	for _, p := range paths {
+		q := QuotePOSIX(p)
+		commands = append(commands, fmt.Sprintf("{ [ ! -e %s ] || printf '%%s\\000' %s; }", q, q))
+	}
+	return commands
+}
+
+// Join commands with && into command lines shorter than
+// maxCopyCommandLength, except for single commands longer than that.
+func batchCommands(commands []string) []string {
+	var batches []string
+	for len(commands) > 0 {
+		n, length := 1, len(commands[0])
+		for n < len(commands) && length+len(commands[n])+4 < maxCopyCommandLength {
+			length += len(commands[n]) + 4
+			n++
+		}
+		batches = append(batches, strings.Join(commands[:n], " && "))
+		commands = commands[n:]
+	}
+	return batches
+}
+
+// Split find -print0 style output.
+func splitNul(out []byte) []string {
+	var fields []string
+	for _, f := range bytes.Split(out, []byte{0}) {
+		if len(f) > 0 {
+			fields = append(fields, string(f))
+		}
+	}
+	return fields
+}
+
+// Run cmd on the remote host and return its output.
+func (c *Client) remoteOutput(ctx context.Context, cmd string) ([]byte, error) {
+	return c.runRemote(ctx, func(session *ssh.Session) ([]byte, error) {
+		return session.Output(cmd)
+	})
+}
+
+// Run cmd on the remote host and return its output and error output.
+func (c *Client) remoteCombinedOutput(ctx context.Context, cmd string) ([]byte, error) {
+	return c.runRemote(ctx, func(session *ssh.Session) ([]byte, error) {
+		return session.CombinedOutput(cmd)
+	})
+}
+
+// Run fn with a new session, which is closed early if ctx is done.
+func (c *Client) runRemote(ctx context.Context, fn func(*ssh.Session) ([]byte, error)) ([]byte, error) {
+	session, err := c.SSHClient.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Could not open session: %w", err)
+	}
+	defer session.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		session.Close()
+	})
+	defer stop()
+
+	out, err := fn(session)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return out, err
+}
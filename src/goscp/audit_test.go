@@ -0,0 +1,118 @@
+package goscp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuditDownload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-audit")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, p := range []string{"data/a.txt", "data/sub/b.txt", "data/dir/keep"} {
+		local := filepath.Join(tmp, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if err := ioutil.WriteFile(local, []byte("x"), 0644); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	remoteFiles := []string{
+		"/srv/data/a.txt",
+		"/srv/data/new.txt",
+		"/srv/data/sub/b.txt",
+		// A directory locally, the download would fail rather than replace it
+		"/srv/data/dir",
+		// Not below the requested path
+		"/srv/database",
+	}
+
+	entries := auditDownload([]string{tmp}, "/srv/data", remoteFiles)
+	expected := []AuditEntry{
+		{Path: filepath.Join(tmp, "data", "a.txt"), Source: "/srv/data/a.txt"},
+		{Path: filepath.Join(tmp, "data", "sub", "b.txt"), Source: "/srv/data/sub/b.txt"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		expectedError(t, entries, expected)
+	}
+
+	// A single file lands under its base name
+	entries = auditDownload([]string{tmp, "data"}, "/srv/a.txt", []string{"/srv/a.txt"})
+	expected = []AuditEntry{{Path: filepath.Join(tmp, "data", "a.txt"), Source: "/srv/a.txt"}}
+	if !reflect.DeepEqual(entries, expected) {
+		expectedError(t, entries, expected)
+	}
+}
+
+func TestExistsCommands(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("No shell available")
+	}
+
+	tmp, err := ioutil.TempDir("", "goscp-exists")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	existing := filepath.Join(tmp, "it's here.txt")
+	if err := ioutil.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	paths := []string{filepath.Join(tmp, "missing"), existing, filepath.Join(tmp, "$(false)")}
+	for _, cmd := range batchCommands(existsCommands(paths)) {
+		out, err := exec.Command("sh", "-c", cmd).Output()
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		found := splitNul(out)
+		if !reflect.DeepEqual(found, []string{existing}) {
+			expectedError(t, found, []string{existing})
+		}
+	}
+}
+
+func TestBatchCommands(t *testing.T) {
+	long := strings.Repeat("x", maxCopyCommandLength/2)
+	tests := []struct {
+		Commands []string
+		Expected []string
+	}{
+		{Commands: nil, Expected: nil},
+		{Commands: []string{"a", "b"}, Expected: []string{"a && b"}},
+		{Commands: []string{long, long, "a"}, Expected: []string{long, long + " && a"}},
+	}
+
+	for _, v := range tests {
+		batches := batchCommands(v.Commands)
+		if !reflect.DeepEqual(batches, v.Expected) {
+			expectedError(t, len(batches), len(v.Expected))
+		}
+	}
+}
+
+func TestAuditRefusesShell(t *testing.T) {
+	c := &Client{}
+	opts := TransferOptions{Quirks: Quirks{Shell: ShellPowerShell}}
+
+	if _, err := c.AuditDownloadWithOptions(context.Background(), "/srv/data", opts); !errors.Is(err, ErrRefused) {
+		expectedError(t, err, ErrRefused)
+	}
+	if _, err := c.AuditUploadWithOptions(context.Background(), ".", opts); !errors.Is(err, ErrRefused) {
+		expectedError(t, err, ErrRefused)
+	}
+}
@@ -0,0 +1,263 @@
+package goscp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// BenchmarkResult holds the measurements taken for one synthetic file size.
+type BenchmarkResult struct {
+	// Size of the synthetic file in bytes
+	Size int64
+
+	// Time until the remote sink acknowledged the session
+	UploadLatency time.Duration
+
+	// Time taken to send the synthetic file, from its first content byte
+	// until the remote sink acknowledged it
+	UploadDuration time.Duration
+
+	// Time until the remote source announced the synthetic file
+	DownloadLatency time.Duration
+
+	// Time taken to receive the synthetic file, from acknowledging its header
+	// until the remote source finished it
+	DownloadDuration time.Duration
+}
+
+// UploadThroughput returns the achieved upload rate in bytes per second.
+func (r BenchmarkResult) UploadThroughput() float64 {
+	return throughput(r.Size, r.UploadDuration)
+}
+
+// DownloadThroughput returns the achieved download rate in bytes per second.
+func (r BenchmarkResult) DownloadThroughput() float64 {
+	return throughput(r.Size, r.DownloadDuration)
+}
+
+func throughput(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / d.Seconds()
+}
+
+// Benchmark sends a synthetic file of each size to c.DestinationPath on the
+// remote host, downloads it again and reports the achieved throughput and
+// latency. The remote scp runs like it does for transfers with the Client's
+// options, BandwidthLimit included. The synthetic files are removed from the
+// remote host afterwards, which needs a POSIX shell.
+func (c *Client) Benchmark(ctx context.Context, sizes []int64) ([]BenchmarkResult, error) {
+	var results []BenchmarkResult
+	err := c.withTransfer(ctx, benchmarkOptions(c.TransferOptions), func(t *transfer) (err error) {
+		results, err = t.benchmark(ctx, sizes)
+		return err
+	})
+	return results, err
+}
+
+// Options of Benchmark. The synthetic file is sent without times, to a path
+// that isn't a directory.
+func benchmarkOptions(opts TransferOptions) TransferOptions {
+	opts.PreserveTimes = false
+	opts.TargetIsDirectory = false
+	return opts
+}
+
+func (t *transfer) benchmark(ctx context.Context, sizes []int64) ([]BenchmarkResult, error) {
+	results := make([]BenchmarkResult, 0, len(sizes))
+
+	for _, size := range sizes {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		name := fmt.Sprintf(".goscp-benchmark-%d", size)
+		remotePath := path.Join(filepath.ToSlash(filepath.Join(t.opts.DestinationPath...)), name)
+		result := BenchmarkResult{Size: size}
+
+		var err error
+		result.UploadLatency, result.UploadDuration, err = t.benchmarkSession(ctx, FlagSink, remotePath, func(w io.Writer, r *bufio.Reader) (time.Duration, time.Duration, error) {
+			return benchmarkSend(w, r, name, size)
+		})
+		if err != nil {
+			return results, err
+		}
+
+		result.DownloadLatency, result.DownloadDuration, err = t.benchmarkSession(ctx, FlagSource, remotePath, func(w io.Writer, r *bufio.Reader) (time.Duration, time.Duration, error) {
+			return benchmarkReceive(w, r, size)
+		})
+
+		// Remove the synthetic file even if the download failed
+		if rmErr := t.benchmarkCleanup(ctx, remotePath); err == nil {
+			err = rmErr
+		}
+		if err != nil {
+			return results, err
+		}
+
+		t.outputInfo(fmt.Sprintf("Benchmark %d bytes: up %.0f B/s, down %.0f B/s", size, result.UploadThroughput(), result.DownloadThroughput()))
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Run one benchmark leg in its own session, with the remote scp started by
+// flag FlagSink or FlagSource. Returns the latency and duration measured by
+// fn, which leave out opening the session and starting the remote scp.
+func (t *transfer) benchmarkSession(ctx context.Context, flag, remotePath string, fn func(io.Writer, *bufio.Reader) (time.Duration, time.Duration, error)) (time.Duration, time.Duration, error) {
+	cmd, err := t.benchmarkCommand(flag, remotePath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	session, err := t.openSession()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer session.Close()
+
+	if err := t.openPipes(session); err != nil {
+		return 0, 0, err
+	}
+	session.Stderr = &promptWatcher{t: t, session: session}
+
+	var latency, duration time.Duration
+	var fnErr error
+	err = t.runSession(ctx, session, cmd, func() {
+		defer t.scpStdinPipe.Close()
+
+		t.markStarted()
+		latency, duration, fnErr = fn(t.scpStdinPipe, t.scpStdoutPipe.Reader)
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, 0, ctxErr
+	}
+	// Stalls, timeouts and prompts are recorded on the transfer
+	if tErr := t.contextError(ctx); tErr != nil {
+		return 0, 0, tErr
+	}
+	if fnErr != nil {
+		return 0, 0, fnErr
+	}
+	if err != nil {
+		return 0, 0, t.sessionError(ctx, err, remotePath)
+	}
+
+	return latency, duration, nil
+}
+
+// Remove a synthetic benchmark file from the remote host, also after ctx is
+// done.
+func (t *transfer) benchmarkCleanup(ctx context.Context, remotePath string) error {
+	encoded, err := t.encodeRemote(remotePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.shellOutput(context.WithoutCancel(ctx), "rm -f "+QuotePOSIX(encoded))
+	return err
+}
+
+// Build the scp command sending or receiving a single synthetic file, with
+// flag FlagSink or FlagSource, like the transfer's own commands.
+func (t *transfer) benchmarkCommand(flag, remotePath string) (string, error) {
+	encoded, err := t.encodeRemote(remotePath)
+	if err != nil {
+		return "", err
+	}
+	return t.command(Command{Flags: []string{flag}, Path: encoded}), nil
+}
+
+// Send a synthetic file of size bytes to a remote sink. Returns the time until
+// the sink's first ack and the time from the first content byte until the
+// final ack.
+func benchmarkSend(w io.Writer, r *bufio.Reader, name string, size int64) (time.Duration, time.Duration, error) {
+	start := time.Now()
+	if err := readBenchmarkAck(r); err != nil {
+		return 0, 0, err
+	}
+	latency := time.Since(start)
+
+	fmt.Fprintf(w, "C0644 %d %s\n", size, name)
+	if err := readBenchmarkAck(r); err != nil {
+		return 0, 0, err
+	}
+
+	start = time.Now()
+	if _, err := io.CopyN(w, zeroReader{}, size); err != nil {
+		return 0, 0, err
+	}
+
+	fmt.Fprint(w, "\x00")
+	if err := readBenchmarkAck(r); err != nil {
+		return 0, 0, err
+	}
+
+	return latency, time.Since(start), nil
+}
+
+// Receive a synthetic file of size bytes from a remote source. Returns the
+// time until the source announced the file and the time from acknowledging
+// its header until the source's final ack.
+func benchmarkReceive(w io.Writer, r *bufio.Reader, size int64) (time.Duration, time.Duration, error) {
+	start := time.Now()
+	fmt.Fprint(w, "\x00")
+
+	msg, err := r.ReadString('\n')
+	if err != nil {
+		return 0, 0, err
+	}
+	latency := time.Since(start)
+
+	if len(msg) == 0 || msg[0] != 'C' {
+		return 0, 0, fmt.Errorf("Unexpected benchmark message: [%q]", msg)
+	}
+
+	start = time.Now()
+	fmt.Fprint(w, "\x00")
+
+	if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+		return 0, 0, err
+	}
+
+	if err := readBenchmarkAck(r); err != nil {
+		return 0, 0, err
+	}
+	duration := time.Since(start)
+	fmt.Fprint(w, "\x00")
+
+	return latency, duration, nil
+}
+
+// Read a single response byte, returning the remote message on failure.
+func readBenchmarkAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+
+	msg, _ := r.ReadString('\n')
+	return errors.New("Benchmark failed: " + msg)
+}
+
+// Endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,155 @@
+package goscp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Delay of the fake remote before a message that isn't timed as transfer.
+const benchmarkDelay = 200 * time.Millisecond
+
+func TestBenchmarkSend(t *testing.T) {
+	tests := []struct {
+		Size          int64
+		SinkResponse  string
+		ExpectedError string
+	}{
+		{
+			// Successful upload
+			Size:         1024,
+			SinkResponse: "\x00",
+		},
+		{
+			// Rejected by the remote sink
+			Size:          1024,
+			SinkResponse:  "\x01scp: permission denied\n",
+			ExpectedError: "Benchmark failed: scp: permission denied\n",
+		},
+	}
+
+	for _, v := range tests {
+		localR, remoteW := io.Pipe()
+		remoteR, localW := io.Pipe()
+
+		go func(size int64, response string) {
+			br := bufio.NewReader(remoteR)
+
+			fmt.Fprint(remoteW, "\x00")
+			msg, _ := br.ReadString('\n')
+			if expected := fmt.Sprintf("C0644 %d bench\n", size); msg != expected {
+				expectedError(t, msg, expected)
+			}
+
+			// A slow header ack isn't part of the duration
+			time.Sleep(benchmarkDelay)
+			fmt.Fprint(remoteW, response)
+			if response != "\x00" {
+				return
+			}
+
+			io.CopyN(ioutil.Discard, br, size+1)
+			fmt.Fprint(remoteW, "\x00")
+		}(v.Size, v.SinkResponse)
+
+		latency, duration, err := benchmarkSend(localW, bufio.NewReader(localR), "bench", v.Size)
+		if err != nil {
+			if err.Error() != v.ExpectedError {
+				expectedError(t, err, v.ExpectedError)
+			}
+			continue
+		}
+		if v.ExpectedError != "" {
+			expectedError(t, nil, v.ExpectedError)
+		}
+		if latency <= 0 {
+			expectedError(t, latency, "> 0")
+		}
+		if duration <= 0 || duration >= benchmarkDelay {
+			expectedError(t, duration, fmt.Sprintf("> 0 and < %s", benchmarkDelay))
+		}
+	}
+}
+
+func TestBenchmarkReceive(t *testing.T) {
+	size := int64(2048)
+
+	localR, remoteW := io.Pipe()
+	remoteR, localW := io.Pipe()
+
+	go func() {
+		br := bufio.NewReader(remoteR)
+
+		br.ReadByte()
+		time.Sleep(benchmarkDelay)
+		fmt.Fprintf(remoteW, "C0644 %d bench\n", size)
+		br.ReadByte()
+		io.CopyN(remoteW, zeroReader{}, size)
+		fmt.Fprint(remoteW, "\x00")
+		br.ReadByte()
+	}()
+
+	latency, duration, err := benchmarkReceive(localW, bufio.NewReader(localR), size)
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if latency < benchmarkDelay {
+		expectedError(t, latency, fmt.Sprintf(">= %s", benchmarkDelay))
+	}
+	if duration <= 0 || duration >= benchmarkDelay {
+		expectedError(t, duration, fmt.Sprintf("> 0 and < %s", benchmarkDelay))
+	}
+}
+
+func TestBenchmarkThroughput(t *testing.T) {
+	r := BenchmarkResult{
+		Size:             1000,
+		UploadDuration:   time.Second,
+		DownloadDuration: time.Second / 2,
+	}
+
+	if r.UploadThroughput() != 1000 {
+		expectedError(t, r.UploadThroughput(), 1000)
+	}
+	if r.DownloadThroughput() != 2000 {
+		expectedError(t, r.DownloadThroughput(), 2000)
+	}
+	if (BenchmarkResult{}).UploadThroughput() != 0 {
+		expectedError(t, (BenchmarkResult{}).UploadThroughput(), 0)
+	}
+}
+
+func TestBenchmarkCommand(t *testing.T) {
+	tests := []struct {
+		Flag       string
+		RemotePath string
+		Expected   string
+	}{
+		{Flag: FlagSink, RemotePath: "/tmp/.goscp-benchmark-1", Expected: "scp -t /tmp/.goscp-benchmark-1"},
+		{Flag: FlagSource, RemotePath: "/tmp/$HOME/`id`/.goscp-benchmark-1", Expected: "scp -f '/tmp/$HOME/`id`/.goscp-benchmark-1'"},
+		{Flag: FlagSink, RemotePath: "/tmp/it's/.goscp-benchmark-1", Expected: `scp -t '/tmp/it'\''s/.goscp-benchmark-1'`},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: benchmarkOptions(TransferOptions{})}
+		if cmd, _ := c.benchmarkCommand(v.Flag, v.RemotePath); cmd != v.Expected {
+			expectedError(t, cmd, v.Expected)
+		}
+	}
+
+	// Built like the transfer's own commands, without times or -d
+	c := &transfer{opts: benchmarkOptions(TransferOptions{
+		RemoteProgram:     "/opt/scp",
+		RemoteFlags:       []string{"-O"},
+		Compress:          true,
+		PreserveTimes:     true,
+		TargetIsDirectory: true,
+	})}
+	expected := "/opt/scp -O -C -t /tmp/.goscp-benchmark-1"
+	if cmd, _ := c.benchmarkCommand(FlagSink, "/tmp/.goscp-benchmark-1"); cmd != expected {
+		expectedError(t, cmd, expected)
+	}
+}
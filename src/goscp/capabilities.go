@@ -0,0 +1,183 @@
+package goscp
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Shells the remote host can run commands in.
+const (
+	ShellPOSIX      = "posix"
+	ShellCmd        = "cmd"
+	ShellPowerShell = "powershell"
+)
+
+// Commands run to find the capabilities. The shell probe prints
+// "goscp:<name of the shell>" in POSIX shells, "goscp:" in PowerShell where
+// $0 is unset, and the quoted text as is in cmd.exe.
+const (
+	shellProbe = `echo "goscp:$0"`
+	scpProbe   = "scp"
+)
+
+// Option list in the usage the remote scp prints when run without
+// arguments, e.g. "usage: scp [-346ABCOpqRrsTv] [-c cipher] ...".
+var scpUsageRx = regexp.MustCompile(`usage: scp \[-([0-9A-Za-z]+)\]`)
+
+// Capabilities describes the remote scp and the shell running it, as found
+// by Client.Probe.
+type Capabilities struct {
+	// SSH server software from its version string, e.g. "OpenSSH_9.6" or
+	// "dropbear_2022.83"
+	Server string
+
+	// Shell running remote commands, ShellPOSIX, ShellCmd or
+	// ShellPowerShell
+	Shell string
+
+	// Option letters listed in the usage of the remote scp, e.g.
+	// "346ABCOpqRrsTv". Empty when no usage was printed, and every flag is
+	// then assumed supported.
+	Options string
+}
+
+// Supports reports whether the remote scp accepts flag, e.g. FlagPreserve.
+func (c *Capabilities) Supports(flag string) bool {
+	if c.Options == "" {
+		return true
+	}
+	return len(flag) == 2 && flag[0] == '-' && strings.IndexByte(c.Options, flag[1]) >= 0
+}
+
+// Quote returns the function quoting paths for the remote shell.
+func (c *Capabilities) Quote() func(string) string {
+	switch c.Shell {
+	case ShellCmd:
+		return QuoteCmd
+	case ShellPowerShell:
+		return QuotePowerShell
+	}
+	return QuotePOSIX
+}
+
+// Probe finds the capabilities of the remote scp. The result is kept, later
+// calls return it without probing again, and transfers started with the
+// ProbeRemote option adjust their commands to it.
+func (c *Client) Probe(ctx context.Context) (*Capabilities, error) {
+	c.probeMu.Lock()
+	defer c.probeMu.Unlock()
+
+	if caps := c.capabilities(); caps != nil {
+		return caps, nil
+	}
+
+	caps := &Capabilities{
+		Server: strings.TrimPrefix(string(c.SSHClient.ServerVersion()), "SSH-2.0-"),
+	}
+
+	out, err := c.remoteOutput(ctx, shellProbe)
+	if err != nil {
+		return nil, fmt.Errorf("Could not probe remote shell: %w", err)
+	}
+	caps.Shell = parseShellProbe(string(out))
+
+	// scp exits with an error after printing its usage
+	out, err = c.remoteCombinedOutput(ctx, scpProbe)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if m := scpUsageRx.FindStringSubmatch(string(out)); m != nil {
+		caps.Options = m[1]
+	} else if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("Could not probe remote scp: %w", err)
+	}
+
+	c.mu.Lock()
+	c.caps = caps
+	c.mu.Unlock()
+
+	return caps, nil
+}
+
+// Capabilities found by Probe, nil before.
+func (c *Client) capabilities() *Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.caps
+}
+
+// Tell the shell from the output of shellProbe.
+func parseShellProbe(out string) string {
+	out = strings.TrimSpace(out)
+	switch {
+	case out == `"goscp:$0"`:
+		return ShellCmd
+	case out == "goscp:":
+		return ShellPowerShell
+	}
+	return ShellPOSIX
+}
+
+// Find the capabilities of the remote scp with the ProbeRemote option. A
+// failed probe leaves the commands as they are.
+func (t *transfer) probe(ctx context.Context) {
+	if !t.opts.ProbeRemote {
+		return
+	}
+
+	caps, err := t.c.Probe(ctx)
+	if err != nil {
+		t.addWarning("", err.Error())
+		return
+	}
+	t.caps = caps
+
+	if t.opts.Quirks == (Quirks{}) {
+		t.opts.Quirks = QuirksFor(caps.Server)
+	}
+}
+
+// Shell running remote commands, from the Quirks option or else found by
+// Probe. ShellPOSIX if neither tells.
+func (t *transfer) remoteShell() string {
+	switch {
+	case t.opts.Quirks.Shell != "":
+		return t.opts.Quirks.Shell
+	case t.caps != nil:
+		return t.caps.Shell
+	}
+	return ShellPOSIX
+}
+
+// Adjust cmd to the capabilities found by Probe, if any: paths are quoted
+// for the remote shell and unsupported optional flags are dropped.
+func (t *transfer) adjustCommand(cmd Command) Command {
+	if t.caps == nil {
+		return cmd
+	}
+
+	if cmd.Quote == nil {
+		cmd.Quote = t.caps.Quote()
+	}
+
+	flags := make([]string, 0, len(cmd.Flags))
+	for i := 0; i < len(cmd.Flags); i++ {
+		flag := cmd.Flags[i]
+		optional := flag == FlagPreserve || flag == FlagCompress || flag == FlagLimit
+		if optional && !t.caps.Supports(flag) {
+			t.addWarning("", fmt.Sprintf("Remote scp doesn't support %s, leaving it out", flag))
+			if flag == FlagLimit {
+				// Its value goes too
+				i++
+			}
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	cmd.Flags = flags
+
+	return cmd
+}
@@ -0,0 +1,98 @@
+package goscp
+
+import (
+	"testing"
+)
+
+func TestParseShellProbe(t *testing.T) {
+	tests := []struct {
+		Output   string
+		Expected string
+	}{
+		{Output: "goscp:bash\n", Expected: ShellPOSIX},
+		{Output: "goscp:-sh\n", Expected: ShellPOSIX},
+		{Output: "goscp:\r\n", Expected: ShellPowerShell},
+		{Output: "\"goscp:$0\"\r\n", Expected: ShellCmd},
+		{Output: "", Expected: ShellPOSIX},
+	}
+
+	for _, v := range tests {
+		if shell := parseShellProbe(v.Output); shell != v.Expected {
+			expectedError(t, shell, v.Expected)
+		}
+	}
+}
+
+func TestScpUsage(t *testing.T) {
+	usage := "usage: scp [-346ABCOpqRrsTv] [-c cipher] [-D sftp_server_path] [-F ssh_config]\n"
+	m := scpUsageRx.FindStringSubmatch(usage)
+	if m == nil || m[1] != "346ABCOpqRrsTv" {
+		expectedError(t, m, "346ABCOpqRrsTv")
+	}
+}
+
+func TestAdjustCommand(t *testing.T) {
+	tests := []struct {
+		Caps             *Capabilities
+		Command          Command
+		Expected         string
+		ExpectedWarnings int
+	}{
+		{
+			// Not probed
+			Command:  Command{Flags: []string{FlagPreserve, FlagSink}, Path: "/srv/my data"},
+			Expected: "scp -p -t '/srv/my data'",
+		},
+		{
+			// Usage unknown, every flag is kept
+			Caps:     &Capabilities{Shell: ShellPOSIX},
+			Command:  Command{Flags: []string{FlagPreserve, FlagSink}, Path: "/srv/my data"},
+			Expected: "scp -p -t '/srv/my data'",
+		},
+		{
+			Caps:             &Capabilities{Shell: ShellPOSIX, Options: "1246BCqrv"},
+			Command:          Command{Flags: []string{FlagPreserve, FlagRecursive, FlagSink}, Path: "/srv"},
+			Expected:         "scp -r -t /srv",
+			ExpectedWarnings: 1,
+		},
+		{
+			Caps:             &Capabilities{Shell: ShellPOSIX, Options: "346ABCOpqRrsTv"},
+			Command:          Command{Flags: []string{FlagCompress, FlagPreserve, FlagSink}, Path: "/srv"},
+			Expected:         "scp -C -p -t /srv",
+			ExpectedWarnings: 0,
+		},
+		{
+			Caps:             &Capabilities{Shell: ShellPOSIX, Options: "pqrv"},
+			Command:          Command{Flags: []string{FlagCompress, FlagPreserve, FlagSink}, Path: "/srv"},
+			Expected:         "scp -p -t /srv",
+			ExpectedWarnings: 1,
+		},
+		{
+			// The limit's value is dropped with it
+			Caps:             &Capabilities{Shell: ShellPOSIX, Options: "pqrv"},
+			Command:          Command{Flags: []string{FlagLimit, "8000", FlagSink}, Path: "/srv"},
+			Expected:         "scp -t /srv",
+			ExpectedWarnings: 1,
+		},
+		{
+			Caps:     &Capabilities{Shell: ShellCmd},
+			Command:  SinkCommand(`C:\my files`),
+			Expected: `scp -r -t ^"C:\my files^"`,
+		},
+		{
+			Caps:     &Capabilities{Shell: ShellPowerShell},
+			Command:  SinkCommand(`C:\my files`),
+			Expected: `scp -r -t 'C:\my files'`,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{c: NewClient(nil), caps: v.Caps}
+		if cmd := c.adjustCommand(v.Command).String(); cmd != v.Expected {
+			expectedError(t, cmd, v.Expected)
+		}
+		if len(c.warnings) != v.ExpectedWarnings {
+			expectedError(t, c.warnings, v.ExpectedWarnings)
+		}
+	}
+}
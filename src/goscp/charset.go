@@ -0,0 +1,85 @@
+package goscp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Charset converts names between the encoding of a remote and UTF-8.
+// Encodings of golang.org/x/text fit with a small wrapper around their
+// NewDecoder().String and NewEncoder().String.
+type Charset interface {
+	// Decode a remote name to UTF-8
+	Decode(name string) (string, error)
+
+	// Encode a UTF-8 name for the remote, failing with ErrUnencodable for
+	// characters it has no bytes for
+	Encode(name string) (string, error)
+}
+
+// Latin1 is the ISO-8859-1 charset, where each byte is the code point of
+// the same value.
+var Latin1 Charset = latin1{}
+
+type latin1 struct{}
+
+func (latin1) Decode(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		b.WriteRune(rune(name[i]))
+	}
+	return b.String(), nil
+}
+
+func (latin1) Encode(name string) (string, error) {
+	b := make([]byte, 0, len(name))
+	for _, r := range name {
+		if r > 0xff || r == utf8.RuneError {
+			return "", ErrUnencodable
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// Decode the name of an incoming item with the RemoteCharset option.
+func (t *transfer) decodeName(m *message) error {
+	if t.opts.RemoteCharset == nil {
+		return nil
+	}
+
+	name, err := t.decodeRemote(m.Name)
+	if err != nil {
+		return err
+	}
+
+	m.Name = name
+	return nil
+}
+
+// Decode a name or path from the remote with the RemoteCharset option.
+func (t *transfer) decodeRemote(s string) (string, error) {
+	if t.opts.RemoteCharset == nil {
+		return s, nil
+	}
+
+	decoded, err := t.opts.RemoteCharset.Decode(s)
+	if err != nil {
+		return "", fmt.Errorf("Could not decode name [%q]: %w", s, err)
+	}
+	return decoded, nil
+}
+
+// Encode a name or path for the remote with the RemoteCharset option.
+func (t *transfer) encodeRemote(s string) (string, error) {
+	if t.opts.RemoteCharset == nil {
+		return s, nil
+	}
+
+	encoded, err := t.opts.RemoteCharset.Encode(s)
+	if err != nil {
+		return "", fmt.Errorf("Could not encode [%q] for the remote: %w", s, err)
+	}
+	return encoded, nil
+}
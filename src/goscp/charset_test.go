@@ -0,0 +1,93 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLatin1(t *testing.T) {
+	tests := []struct {
+		UTF8   string
+		Latin1 string
+	}{
+		{UTF8: "plain.txt", Latin1: "plain.txt"},
+		{UTF8: "café.txt", Latin1: "caf\xe9.txt"},
+		{UTF8: "Größe", Latin1: "Gr\xf6\xdfe"},
+	}
+
+	for _, v := range tests {
+		if s, err := Latin1.Encode(v.UTF8); err != nil || s != v.Latin1 {
+			expectedError(t, s, v.Latin1)
+		}
+		if s, err := Latin1.Decode(v.Latin1); err != nil || s != v.UTF8 {
+			expectedError(t, s, v.UTF8)
+		}
+	}
+
+	if _, err := Latin1.Encode("日本"); !errors.Is(err, ErrUnencodable) {
+		expectedError(t, err, ErrUnencodable)
+	}
+}
+
+func TestDownloadCharset(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-charset")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	input := "D0755 0 r\xe9pertoire\nC0644 2 caf\xe9.txt\nhi\x00E\n"
+	c := &transfer{
+		opts:          TransferOptions{RemoteCharset: Latin1},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "répertoire", "café.txt")); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+func TestUploadCharset(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-charset")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "répertoire")
+	os.MkdirAll(root, 0755)
+	ioutil.WriteFile(filepath.Join(root, "café.txt"), []byte("hi"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "日本.txt"), []byte("x"), 0644)
+
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts:          TransferOptions{RemoteCharset: Latin1, SoftFail: true},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	if err := c.sendTree(root); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// Names the charset can't represent are skipped
+	expectedOutput := "D0755 0 r\xe9pertoire\nC0644 2 caf\xe9.txt\nhi\x00E\n"
+	if out.String() != expectedOutput {
+		expectedError(t, out.String(), expectedOutput)
+	}
+	if len(c.errors) != 1 || !errors.Is(c.errors[0], ErrUnencodable) {
+		expectedError(t, c.errors, ErrUnencodable)
+	}
+}
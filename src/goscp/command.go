@@ -0,0 +1,188 @@
+package goscp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Flags of the remote scp command.
+const (
+	// FlagSink makes scp receive files, the remote end of an upload
+	FlagSink = "-t"
+
+	// FlagSource makes scp send files, the remote end of a download
+	FlagSource = "-f"
+
+	// FlagRecursive allows directories to be sent and received
+	FlagRecursive = "-r"
+
+	// FlagPreserve keeps modification and access times, and modes
+	FlagPreserve = "-p"
+
+	// FlagCompress enables compression in the remote scp
+	FlagCompress = "-C"
+
+	// FlagTargetDirectory makes the sink fail unless its path is an
+	// existing directory
+	FlagTargetDirectory = "-d"
+
+	// FlagLimit limits the bandwidth of the remote scp, followed by a
+	// separate argument in Kbit/s
+	FlagLimit = "-l"
+)
+
+// Command builds the remote scp command line of a transfer.
+type Command struct {
+	// Remote program, "scp" when empty
+	Program string
+
+	// Flags in order, e.g. FlagRecursive and FlagSink
+	Flags []string
+
+	// Remote path the command works on
+	Path string
+
+	// Further remote paths after Path, for sources sending several
+	Paths []string
+
+	// Quotes Path and Paths for the remote shell, QuotePOSIX when nil
+	Quote func(string) string
+}
+
+// SinkCommand returns the command uploads run to receive into remotePath.
+func SinkCommand(remotePath string) Command {
+	return Command{Flags: []string{FlagRecursive, FlagSink}, Path: remotePath}
+}
+
+// SourceCommand returns the command downloads run to send remotePath.
+func SourceCommand(remotePath string) Command {
+	return Command{Flags: []string{FlagRecursive, FlagSource}, Path: remotePath}
+}
+
+// String returns the command line, with Path and Paths quoted.
+func (c Command) String() string {
+	program := c.Program
+	if program == "" {
+		program = "scp"
+	}
+
+	parts := append([]string{program}, c.Flags...)
+	return strings.Join(append(parts, c.quotedPath()), " ")
+}
+
+// Path and Paths quoted with Quote, separated by spaces.
+func (c Command) quotedPath() string {
+	quote := c.Quote
+	if quote == nil {
+		quote = QuotePOSIX
+	}
+
+	quoted := quote(c.Path)
+	for _, p := range c.Paths {
+		quoted += " " + quote(p)
+	}
+	return quoted
+}
+
+// Build the command line with the RemoteProgram, RemoteFlags and
+// CommandTemplate options.
+func (t *transfer) customCommand(cmd Command) string {
+	if t.opts.RemoteProgram != "" {
+		cmd.Program = t.opts.RemoteProgram
+	}
+	if len(t.opts.RemoteFlags) > 0 {
+		cmd.Flags = append(append([]string(nil), t.opts.RemoteFlags...), cmd.Flags...)
+	}
+
+	if t.opts.CommandTemplate == "" {
+		return cmd.String()
+	}
+
+	r := strings.NewReplacer("{flags}", strings.Join(cmd.Flags, " "), "{path}", cmd.quotedPath())
+	return r.Replace(t.opts.CommandTemplate)
+}
+
+// Command line for cmd with the options of the transfer applied.
+func (t *transfer) command(cmd Command) string {
+	cmd = t.recursiveFlags(cmd)
+	cmd = t.targetFlags(cmd)
+	cmd = t.preserveFlags(cmd)
+	if t.opts.Compress {
+		cmd.Flags = append([]string{FlagCompress}, cmd.Flags...)
+	}
+	if t.opts.RemoteBandwidthLimit > 0 {
+		limit := strconv.FormatInt(t.opts.RemoteBandwidthLimit, 10)
+		cmd.Flags = append([]string{FlagLimit, limit}, cmd.Flags...)
+	}
+	cmd = t.adjustCommand(cmd)
+
+	return t.sudo(t.customCommand(cmd))
+}
+
+// Run cmd, a POSIX shell command line other than scp, with the Env and Sudo
+// options and return its output. sudo gets the password from SudoPassword
+// up front, as nothing answers its prompt. Refused when the remote shell
+// isn't POSIX.
+func (t *transfer) shellOutput(ctx context.Context, cmd string) ([]byte, error) {
+	if shell := t.remoteShell(); shell != ShellPOSIX {
+		return nil, refusedErrorf("Refusing to run a POSIX command in the remote %s shell: [%q]", shell, cmd)
+	}
+
+	if t.opts.Sudo {
+		// sudo runs a single command, cmd may be several
+		cmd = t.sudo("sh -c " + QuotePOSIX(cmd))
+	}
+
+	return t.c.runRemote(ctx, func(session *ssh.Session) ([]byte, error) {
+		line, err := t.applyEnv(session, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.opts.Sudo && t.opts.SudoPassword != nil {
+			password, err := t.opts.SudoPassword()
+			if err != nil {
+				return nil, err
+			}
+			session.Stdin = strings.NewReader(password + "\n")
+		}
+
+		return session.Output(line)
+	})
+}
+
+// Add the target directory flag to sink commands with the TargetIsDirectory
+// option.
+func (t *transfer) targetFlags(cmd Command) Command {
+	if !t.opts.TargetIsDirectory {
+		return cmd
+	}
+
+	for i, flag := range cmd.Flags {
+		if flag == FlagSink {
+			flags := append([]string(nil), cmd.Flags[:i]...)
+			cmd.Flags = append(append(flags, FlagTargetDirectory), cmd.Flags[i:]...)
+			break
+		}
+	}
+	return cmd
+}
+
+// Drop the recursive flag with the NonRecursive option.
+func (t *transfer) recursiveFlags(cmd Command) Command {
+	if !t.opts.NonRecursive {
+		return cmd
+	}
+
+	flags := make([]string, 0, len(cmd.Flags))
+	for _, flag := range cmd.Flags {
+		if flag != FlagRecursive {
+			flags = append(flags, flag)
+		}
+	}
+	cmd.Flags = flags
+	return cmd
+}
@@ -0,0 +1,210 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		Command  Command
+		Expected string
+	}{
+		{
+			Command:  SinkCommand("/srv/data"),
+			Expected: "scp -r -t /srv/data",
+		},
+		{
+			Command:  SourceCommand("/srv/my data/$HOME"),
+			Expected: "scp -r -f '/srv/my data/$HOME'",
+		},
+		{
+			// Several sources
+			Command: Command{
+				Flags: []string{FlagSource},
+				Path:  "/var/log/syslog",
+				Paths: []string{"/etc/hosts", "/srv/my data"},
+			},
+			Expected: "scp -f /var/log/syslog /etc/hosts '/srv/my data'",
+		},
+		{
+			// Custom program and quoting
+			Command: Command{
+				Program: "/usr/local/bin/scp",
+				Flags:   []string{FlagSink},
+				Path:    `C:\Users\me\my files`,
+				Quote:   QuoteWindows,
+			},
+			Expected: `/usr/local/bin/scp -t "C:\Users\me\my files"`,
+		},
+	}
+
+	for _, v := range tests {
+		if cmd := v.Command.String(); cmd != v.Expected {
+			expectedError(t, cmd, v.Expected)
+		}
+	}
+}
+
+func TestNonRecursive(t *testing.T) {
+	tests := []struct {
+		Options  TransferOptions
+		Command  Command
+		Expected string
+	}{
+		{
+			Command:  SourceCommand("/srv/a.txt"),
+			Expected: "scp -r -f /srv/a.txt",
+		},
+		{
+			Options:  TransferOptions{NonRecursive: true},
+			Command:  SourceCommand("/srv/a.txt"),
+			Expected: "scp -f /srv/a.txt",
+		},
+		{
+			Options:  TransferOptions{NonRecursive: true, PreserveTimes: true},
+			Command:  SinkCommand("/srv"),
+			Expected: "scp -p -t /srv",
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: v.Options}
+		if cmd := c.command(v.Command); cmd != v.Expected {
+			expectedError(t, cmd, v.Expected)
+		}
+	}
+
+	// The remote would refuse a directory, after the files before it
+	tmp, err := ioutil.TempDir("", "goscp-nonrecursive")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts:          TransferOptions{NonRecursive: true},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	if err := c.sendTree(tmp); err == nil {
+		expectedError(t, err, "error for a directory")
+	}
+	if out.Len() != 0 {
+		expectedError(t, out.String(), "")
+	}
+
+	file := filepath.Join(tmp, "a.txt")
+	if err := ioutil.WriteFile(file, []byte("abc"), 0644); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := c.sendTree(file); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if expected := "C0644 3 a.txt\nabc\x00"; out.String() != expected {
+		expectedError(t, out.String(), expected)
+	}
+}
+
+func TestCustomCommand(t *testing.T) {
+	tests := []struct {
+		Options  TransferOptions
+		Command  Command
+		Expected string
+	}{
+		{
+			Options:  TransferOptions{RemoteProgram: "/usr/local/bin/scp"},
+			Command:  SinkCommand("/srv/my data"),
+			Expected: "/usr/local/bin/scp -r -t '/srv/my data'",
+		},
+		{
+			Options:  TransferOptions{RemoteFlags: []string{"-v", "-O"}},
+			Command:  SourceCommand("/srv/a.txt"),
+			Expected: "scp -v -O -r -f /srv/a.txt",
+		},
+		{
+			Options:  TransferOptions{CommandTemplate: "/usr/bin/rssh -c 'scp {flags} {path}'", RemoteFlags: []string{"-q"}},
+			Command:  SinkCommand("/srv"),
+			Expected: "/usr/bin/rssh -c 'scp -q -r -t /srv'",
+		},
+		{
+			// Remote bandwidth limit
+			Options:  TransferOptions{RemoteBandwidthLimit: 8000},
+			Command:  SinkCommand("/srv"),
+			Expected: "scp -l 8000 -r -t /srv",
+		},
+		{
+			// scp -p and -C
+			Options:  TransferOptions{PreserveTimes: true, Compress: true},
+			Command:  SourceCommand("/srv"),
+			Expected: "scp -C -p -r -f /srv",
+		},
+		{
+			Options:  TransferOptions{CommandTemplate: "/usr/local/bin/scp -rt {path}", PreserveTimes: true},
+			Command:  SinkCommand("/srv/it's"),
+			Expected: `/usr/local/bin/scp -rt '/srv/it'\''s'`,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: v.Options}
+		if cmd := c.command(v.Command); cmd != v.Expected {
+			expectedError(t, cmd, v.Expected)
+		}
+	}
+}
+
+func TestTargetIsDirectory(t *testing.T) {
+	tests := []struct {
+		Options  TransferOptions
+		Command  Command
+		Expected string
+	}{
+		{
+			Options:  TransferOptions{TargetIsDirectory: true},
+			Command:  SinkCommand("/srv"),
+			Expected: "scp -r -d -t /srv",
+		},
+		{
+			Options:  TransferOptions{TargetIsDirectory: true, NonRecursive: true},
+			Command:  SinkCommand("/srv"),
+			Expected: "scp -d -t /srv",
+		},
+		{
+			// Downloads have no target on the remote
+			Options:  TransferOptions{TargetIsDirectory: true},
+			Command:  SourceCommand("/srv"),
+			Expected: "scp -r -f /srv",
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: v.Options}
+		if cmd := c.command(v.Command); cmd != v.Expected {
+			expectedError(t, cmd, v.Expected)
+		}
+	}
+
+	// The remote refuses before the first item
+	input := "\x01scp: /srv/report.txt: Not a directory\n"
+	c := &transfer{
+		opts:          TransferOptions{TargetIsDirectory: true},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.handleUpload(func() error {
+		t.Error("Unexpected send")
+		return nil
+	})
+
+	if len(c.errors) != 1 || !errors.Is(c.errors[0], ErrNotDirectory) {
+		expectedError(t, c.errors, ErrNotDirectory)
+	}
+}
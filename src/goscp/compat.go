@@ -0,0 +1,78 @@
+package goscp
+
+import (
+	"strings"
+)
+
+// Quirks relax downloads for servers whose scp deviates from OpenSSH's.
+// Line endings in CRLF are always accepted.
+type Quirks struct {
+	// Accept E messages without their newline. An E is taken as complete
+	// when nothing follows it yet, rather than waiting for a newline that
+	// never comes, a late newline is ignored like any blank line.
+	BareEndDirectory bool
+
+	// Treat lines starting with "scp: " as warnings, for servers that send
+	// them without the leading \x01 byte
+	PlainWarnings bool
+
+	// Shell running remote commands when it isn't found with ProbeRemote,
+	// ShellCmd or ShellPowerShell. Empty for a POSIX shell.
+	Shell string
+}
+
+// QuirksFor returns the quirks needed by an SSH server, from its version
+// string, e.g. "SSH-2.0-dropbear_2022.83" or "OpenSSH_for_Windows_8.1".
+// Other servers need none. Transfers with the ProbeRemote option and no
+// Quirks of their own use it.
+func QuirksFor(server string) Quirks {
+	server = strings.ToLower(server)
+	if strings.Contains(server, "dropbear") || strings.Contains(server, "openssh_for_windows") {
+		return Quirks{BareEndDirectory: true, PlainWarnings: true}
+	}
+	return Quirks{}
+}
+
+// Read the next raw message line of a download.
+func (t *transfer) readMessage() (string, error) {
+	if !t.opts.StrictProtocol {
+		t.skipNuls()
+	}
+
+	if t.opts.Quirks.BareEndDirectory {
+		if b, err := t.scpStdoutPipe.Peek(1); err == nil && b[0] == 'E' {
+			t.scpStdoutPipe.Discard(1)
+
+			// The remote may wait for the ack without sending a newline,
+			// only one that already arrived is read
+			if t.scpStdoutPipe.Buffered() > 0 {
+				if next, _ := t.scpStdoutPipe.Peek(1); next[0] == '\r' || next[0] == '\n' {
+					t.scpStdoutPipe.ReadString('\n')
+				}
+			}
+			return endDir + "\n", nil
+		}
+	}
+
+	return t.scpStdoutPipe.ReadString('\n')
+}
+
+// Rewrite a message with the Quirks option into what OpenSSH sends.
+func (t *transfer) quirkMessage(msg string) string {
+	if t.opts.Quirks.PlainWarnings && strings.HasPrefix(msg, "scp: ") {
+		return "\x01" + msg
+	}
+	return msg
+}
+
+// Drop NUL bytes from the remote before a message, stray acks that would
+// otherwise hide the message type.
+func (t *transfer) skipNuls() {
+	for {
+		b, err := t.scpStdoutPipe.Peek(1)
+		if err != nil || b[0] != 0 {
+			return
+		}
+		t.scpStdoutPipe.Discard(1)
+	}
+}
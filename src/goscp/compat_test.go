@@ -0,0 +1,107 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuirksFor(t *testing.T) {
+	tests := []struct {
+		server   string
+		expected Quirks
+	}{
+		{"SSH-2.0-OpenSSH_9.6", Quirks{}},
+		{"SSH-2.0-dropbear_2022.83", Quirks{BareEndDirectory: true, PlainWarnings: true}},
+		{"SSH-2.0-OpenSSH_for_Windows_8.1", Quirks{BareEndDirectory: true, PlainWarnings: true}},
+		{"", Quirks{}},
+	}
+
+	for _, test := range tests {
+		if q := QuirksFor(test.server); q != test.expected {
+			expectedError(t, q, test.expected)
+		}
+	}
+}
+
+func TestDownloadQuirks(t *testing.T) {
+	tests := []struct {
+		input    string
+		quirks   Quirks
+		errors   int
+		protocol bool
+	}{
+		// Bare E with nothing after it, and with more messages following
+		{"D0755 0 dir\r\nC0644 2 a.txt\r\nhi\x00E", Quirks{BareEndDirectory: true}, 0, false},
+		{"D0755 0 dir\nD0755 0 sub\nEE\nC0644 2 b.txt\nhi\x00", Quirks{BareEndDirectory: true}, 0, false},
+
+		// Warnings without \x01
+		{"D0755 0 dir\nscp: a.txt: Permission denied\nE\n", Quirks{PlainWarnings: true}, 1, false},
+		{"D0755 0 dir\nscp: a.txt: Permission denied\nE\n", Quirks{}, 1, true},
+	}
+
+	for _, test := range tests {
+		tmp, err := ioutil.TempDir("", "goscp-quirks")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		c := &transfer{
+			opts:          TransferOptions{Quirks: test.quirks, ContinueOnError: true},
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(test.input))},
+		}
+		c.path = []string{tmp}
+		c.rootDepth = 1
+		c.handleDownload()
+
+		if len(c.errors) != test.errors {
+			expectedError(t, c.errors, test.errors)
+		}
+		if len(c.errors) > 0 && errors.Is(c.errors[0], ErrProtocol) != test.protocol {
+			expectedError(t, c.errors[0], test.protocol)
+		}
+		if !test.protocol {
+			if _, err := os.Stat(filepath.Join(tmp, "dir")); err != nil {
+				t.Error("Unexpected error:", err)
+			}
+		}
+	}
+}
+
+func TestDownloadFraming(t *testing.T) {
+	tests := []string{
+		"D0755 0 dir\r\nC0644 2 a.txt\r\nhi\x00E\r\n",
+		"\x00\x00D0755 0 dir\n\x00C0644 2 a.txt\nhi\x00\x00\x00E\n\x00",
+		"D0755 0 dir\x00\n\r\n\x00\nC0644 2 a.txt\r\x00\nhi\x00E\n",
+	}
+
+	for _, input := range tests {
+		tmp, err := ioutil.TempDir("", "goscp-framing")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		c := &transfer{
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+		}
+		c.path = []string{tmp}
+		c.rootDepth = 1
+		c.handleDownload()
+
+		if len(c.errors) != 0 {
+			t.Errorf("Unexpected errors for [%q]: %v", input, c.errors)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(tmp, "dir", "a.txt")); err != nil || string(b) != "hi" {
+			t.Errorf("Unexpected content for [%q]: %q, %v", input, b, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package goscp
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Longest remote command used to recreate duplicates, well below common
+// ARG_MAX limits.
+const maxCopyCommandLength = 64 * 1024
+
+// Group the regular files below localPath by content and mode, and with
+// withTimes by modification time, which the copies can't have of their own.
+// Every file matching a file earlier in walk order is returned mapped to
+// that earlier file. Directories excluded by markers are left out, like the
+// upload leaves them out.
+func findDuplicates(localPath string, markers []string, withTimes bool) (map[string]string, error) {
+	bySize := make(map[int64][]string)
+	attrs := make(map[string]string)
+	err := filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Reported by the upload walk itself
+			return nil
+		}
+
+		if info.IsDir() {
+			if _, ok := findMarker(p, markers); ok {
+				return filepath.SkipDir
+			}
+		}
+
+		if info.Mode().IsRegular() && info.Size() > 0 {
+			bySize[info.Size()] = append(bySize[info.Size()], p)
+
+			attrs[p] = fmt.Sprintf("%o", info.Mode()&modeBits)
+			if withTimes {
+				attrs[p] += fmt.Sprintf(" %d", info.ModTime().Unix())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := make(map[string]string)
+	for _, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+
+		byHash := make(map[string]string)
+		for _, p := range paths {
+			sum, err := hashFile(p)
+			if err != nil {
+				// Unreadable files are uploaded, and fail, as usual
+				continue
+			}
+
+			key := sum + attrs[p]
+			if original, ok := byHash[key]; ok {
+				duplicates[p] = original
+			} else {
+				byHash[key] = p
+			}
+		}
+	}
+
+	return duplicates, nil
+}
+
+// Return the SHA-256 of a file's content.
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return string(h.Sum(nil)), nil
+}
+
+// Check if remotePath is an existing directory on the remote host, run
+// like the transfer's other commands.
+func (t *transfer) remoteIsDir(ctx context.Context, remotePath string) (bool, error) {
+	encoded, err := t.encodeRemote(remotePath)
+	if err != nil {
+		return false, err
+	}
+
+	out, err := t.shellOutput(ctx, fmt.Sprintf("if test -d %s; then echo yes; fi", QuotePOSIX(encoded)))
+	if err != nil {
+		return false, fmt.Errorf("Could not check [%q]: %w", remotePath, err)
+	}
+	return strings.TrimSpace(string(out)) == "yes", nil
+}
+
+// Map a local path below localPath to where an upload to remoteDest puts it.
+// When remoteDest doesn't exist the top level item is created as remoteDest.
+func remoteUploadPath(remoteDest string, destIsDir bool, localPath, p string) string {
+	rel, err := filepath.Rel(filepath.Dir(localPath), p)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+
+	if !destIsDir {
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			rel = rel[i+1:]
+		} else {
+			rel = ""
+		}
+	}
+
+	return path.Join(remoteDest, rel)
+}
+
+// Recreate skipped duplicates on the remote host by copying the uploaded
+// originals.
+func (t *transfer) copyDuplicates(ctx context.Context, remoteDest string, destIsDir bool, localPath string) error {
+	commands, err := t.copyCommands(remoteDest, destIsDir, localPath)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batchCommands(commands) {
+		t.outputInfo(fmt.Sprintf("Copying %d duplicates", strings.Count(batch, " && ")+1))
+		if _, err := t.shellOutput(ctx, batch); err != nil {
+			return fmt.Errorf("Could not copy duplicates to [%q]: %w", remoteDest, err)
+		}
+	}
+
+	return nil
+}
+
+// Build one cp command per duplicate, in the remote names the upload sent
+// and keeping times and modes like the upload does.
+func (t *transfer) copyCommands(remoteDest string, destIsDir bool, localPath string) ([]string, error) {
+	cp := "cp"
+	if t.opts.PreserveTimes || t.opts.PreserveMode {
+		cp = "cp -p"
+	}
+
+	// Remote names are normalized like the names the upload sends
+	remotePath := func(p string) (string, error) {
+		target := remoteUploadPath(remoteDest, destIsDir, t.normalizeName(localPath), t.normalizeName(p))
+		return t.encodeRemote(target)
+	}
+
+	paths := make([]string, 0, len(t.duplicates))
+	for p := range t.duplicates {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	commands := make([]string, 0, len(paths))
+	for _, p := range paths {
+		from, err := remotePath(t.duplicates[p])
+		if err != nil {
+			return nil, err
+		}
+		to, err := remotePath(p)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, fmt.Sprintf("%s %s %s", cp, QuotePOSIX(from), QuotePOSIX(to)))
+	}
+	return commands, nil
+}
@@ -0,0 +1,217 @@
+package goscp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscp-dedupe")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"a.txt":       "same content",
+		"b/c.txt":     "same content",
+		"b/d.txt":     "different!!!",
+		"e.txt":       "other",
+		"empty1.txt":  "",
+		"empty2.txt":  "",
+		"z/same.txt":  "same content",
+		"z/other.txt": "other",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	duplicates, err := findDuplicates(root, nil, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := map[string]string{
+		filepath.Join(root, "b/c.txt"):     filepath.Join(root, "a.txt"),
+		filepath.Join(root, "z/same.txt"):  filepath.Join(root, "a.txt"),
+		filepath.Join(root, "z/other.txt"): filepath.Join(root, "e.txt"),
+	}
+	if !reflect.DeepEqual(duplicates, expected) {
+		expectedError(t, duplicates, expected)
+	}
+}
+
+func TestFindDuplicatesAttributes(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscp-dedupe")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	// Same content, but the copies would get the mode and time of a.sh
+	files := []struct {
+		Name  string
+		Mode  os.FileMode
+		Mtime time.Time
+	}{
+		{Name: "a.sh", Mode: 0755, Mtime: time.Unix(1500000000, 0)},
+		{Name: "b.sh", Mode: 0644, Mtime: time.Unix(1500000000, 0)},
+		{Name: "c.sh", Mode: 0755, Mtime: time.Unix(1600000000, 0)},
+	}
+	for _, f := range files {
+		p := filepath.Join(root, f.Name)
+		if err := ioutil.WriteFile(p, []byte("#!/bin/sh"), f.Mode); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		os.Chmod(p, f.Mode)
+		os.Chtimes(p, f.Mtime, f.Mtime)
+	}
+
+	duplicates, err := findDuplicates(root, nil, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := map[string]string{filepath.Join(root, "c.sh"): filepath.Join(root, "a.sh")}
+	if !reflect.DeepEqual(duplicates, expected) {
+		expectedError(t, duplicates, expected)
+	}
+
+	// Times only matter when they are sent
+	duplicates, err = findDuplicates(root, nil, true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(duplicates) != 0 {
+		expectedError(t, duplicates, "no duplicates")
+	}
+}
+
+func TestRemoteUploadPath(t *testing.T) {
+	tests := []struct {
+		RemoteDest string
+		DestIsDir  bool
+		LocalPath  string
+		Path       string
+		Expected   string
+	}{
+		{
+			// Directory uploaded into an existing directory
+			RemoteDest: "/usr/local/src",
+			DestIsDir:  true,
+			LocalPath:  "/home/me/project",
+			Path:       "/home/me/project/assets/logo.png",
+			Expected:   "/usr/local/src/project/assets/logo.png",
+		},
+		{
+			// Directory uploaded as a new directory
+			RemoteDest: "/usr/local/src/copy",
+			DestIsDir:  false,
+			LocalPath:  "/home/me/project",
+			Path:       "/home/me/project/assets/logo.png",
+			Expected:   "/usr/local/src/copy/assets/logo.png",
+		},
+		{
+			// Relative local path
+			RemoteDest: "dest",
+			DestIsDir:  true,
+			LocalPath:  "project",
+			Path:       "project/logo.png",
+			Expected:   "dest/project/logo.png",
+		},
+	}
+
+	for _, v := range tests {
+		output := remoteUploadPath(v.RemoteDest, v.DestIsDir, v.LocalPath, v.Path)
+		if output != v.Expected {
+			expectedError(t, output, v.Expected)
+		}
+	}
+}
+
+func TestHandleItemSkipsDuplicate(t *testing.T) {
+	filePath := "goscp-duplicate.txt"
+	if err := ioutil.WriteFile(filePath, []byte("duplicate"), 0644); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	created = append(created, filePath)
+	stats, _ := os.Stat(filePath)
+
+	// Nothing may be written for a skipped duplicate
+	c := &transfer{
+		scpStdinPipe: nopWriteCloser{nil},
+		duplicates:   map[string]string{filePath: "original.txt"},
+	}
+	if err := c.handleItem(filePath, stats, nil); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+func TestCopyCommands(t *testing.T) {
+	duplicates := map[string]string{
+		"/home/me/project/b.txt":     "/home/me/project/a.txt",
+		"/home/me/project/it's.txt":  "/home/me/project/a.txt",
+		"/home/me/project/sub/c.txt": "/home/me/project/a.txt",
+	}
+
+	tests := []struct {
+		Opts     TransferOptions
+		Expected []string
+	}{
+		{
+			Expected: []string{
+				"cp /dest/project/a.txt /dest/project/b.txt",
+				`cp /dest/project/a.txt '/dest/project/it'\''s.txt'`,
+				"cp /dest/project/a.txt /dest/project/sub/c.txt",
+			},
+		},
+		{
+			// Copies keep times and modes like the upload
+			Opts: TransferOptions{PreserveTimes: true},
+			Expected: []string{
+				"cp -p /dest/project/a.txt /dest/project/b.txt",
+				`cp -p /dest/project/a.txt '/dest/project/it'\''s.txt'`,
+				"cp -p /dest/project/a.txt /dest/project/sub/c.txt",
+			},
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: v.Opts, duplicates: duplicates}
+		commands, err := c.copyCommands("/dest", true, "/home/me/project")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if strings.Join(commands, "\n") != strings.Join(v.Expected, "\n") {
+			expectedError(t, commands, v.Expected)
+		}
+	}
+
+	// Names the remote charset can't hold
+	c := &transfer{
+		opts:       TransferOptions{RemoteCharset: Latin1},
+		duplicates: map[string]string{"/home/me/project/日本.txt": "/home/me/project/a.txt"},
+	}
+	if _, err := c.copyCommands("/dest", true, "/home/me/project"); !errors.Is(err, ErrUnencodable) {
+		expectedError(t, err, ErrUnencodable)
+	}
+}
+
+func TestShellOutputRefused(t *testing.T) {
+	for _, shell := range []string{ShellCmd, ShellPowerShell} {
+		c := &transfer{opts: TransferOptions{Quirks: Quirks{Shell: shell}}}
+		if _, err := c.shellOutput(context.Background(), "test -d /srv"); !errors.Is(err, ErrRefused) {
+			expectedError(t, err, ErrRefused)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package goscp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirStats holds aggregate counts for a directory and everything below it.
+type DirStats struct {
+	// Files transferred
+	Files int
+
+	// Subdirectories transferred
+	Dirs int
+
+	// Bytes of file content transferred
+	Bytes int64
+}
+
+// Directory currently being transferred.
+type dirFrame struct {
+	path  string
+	stats DirStats
+
+	// Local path, mode and times to apply once a downloaded directory is
+	// done
+	localPath string
+	mode      os.FileMode
+	times     *fileTimes
+}
+
+// Track entering a directory, relPath is relative to the transfer root.
+func (t *transfer) enterDir(relPath string) {
+	t.dirStack = append(t.dirStack, dirFrame{path: relPath})
+	t.progress.startDir()
+
+	if t.opts.OnDirStart != nil {
+		t.opts.OnDirStart(relPath)
+	}
+}
+
+// Track leaving the current directory, adding its stats to its parent.
+func (t *transfer) leaveDir() {
+	if len(t.dirStack) == 0 {
+		return
+	}
+
+	f := t.dirStack[len(t.dirStack)-1]
+	t.dirStack = t.dirStack[:len(t.dirStack)-1]
+
+	if len(t.dirStack) > 0 {
+		parent := &t.dirStack[len(t.dirStack)-1]
+		parent.stats.Files += f.stats.Files
+		parent.stats.Dirs += f.stats.Dirs + 1
+		parent.stats.Bytes += f.stats.Bytes
+	}
+
+	if t.opts.OnDirEnd != nil {
+		t.opts.OnDirEnd(f.path, f.stats)
+	}
+}
+
+// Track a file transferred into the current directory.
+func (t *transfer) countFile(size int64) {
+	if len(t.dirStack) > 0 {
+		f := &t.dirStack[len(t.dirStack)-1]
+		f.stats.Files++
+		f.stats.Bytes += size
+	}
+}
+
+// Path of the current download directory relative to the transfer root.
+func (t *transfer) downloadRelPath() string {
+	if t.rootDepth >= len(t.path) {
+		return ""
+	}
+	return strings.Join(t.path[t.rootDepth:], "/")
+}
+
+// Path of a local upload item relative to the transfer root.
+func (t *transfer) uploadRelPath(path string) string {
+	rel, err := filepath.Rel(filepath.Dir(t.uploadRoot), path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,128 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Records directory hook calls.
+type dirEvents []string
+
+func (e *dirEvents) hook(c *transfer) {
+	c.opts.OnDirStart = func(relPath string) {
+		*e = append(*e, "start "+relPath)
+	}
+	c.opts.OnDirEnd = func(relPath string, stats DirStats) {
+		*e = append(*e, fmt.Sprintf("end %s %+v", relPath, stats))
+	}
+}
+
+func TestUploadDirHooks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-hooks")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "root")
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("bb"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "z.txt"), []byte("zzz"), 0644)
+
+	out := &bytes.Buffer{}
+	c := &transfer{scpStdinPipe: nopWriteCloser{out}, scpStdoutPipe: acceptingRemote()}
+
+	var events dirEvents
+	events.hook(c)
+
+	if err := c.sendTree(root); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// z.txt must be sent after leaving sub
+	expectedOutput := "D0755 0 root\n" +
+		"C0644 1 a.txt\na\x00" +
+		"D0755 0 sub\n" +
+		"C0644 2 b.txt\nbb\x00" +
+		"E\n" +
+		"C0644 3 z.txt\nzzz\x00" +
+		"E\n"
+	if out.String() != expectedOutput {
+		expectedError(t, out.String(), expectedOutput)
+	}
+
+	expectedEvents := dirEvents{
+		"start root",
+		"start root/sub",
+		"end root/sub {Files:1 Dirs:0 Bytes:2}",
+		"end root {Files:3 Dirs:1 Bytes:6}",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		expectedError(t, events, expectedEvents)
+	}
+}
+
+func TestUploadSingleFile(t *testing.T) {
+	filePath := "goscp-single.txt"
+	ioutil.WriteFile(filePath, []byte("single"), 0644)
+	created = append(created, filePath)
+
+	out := &bytes.Buffer{}
+	c := &transfer{scpStdinPipe: nopWriteCloser{out}, scpStdoutPipe: acceptingRemote()}
+
+	if err := c.sendTree(filePath); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expectedOutput := "C0644 6 goscp-single.txt\nsingle\x00"
+	if out.String() != expectedOutput {
+		expectedError(t, out.String(), expectedOutput)
+	}
+}
+
+func TestDownloadDirHooks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-hooks")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	c := &transfer{scpStdinPipe: nopWriteCloser{&bytes.Buffer{}}}
+	c.path = []string{tmp}
+	c.startSecurityCheck("/remote/media")
+
+	var events dirEvents
+	events.hook(c)
+
+	c.scpStdoutPipe = &readCanceller{Reader: bufio.NewReader(bytes.NewBufferString("a\x00bbb\x00"))}
+	steps := []func() error{
+		func() error { return c.directory("D0755 0 media") },
+		func() error { return c.file("C0644 1 a.txt") },
+		func() error { return c.directory("D0755 0 sub") },
+		func() error { return c.file("C0644 3 b.txt") },
+		func() error { c.upDirectory(); return nil },
+		func() error { c.upDirectory(); return nil },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	expectedEvents := dirEvents{
+		"start media",
+		"start media/sub",
+		"end media/sub {Files:1 Dirs:0 Bytes:3}",
+		"end media {Files:2 Dirs:1 Bytes:4}",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		expectedError(t, events, expectedEvents)
+	}
+}
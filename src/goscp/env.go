@@ -0,0 +1,57 @@
+package goscp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Names of environment variables that can be set in a POSIX shell.
+var envNameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Lines printed by shells and tools when the requested locale is missing.
+var localeWarningRx = regexp.MustCompile(`^(perl: warning: |[\w.-]+: warning: setlocale: |\s*(LANGUAGE|LANG|LC_[A-Z]+) = |\s*are supported and installed on your system\.|locale: Cannot set )`)
+
+// Apply the Env option to the session. Variables the server refuses, as sshd
+// does for anything not listed in AcceptEnv, are instead set on cmd through
+// the remote shell, which has to be POSIX; other shells leave them unset
+// with a warning. Returns the command to run.
+func (t *transfer) applyEnv(session envSetter, cmd string) (string, error) {
+	names := make([]string, 0, len(t.opts.Env))
+	for name := range t.opts.Env {
+		if !envNameRx.MatchString(name) {
+			return "", fmt.Errorf("Invalid environment variable name: [%q]", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	posix := t.remoteShell() == ShellPOSIX
+	prefix := ""
+	for _, name := range names {
+		value := t.opts.Env[name]
+		if err := session.Setenv(name, value); err == nil {
+			continue
+		}
+
+		if !posix {
+			t.addWarning("", fmt.Sprintf("Server refused %s, and it can't be set in the command for %s", name, t.remoteShell()))
+			continue
+		}
+		t.outputInfo(fmt.Sprintf("Server refused %s, setting it in the command", name))
+		prefix += name + "=" + QuotePOSIX(value) + " "
+	}
+
+	return prefix + cmd, nil
+}
+
+// Check if a line from the remote command is a locale warning rather than
+// a protocol message.
+func isLocaleWarning(line string) bool {
+	return localeWarningRx.MatchString(line)
+}
+
+// Part of ssh.Session used to set environment variables.
+type envSetter interface {
+	Setenv(name, value string) error
+}
@@ -0,0 +1,118 @@
+package goscp
+
+import (
+	"errors"
+	"testing"
+)
+
+// Records environment variables, refusing some like sshd's AcceptEnv.
+type fakeEnvSession struct {
+	accept map[string]bool
+	set    map[string]string
+}
+
+func (s *fakeEnvSession) Setenv(name, value string) error {
+	if !s.accept[name] {
+		return errors.New("ssh: setenv failed")
+	}
+	s.set[name] = value
+	return nil
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		Env             map[string]string
+		Accept          map[string]bool
+		Shell           string
+		ExpectedCommand string
+		ExpectedSet     map[string]string
+		ExpectedError   string
+	}{
+		{
+			// Nothing to set
+			ExpectedCommand: "scp -rf /tmp",
+			ExpectedSet:     map[string]string{},
+		},
+		{
+			// Accepted by the server
+			Env:             map[string]string{"LC_ALL": "C"},
+			Accept:          map[string]bool{"LC_ALL": true},
+			ExpectedCommand: "scp -rf /tmp",
+			ExpectedSet:     map[string]string{"LC_ALL": "C"},
+		},
+		{
+			// Refused by the server
+			Env:             map[string]string{"LC_ALL": "C", "TMPDIR": "/var/my tmp"},
+			Accept:          map[string]bool{"LC_ALL": true},
+			ExpectedCommand: "TMPDIR='/var/my tmp' scp -rf /tmp",
+			ExpectedSet:     map[string]string{"LC_ALL": "C"},
+		},
+		{
+			// Refused, but not set through cmd.exe
+			Env:             map[string]string{"LC_ALL": "C", "TMPDIR": "/var/my tmp"},
+			Accept:          map[string]bool{"LC_ALL": true},
+			Shell:           ShellCmd,
+			ExpectedCommand: "scp -rf /tmp",
+			ExpectedSet:     map[string]string{"LC_ALL": "C"},
+		},
+		{
+			// Would run a command in the prefix
+			Env:           map[string]string{"X=$(reboot) Y": "1"},
+			Accept:        map[string]bool{},
+			ExpectedSet:   map[string]string{},
+			ExpectedError: `Invalid environment variable name: ["X=$(reboot) Y"]`,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: TransferOptions{Env: v.Env, Quirks: Quirks{Shell: v.Shell}}}
+		session := &fakeEnvSession{accept: v.Accept, set: map[string]string{}}
+
+		cmd, err := c.applyEnv(session, "scp -rf /tmp")
+		if v.ExpectedError == "" {
+			if err != nil {
+				t.Error("Unexpected error:", err)
+			}
+		} else if err == nil || err.Error() != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+		if v.Shell != "" && len(c.warnings) != 1 {
+			expectedError(t, c.warnings, "warning for TMPDIR")
+		}
+		if cmd != v.ExpectedCommand {
+			expectedError(t, cmd, v.ExpectedCommand)
+		}
+
+		if len(session.set) != len(v.ExpectedSet) {
+			expectedError(t, session.set, v.ExpectedSet)
+		}
+		for name, value := range v.ExpectedSet {
+			if session.set[name] != value {
+				expectedError(t, session.set[name], value)
+			}
+		}
+	}
+}
+
+func TestIsLocaleWarning(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected bool
+	}{
+		{Input: "perl: warning: Setting locale failed.", Expected: true},
+		{Input: "LC_ALL = (unset),", Expected: true},
+		{Input: "LANG = \"en_US.UTF-8\"", Expected: true},
+		{Input: "are supported and installed on your system.", Expected: true},
+		{Input: "bash: warning: setlocale: LC_ALL: cannot change locale (en_US.UTF-8)", Expected: true},
+		{Input: "-bash: warning: setlocale: LC_CTYPE: cannot change locale (UTF-8)", Expected: true},
+		{Input: "C0644 10 LANG = x", Expected: false},
+		{Input: "D0755 0 perl: warning: ", Expected: false},
+		{Input: "E", Expected: false},
+	}
+
+	for _, v := range tests {
+		if output := isLocaleWarning(v.Input); output != v.Expected {
+			t.Errorf("%q: received: %v, expected: %v", v.Input, output, v.Expected)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package goscp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrRemoteNotFound is wrapped by remote messages reporting a missing
+	// file or directory.
+	ErrRemoteNotFound = errors.New("Remote file not found")
+
+	// ErrPermissionDenied is wrapped by remote messages reporting a
+	// permission problem.
+	ErrPermissionDenied = errors.New("Permission denied")
+
+	// ErrNotDirectory is wrapped by remote messages reporting a path that
+	// isn't a directory, e.g. the destination of an upload with the
+	// TargetIsDirectory option.
+	ErrNotDirectory = errors.New("Not a directory")
+
+	// ErrProtocol is wrapped by errors for messages that don't follow the
+	// SCP protocol.
+	ErrProtocol = errors.New("Protocol error")
+
+	// ErrRefused is wrapped by errors for items rejected by Client.Security.
+	ErrRefused = errors.New("Refused by security policy")
+
+	// ErrCancelled is returned when a transfer is stopped by Cancel.
+	ErrCancelled = errors.New("Transfer cancelled")
+
+	// ErrStartTimeout is returned when the remote scp doesn't respond within
+	// the StartTimeout option.
+	ErrStartTimeout = errors.New("Remote scp did not start")
+
+	// ErrTimeout is returned when a transfer takes longer than the Timeout
+	// option.
+	ErrTimeout = errors.New("Transfer timed out")
+
+	// ErrFileTimeout is reported for files that take longer than the
+	// FileTimeout option.
+	ErrFileTimeout = errors.New("File timed out")
+
+	// ErrStalled is returned when no data moves for longer than the
+	// StallTimeout option.
+	ErrStalled = errors.New("Transfer stalled")
+
+	// ErrClientClosed is returned by transfers started after Client.Close.
+	ErrClientClosed = errors.New("Client closed")
+
+	// ErrInteractivePrompt is returned when the remote command asks for input,
+	// such as a sudo password, instead of speaking the SCP protocol.
+	ErrInteractivePrompt = errors.New("Remote command is waiting for interactive input")
+
+	// ErrUnconfirmed is the result of files sent after a failure with the
+	// PipelineDepth option, which the remote may not have stored.
+	ErrUnconfirmed = errors.New("Not confirmed by the remote")
+
+	// ErrSudoRejected is returned when sudo asks for the password again
+	// after the one from the SudoPassword option.
+	ErrSudoRejected = errors.New("Sudo password rejected")
+
+	// ErrSkipFile can be returned by the BeforeFile option to skip a file.
+	// Its content is read and discarded.
+	ErrSkipFile = errors.New("File skipped")
+
+	// ErrUnencodable is returned by charsets for names they can't represent.
+	ErrUnencodable = errors.New("Not representable in the remote charset")
+)
+
+// Phase of a transfer, reported with errors to the OnError option.
+type Phase string
+
+const (
+	// PhaseStart covers opening the session and starting the remote scp
+	PhaseStart Phase = "start"
+
+	// PhaseWalk covers reading the local tree of an upload
+	PhaseWalk Phase = "walk"
+
+	// PhaseSend covers sending items during an upload
+	PhaseSend Phase = "send"
+
+	// PhaseReceive covers receiving items during a download
+	PhaseReceive Phase = "receive"
+
+	// PhaseFinish covers the remote exit status and work done after the
+	// protocol exchange, such as copying duplicates
+	PhaseFinish Phase = "finish"
+)
+
+// RemoteMessageError is a warning or error message sent by the remote scp
+// process, e.g. "scp: /data: No such file or directory".
+//
+// Known causes can be checked with errors.Is against ErrRemoteNotFound,
+// ErrPermissionDenied and ErrNotDirectory.
+type RemoteMessageError struct {
+	// Fatal is true for error messages and false for warnings
+	Fatal bool
+
+	// Message text as sent by the remote
+	Message string
+}
+
+func (e *RemoteMessageError) Error() string {
+	if e.Fatal {
+		return fmt.Sprintf("Error message: [%q]", e.Message)
+	}
+	return fmt.Sprintf("Warning message: [%q]", e.Message)
+}
+
+// Unwrap returns the known cause of the message, if any.
+func (e *RemoteMessageError) Unwrap() error {
+	switch {
+	case strings.Contains(e.Message, "No such file or directory"):
+		return ErrRemoteNotFound
+	case strings.Contains(e.Message, "Permission denied"):
+		return ErrPermissionDenied
+	case strings.Contains(e.Message, "Not a directory"):
+		return ErrNotDirectory
+	}
+	return nil
+}
+
+// Build a RemoteMessageError from a raw message starting with its type byte.
+func newRemoteMessageError(msg string) *RemoteMessageError {
+	e := &RemoteMessageError{Fatal: strings.HasPrefix(msg, "\x02")}
+	e.Message = strings.TrimSpace(strings.TrimLeft(msg, "\x01\x02"))
+	return e
+}
+
+// RemoteError is returned when the remote scp exits with a non-zero status.
+// Message is the last warning, error or stderr line the remote sent, which
+// usually explains the status.
+//
+// Known causes in Message can be checked with errors.Is as for
+// RemoteMessageError, and the *ssh.ExitError is available with errors.As.
+type RemoteError struct {
+	// Remote path of the transfer
+	Path string
+
+	// Exit status of the remote scp
+	Code int
+
+	// Last message from the remote, empty if it sent none
+	Message string
+
+	// Error returned by the session
+	Err error
+}
+
+func (e *RemoteError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Remote scp failed for [%q] with status %d", e.Path, e.Code)
+	}
+	return fmt.Sprintf("Remote scp failed for [%q] with status %d: %s", e.Path, e.Code, e.Message)
+}
+
+// Unwrap returns the session error and the known cause of Message, if any.
+func (e *RemoteError) Unwrap() []error {
+	errs := []error{e.Err}
+	if cause := (&RemoteMessageError{Message: e.Message}).Unwrap(); cause != nil {
+		errs = append(errs, cause)
+	}
+	return errs
+}
+
+// SizeChangedError reports a file whose size changed while it was being
+// uploaded. The remote copy keeps the announced size: it is cut short when
+// the file grew and padded with zero bytes when it shrank.
+type SizeChangedError struct {
+	// Local path of the file
+	Path string
+
+	// Size announced to the remote and sent
+	Size int64
+
+	// Size of the file once sending finished
+	CurrentSize int64
+}
+
+func (e *SizeChangedError) Error() string {
+	return fmt.Sprintf("File changed size during upload: [%q], sent %d bytes, now %d", e.Path, e.Size, e.CurrentSize)
+}
+
+// Error with its own text that wraps one of the sentinel errors.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// Error for an item that can be skipped with the ContinueOnError option
+// without losing the protocol state.
+type skippableError struct {
+	err error
+}
+
+func (e *skippableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *skippableError) Unwrap() error {
+	return e.err
+}
+
+// Return the cause of a skippable error, other errors as they are.
+func skipCause(err error) error {
+	if skippable, ok := err.(*skippableError); ok {
+		return skippable.err
+	}
+	return err
+}
+
+// Build a protocol error.
+func protocolErrorf(format string, a ...interface{}) error {
+	return &kindError{msg: fmt.Sprintf(format, a...), kind: ErrProtocol}
+}
+
+// Build a security policy error.
+func refusedErrorf(format string, a ...interface{}) error {
+	return &kindError{msg: fmt.Sprintf(format, a...), kind: ErrRefused}
+}
@@ -0,0 +1,210 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRemoteMessageError(t *testing.T) {
+	tests := []struct {
+		Input         string
+		ExpectedError string
+		ExpectedKind  error
+	}{
+		{
+			Input:         "\x01scp: /data/missing: No such file or directory",
+			ExpectedError: `Warning message: ["scp: /data/missing: No such file or directory"]`,
+			ExpectedKind:  ErrRemoteNotFound,
+		},
+		{
+			Input:         "\x01scp: /root/secret: Permission denied",
+			ExpectedError: `Warning message: ["scp: /root/secret: Permission denied"]`,
+			ExpectedKind:  ErrPermissionDenied,
+		},
+		{
+			Input:         "\x01scp: /srv/report.txt: Not a directory",
+			ExpectedError: `Warning message: ["scp: /srv/report.txt: Not a directory"]`,
+			ExpectedKind:  ErrNotDirectory,
+		},
+		{
+			Input:         "\x02scp: protocol error: unexpected <newline>",
+			ExpectedError: `Error message: ["scp: protocol error: unexpected <newline>"]`,
+		},
+	}
+
+	for _, v := range tests {
+		err := error(newRemoteMessageError(v.Input))
+		if err.Error() != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+
+		if v.ExpectedKind != nil && !errors.Is(err, v.ExpectedKind) {
+			expectedError(t, err, v.ExpectedKind)
+		}
+
+		var remoteErr *RemoteMessageError
+		if !errors.As(err, &remoteErr) {
+			expectedError(t, err, "*RemoteMessageError")
+		}
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	c := &transfer{}
+
+	// Unparseable message
+	_, err := c.parseMessage("Cfoo")
+	if !errors.Is(err, ErrProtocol) {
+		expectedError(t, err, ErrProtocol)
+	}
+
+	// Security policy
+	err = c.checkMessage(message{Type: 'C', Name: "../escape"})
+	if !errors.Is(err, ErrRefused) {
+		expectedError(t, err, ErrRefused)
+	}
+
+	// Cancelled read
+	r := &readCanceller{
+		Reader: bufio.NewReader(&bytes.Buffer{}),
+		cancel: make(chan struct{}),
+	}
+	close(r.cancel)
+	if _, err := r.Read(make([]byte, 1)); err != ErrCancelled {
+		expectedError(t, err, ErrCancelled)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	type event struct {
+		RelPath string
+		Phase   Phase
+		Kind    error
+	}
+	var events []event
+
+	c := &transfer{
+		opts: TransferOptions{
+			OnError: func(relPath string, phase Phase, err error) {
+				events = append(events, event{relPath, phase, errors.Unwrap(err)})
+			},
+		},
+		phase:         PhaseStart,
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(bytes.NewBufferString("C0644 5 ../evil\n"))},
+	}
+	c.handleDownload()
+
+	expected := []event{{"../evil", PhaseReceive, ErrRefused}}
+	if !reflect.DeepEqual(events, expected) {
+		expectedError(t, events, expected)
+	}
+}
+
+func TestWrappedErrors(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-wrap")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		Input    string
+		Cause    error
+		Contains string
+	}{
+		{
+			// Connection lost in the middle of a file
+			Input:    "C0644 5 short.txt\nab",
+			Cause:    io.ErrUnexpectedEOF,
+			Contains: filepath.Join(tmp, "short.txt"),
+		},
+		{
+			// Destination can't be created
+			Input:    "C0644 5 missing/file.txt\nabcde\x00",
+			Cause:    os.ErrNotExist,
+			Contains: filepath.Join(tmp, "missing", "file.txt"),
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{
+			opts:          TransferOptions{Security: PermissiveSecurity()},
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(bytes.NewBufferString(v.Input))},
+		}
+		c.path = []string{tmp}
+		c.handleDownload()
+
+		if len(c.errors) != 1 {
+			t.Fatal("Unexpected errors:", c.errors)
+		}
+		err := c.errors[0]
+		if !errors.Is(err, v.Cause) {
+			expectedError(t, err, v.Cause)
+		}
+		if !strings.Contains(err.Error(), v.Contains) {
+			expectedError(t, err, v.Contains)
+		}
+	}
+
+	// Remote failures keep the remote path and the underlying error
+	cause := errors.New("exit status 1")
+	err = (&transfer{}).sessionError(context.Background(), cause, "/srv/data")
+	if !errors.Is(err, cause) || !strings.Contains(err.Error(), "/srv/data") {
+		expectedError(t, err, cause)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (&transfer{}).sessionError(ctx, ctx.Err(), "/srv/data"); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	c := &transfer{}
+	c.setRemoteText("scp: /srv/data: No such file or directory\n")
+
+	exitErr := &ssh.ExitError{}
+	err := c.sessionError(context.Background(), exitErr, "/srv/data")
+
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatal("Unexpected error:", err)
+	}
+	if remoteErr.Message != "scp: /srv/data: No such file or directory" {
+		expectedError(t, remoteErr.Message, "scp: /srv/data: No such file or directory")
+	}
+	if !errors.Is(err, ErrRemoteNotFound) {
+		expectedError(t, err, ErrRemoteNotFound)
+	}
+
+	var gotExit *ssh.ExitError
+	if !errors.As(err, &gotExit) || gotExit != exitErr {
+		expectedError(t, err, exitErr)
+	}
+
+	// Remote messages replace earlier stderr output
+	c.remoteMessage("\x01scp: /srv/other: Permission denied\n")
+	err = c.sessionError(context.Background(), exitErr, "/srv/data")
+	if !errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrRemoteNotFound) {
+		expectedError(t, err, ErrPermissionDenied)
+	}
+
+	expected := `Remote scp failed for ["/srv/data"] with status 0: scp: /srv/other: Permission denied`
+	if err.Error() != expected {
+		expectedError(t, err.Error(), expected)
+	}
+}
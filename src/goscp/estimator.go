@@ -0,0 +1,141 @@
+package goscp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HostStats keeps the throughput of past transfers per host, to estimate
+// how long new ones will take. It is safe for concurrent use, and can be
+// kept between runs with Save and LoadHostStats.
+type HostStats struct {
+	mu    sync.Mutex
+	hosts map[string]HostThroughput
+}
+
+// HostThroughput sums up the successful transfers with a host.
+type HostThroughput struct {
+	Transfers int
+	Bytes     int64
+	Elapsed   time.Duration
+}
+
+// BytesPerSecond returns the average throughput.
+func (h HostThroughput) BytesPerSecond() float64 {
+	if h.Elapsed <= 0 {
+		return 0
+	}
+	return float64(h.Bytes) / h.Elapsed.Seconds()
+}
+
+// TransferPlan describes a transfer to estimate.
+type TransferPlan struct {
+	// Host as recorded, the SSH server's address e.g. "10.0.0.2:22"
+	Host string
+
+	// Bytes of file content to transfer
+	Bytes int64
+}
+
+// NewHostStats returns empty statistics.
+func NewHostStats() *HostStats {
+	return &HostStats{hosts: make(map[string]HostThroughput)}
+}
+
+// LoadHostStats reads statistics saved with Save. A missing file gives
+// empty statistics.
+func LoadHostStats(path string) (*HostStats, error) {
+	s := NewHostStats()
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.hosts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the statistics to path, replacing it atomically.
+func (s *HostStats) Save(path string) error {
+	s.mu.Lock()
+	b, err := json.MarshalIndent(s.hosts, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Host returns the statistics of host.
+func (s *HostStats) Host(host string) (HostThroughput, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[host]
+	return h, ok
+}
+
+// Record a finished transfer with host.
+func (s *HostStats) Record(host string, r TransferReport) {
+	if r.Bytes <= 0 || r.Elapsed <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hosts == nil {
+		s.hosts = make(map[string]HostThroughput)
+	}
+
+	h := s.hosts[host]
+	h.Transfers++
+	h.Bytes += r.Bytes
+	h.Elapsed += r.Elapsed
+	s.hosts[host] = h
+}
+
+// EstimateDuration returns how long plan should take at the average
+// throughput of past transfers with its host. ok is false when there is no
+// history for the host.
+func (s *HostStats) EstimateDuration(plan TransferPlan) (d time.Duration, ok bool) {
+	h, ok := s.Host(plan.Host)
+	if !ok || h.Bytes <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(float64(h.Elapsed) * float64(plan.Bytes) / float64(h.Bytes)), true
+}
+
+// Address of the client's SSH server, used as the statistics key.
+func (c *Client) statsHost() string {
+	if c.SSHClient == nil {
+		return ""
+	}
+	return c.SSHClient.RemoteAddr().String()
+}
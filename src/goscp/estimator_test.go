@@ -0,0 +1,73 @@
+package goscp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEstimateDuration(t *testing.T) {
+	s := NewHostStats()
+
+	if _, ok := s.EstimateDuration(TransferPlan{Host: "10.0.0.2:22", Bytes: 100}); ok {
+		expectedError(t, ok, false)
+	}
+
+	s.Record("10.0.0.2:22", TransferReport{Bytes: 1000, Elapsed: time.Second})
+	s.Record("10.0.0.2:22", TransferReport{Bytes: 3000, Elapsed: time.Second})
+
+	// Nothing to learn from empty transfers
+	s.Record("10.0.0.2:22", TransferReport{Elapsed: time.Second})
+
+	d, ok := s.EstimateDuration(TransferPlan{Host: "10.0.0.2:22", Bytes: 10000})
+	if !ok || d != 5*time.Second {
+		expectedError(t, d, 5*time.Second)
+	}
+
+	h, _ := s.Host("10.0.0.2:22")
+	if h.Transfers != 2 || h.BytesPerSecond() != 2000 {
+		expectedError(t, h, "2 transfers at 2000 B/s")
+	}
+
+	if _, ok := s.EstimateDuration(TransferPlan{Host: "10.0.0.3:22", Bytes: 100}); ok {
+		expectedError(t, ok, false)
+	}
+}
+
+func TestHostStatsSave(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-stats")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "stats.json")
+
+	// Missing file
+	s, err := LoadHostStats(path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	s.Record("10.0.0.2:22", TransferReport{Bytes: 1000, Elapsed: time.Second})
+	if err := s.Save(path); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	loaded, err := LoadHostStats(path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := HostThroughput{Transfers: 1, Bytes: 1000, Elapsed: time.Second}
+	if h, _ := loaded.Host("10.0.0.2:22"); h != expected {
+		expectedError(t, h, expected)
+	}
+
+	// No temporary file left behind
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 1 {
+		expectedError(t, len(files), 1)
+	}
+}
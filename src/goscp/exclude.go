@@ -0,0 +1,17 @@
+package goscp
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Return the first of markers present in dir. Markers are checked by name
+// only, so CACHEDIR.TAG files without the standard signature also count.
+func findMarker(dir string, markers []string) (string, bool) {
+	for _, marker := range markers {
+		if _, err := os.Lstat(filepath.Join(dir, marker)); err == nil {
+			return marker, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,58 @@
+package goscp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExcludeMarkers(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscp-exclude")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"a.txt":              "same",
+		"cache/CACHEDIR.TAG": "Signature: 8a477f597d28d172789f06886806bc55",
+		"cache/blob":         "same",
+		"keep/b.txt":         "bb",
+		"keep/tmp/.nobackup": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	markers := []string{".nobackup", "CACHEDIR.TAG"}
+
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts:          TransferOptions{ExcludeMarkers: markers},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	if err := c.sendTree(root); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := "D0700 0 " + filepath.Base(root) + "\nC0644 4 a.txt\nsame\x00D0755 0 keep\nC0644 2 b.txt\nbb\x00E\nE\n"
+	if out.String() != expected {
+		expectedError(t, out.String(), expected)
+	}
+
+	// Excluded files are left out of duplicate detection
+	duplicates, err := findDuplicates(root, markers, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !reflect.DeepEqual(duplicates, map[string]string{}) {
+		expectedError(t, duplicates, map[string]string{})
+	}
+}
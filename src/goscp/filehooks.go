@@ -0,0 +1,90 @@
+package goscp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileHeader describes a file announced by the remote host during a
+// download, before its content is read.
+type FileHeader struct {
+	// Path relative to the transfer root
+	RelPath string
+
+	// Size and mode as sent by the remote
+	Size int64
+	Mode os.FileMode
+
+	// Local path the file is written to, change it to redirect the file
+	LocalPath string
+
+	// Receives the content instead of LocalPath when set
+	Target Downloadable
+
+	// Reserve Size bytes on disk before writing, which fails early when
+	// there isn't enough space. Defaults to the Preallocate option.
+	Preallocate bool
+}
+
+// Run the SanitizeName option on the name of an incoming item.
+func (t *transfer) sanitizeName(m *message) error {
+	if t.opts.SanitizeName == nil {
+		return nil
+	}
+
+	name, err := t.opts.SanitizeName(m.Name)
+	if err == ErrSkipFile {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Name [%q] refused: %w", m.Name, err)
+	}
+
+	if name != m.Name {
+		m.SentName = m.Name
+	}
+	m.Name = name
+	return nil
+}
+
+// Run the BeforeFile option for an incoming file.
+func (t *transfer) beforeFile(m message, relPath string) (*FileHeader, error) {
+	h := &FileHeader{
+		RelPath:     relPath,
+		Size:        m.Length,
+		Mode:        m.Mode,
+		LocalPath:   filepath.Join(t.path...) + string(filepath.Separator) + m.Name,
+		Preallocate: t.opts.Preallocate,
+	}
+	if t.stream != nil {
+		h.Target = t.stream
+	}
+	if t.tar != nil {
+		h.Target = t.tar
+	}
+
+	if t.opts.BeforeFile == nil {
+		return h, nil
+	}
+
+	return h, t.opts.BeforeFile(h)
+}
+
+// Read and discard the content of a skipped file.
+func (t *transfer) discardFile(m message) error {
+	t.outputInfo(fmt.Sprintf("Skipping file: %s", m.Name))
+	t.sendAck(t.scpStdinPipe)
+
+	if _, err := io.CopyN(ioutil.Discard, t.scpStdoutPipe, m.Length); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return err
+	}
+
+	t.sendAck(t.scpStdinPipe)
+	return nil
+}
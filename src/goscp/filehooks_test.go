@@ -0,0 +1,245 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBeforeFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-before")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	errStop := errors.New("stop")
+	var headers []string
+
+	out := &bytes.Buffer{}
+	input := "C0644 3 skip.txt\naaa\x00C0600 2 move.txt\nbb\x00C0644 1 stop.txt\nc\x00"
+	c := &transfer{
+		opts: TransferOptions{
+			BeforeFile: func(h *FileHeader) error {
+				headers = append(headers, h.RelPath)
+
+				switch h.RelPath {
+				case "skip.txt":
+					return ErrSkipFile
+				case "move.txt":
+					if h.Size != 2 || h.Mode != 0600 {
+						expectedError(t, h, "size 2, mode 0600")
+					}
+					h.LocalPath = filepath.Join(tmp, "moved.txt")
+					h.Preallocate = true
+				case "stop.txt":
+					return errStop
+				}
+				return nil
+			},
+		},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	expectedHeaders := "skip.txt move.txt stop.txt"
+	if strings.Join(headers, " ") != expectedHeaders {
+		expectedError(t, headers, expectedHeaders)
+	}
+
+	// Initial ack, then two for each received file
+	expectedOutput := "\x00\x00\x00\x00\x00"
+	if out.String() != expectedOutput {
+		expectedError(t, out.String(), expectedOutput)
+	}
+
+	if len(c.errors) != 1 || c.errors[0] != errStop {
+		expectedError(t, c.errors, errStop)
+	}
+
+	if content, _ := ioutil.ReadFile(filepath.Join(tmp, "moved.txt")); string(content) != "bb" {
+		expectedError(t, string(content), "bb")
+	}
+	for _, name := range []string{"skip.txt", "move.txt", "stop.txt"} {
+		if _, err := os.Stat(filepath.Join(tmp, name)); err == nil {
+			expectedError(t, name, "not created")
+		}
+	}
+
+	if len(c.results) != 3 || c.results[0].Err != ErrSkipFile || c.results[1].Err != nil {
+		expectedError(t, c.results, "skipped, received, stopped")
+	}
+}
+
+func TestPreopenedFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-preopened")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	pre, err := os.Create(filepath.Join(tmp, "pre-opened"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var opened []string
+	input := "C0644 3 a.txt\naaa\x00C0644 2 b.txt\nbb\x00C0644 1 c.txt\nc\x00"
+	c := &transfer{
+		opts: TransferOptions{
+			PreopenedFiles: map[string]*os.File{"a.txt": pre},
+			OpenFile: func(h *FileHeader) (*os.File, error) {
+				opened = append(opened, h.RelPath)
+				if h.RelPath == "c.txt" {
+					return nil, nil
+				}
+				return os.Create(filepath.Join(tmp, "factory-"+h.RelPath))
+			},
+		},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if strings.Join(opened, " ") != "b.txt c.txt" {
+		expectedError(t, opened, "b.txt c.txt")
+	}
+
+	expected := map[string]string{
+		"pre-opened":    "aaa",
+		"factory-b.txt": "bb",
+		"c.txt":         "c",
+	}
+	for name, content := range expected {
+		if got, _ := ioutil.ReadFile(filepath.Join(tmp, name)); string(got) != content {
+			expectedError(t, string(got), content)
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(tmp, name)); err == nil {
+			expectedError(t, name, "not created")
+		}
+	}
+
+	// Received files are closed
+	if err := pre.Close(); err == nil {
+		expectedError(t, err, os.ErrClosed)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-sanitize")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	errReserved := errors.New("reserved name")
+	var names []string
+
+	out := &bytes.Buffer{}
+	input := "D0755 0 media\nC0644 2 bell\a.txt\nab\x00C0644 1 .hidden\nc\x00" +
+		"D0755 0 .git\nE\nC0644 1 CON\nf\x00"
+	c := &transfer{
+		opts: TransferOptions{
+			SanitizeName: func(name string) (string, error) {
+				names = append(names, name)
+
+				switch {
+				case strings.HasPrefix(name, "."):
+					return "", ErrSkipFile
+				case name == "CON":
+					return "", errReserved
+				}
+				return strings.Map(func(r rune) rune {
+					if r < ' ' {
+						return -1
+					}
+					return r
+				}, name), nil
+			},
+		},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	// The remote leaves out the content of the skipped directory
+	expectedNames := []string{"media", "bell\a.txt", ".hidden", ".git", "CON"}
+	if strings.Join(names, " ") != strings.Join(expectedNames, " ") {
+		expectedError(t, names, expectedNames)
+	}
+
+	if len(c.errors) != 1 || !errors.Is(c.errors[0], errReserved) {
+		expectedError(t, c.errors, errReserved)
+	}
+	if !strings.Contains(out.String(), "\x01scp: .git: skipped\n") {
+		expectedError(t, out.String(), "warning for .git")
+	}
+
+	if content, _ := ioutil.ReadFile(filepath.Join(tmp, "media", "bell.txt")); string(content) != "ab" {
+		expectedError(t, string(content), "ab")
+	}
+	for _, name := range []string{".hidden", ".git"} {
+		if _, err := os.Stat(filepath.Join(tmp, "media", name)); err == nil {
+			expectedError(t, name, "not created")
+		}
+	}
+}
+
+func TestSanitizeNameExpected(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-sanitize")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The requested name is checked as sent, before it's lowercased
+	input := "D0755 0 Media\nC0644 2 A.txt\nab\x00E\n"
+	c := &transfer{
+		opts: TransferOptions{
+			SanitizeName: func(name string) (string, error) {
+				return strings.ToLower(name), nil
+			},
+		},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.startSecurityCheck("/srv/Media")
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(tmp, "media", "a.txt")); string(content) != "ab" {
+		expectedError(t, string(content), "ab")
+	}
+
+	// A name the remote wasn't asked for is still refused
+	input = "C0644 2 other.txt\nab\x00"
+	c.scpStdoutPipe = &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))}
+	c.errors = nil
+	c.path = []string{tmp}
+	c.startSecurityCheck("/srv/Media")
+	c.handleDownload()
+
+	if len(c.errors) != 1 || !errors.Is(c.errors[0], ErrRefused) {
+		expectedError(t, c.errors, ErrRefused)
+	}
+}
@@ -0,0 +1,72 @@
+package goscp
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Result of a read running in the background.
+type readResult struct {
+	n   int
+	err error
+}
+
+// Fails reads once a file has taken longer than its timeout, e.g. when it is
+// behind a dead network mount. Reads run in the background so a blocked read
+// can be abandoned; it then finishes on its own, or never.
+type timeoutReader struct {
+	r        io.Reader
+	path     string
+	timeout  time.Duration
+	deadline time.Time
+
+	// Read buffer, owned by the background read while one runs
+	buf []byte
+
+	// Set once a read has been abandoned
+	timedOut bool
+}
+
+func newTimeoutReader(r io.Reader, path string, timeout time.Duration) *timeoutReader {
+	return &timeoutReader{
+		r:        r,
+		path:     path,
+		timeout:  timeout,
+		deadline: time.Now().Add(timeout),
+	}
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	remaining := time.Until(r.deadline)
+	if r.timedOut || remaining <= 0 {
+		return 0, r.err()
+	}
+
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := r.r.Read(buf)
+		done <- readResult{n, err}
+	}()
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		copy(p, buf[:res.n])
+		return res.n, res.err
+	case <-timer.C:
+		r.timedOut = true
+		return 0, r.err()
+	}
+}
+
+func (r *timeoutReader) err() error {
+	return fmt.Errorf("%w: [%q] took longer than %s", ErrFileTimeout, r.path, r.timeout)
+}
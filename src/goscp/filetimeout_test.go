@@ -0,0 +1,91 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns its data, then blocks like a read from a dead network mount.
+type stuckReader struct {
+	data  io.Reader
+	block chan struct{}
+}
+
+func (r *stuckReader) Read(p []byte) (int, error) {
+	if n, _ := r.data.Read(p); n > 0 {
+		return n, nil
+	}
+	<-r.block
+	return 0, io.EOF
+}
+
+func TestFileTimeoutUpload(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts:          TransferOptions{FileTimeout: 20 * time.Millisecond},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+
+	r := &stuckReader{data: strings.NewReader("ab"), block: block}
+	err := c.sendStream(r, "stuck.txt", "stuck.txt", "stuck.txt", 0644, 5)
+	if !errors.Is(err, ErrFileTimeout) {
+		expectedError(t, err, ErrFileTimeout)
+	}
+
+	// Padded to the announced size, then failed instead of acknowledged
+	expectedOutput := "C0644 5 stuck.txt\nab\x00\x00\x00\x01scp: " + err.Error() + "\n"
+	if out.String() != expectedOutput {
+		expectedError(t, out.String(), expectedOutput)
+	}
+}
+
+func TestFileTimeoutDownload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-timeout")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	remote := &stuckReader{data: strings.NewReader("C0644 5 a.txt\nab"), block: block}
+	c := (&Client{}).startTransfer(TransferOptions{
+		FileTimeout: 20 * time.Millisecond,
+		Security:    Security{AllowUnexpectedNames: true},
+	})
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.scpStdinPipe = nopWriteCloser{&bytes.Buffer{}}
+	c.scpStdoutPipe = &readCanceller{Reader: bufio.NewReader(remote), cancel: c.cancelled}
+
+	done := make(chan struct{})
+	go func() {
+		c.handleDownload()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Download didn't give up on the file")
+	}
+
+	if len(c.errors) == 0 || !errors.Is(c.errors[0], ErrFileTimeout) {
+		expectedError(t, c.errors, ErrFileTimeout)
+	}
+	if !c.isCancelled() {
+		expectedError(t, "running", "cancelled")
+	}
+}
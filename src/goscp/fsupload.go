@@ -0,0 +1,182 @@
+package goscp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// UploadFS uploads root from fsys to c.DestinationPath like Upload does for
+// a local path, e.g. assets of an embed.FS. root is a slash separated path
+// of fsys, "." sends the entries of fsys without a directory of its own.
+// Files are sent with mode 0644 and directories with 0755, unless the
+// FSModes option is set.
+func (c *Client) UploadFS(ctx context.Context, fsys fs.FS, root string) error {
+	return c.StartUploadFS(ctx, fsys, root, c.TransferOptions).Wait()
+}
+
+// StartUploadFS starts uploading root from fsys with opts in the background
+// and returns a handle to supervise it. Files without a modification time,
+// like those of embed.FS, are sent without times with PreserveTimes.
+func (c *Client) StartUploadFS(ctx context.Context, fsys fs.FS, root string, opts TransferOptions) *Transfer {
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.uploadFS(ctx, fsys, root)
+	})
+}
+
+func (t *transfer) uploadFS(ctx context.Context, fsys fs.FS, root string) {
+	if !fs.ValidPath(root) {
+		t.addError(fmt.Errorf("Invalid path in filesystem: [%q]", root))
+		return
+	}
+
+	session, err := t.openSession()
+	if err != nil {
+		t.addError(err)
+		return
+	}
+	defer session.Close()
+
+	remoteDest := filepath.Join(t.opts.DestinationPath...)
+	t.runUpload(ctx, session, remoteDest, func() error {
+		return t.sendFS(fsys, root)
+	})
+}
+
+// Send root of fsys, directories with everything below them.
+func (t *transfer) sendFS(fsys fs.FS, root string) error {
+	t.path = nil
+	t.dirStack = nil
+	t.traceLast = time.Time{}
+
+	if root != "." {
+		return t.sendFSItem(fsys, root, path.Base(root))
+	}
+
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return fmt.Errorf("Could not read [%q]: %w", root, err)
+	}
+	for _, entry := range entries {
+		if err := t.sendFSItem(fsys, entry.Name(), entry.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send the file or directory name of fsys, relPath names it in results
+// and hooks.
+func (t *transfer) sendFSItem(fsys fs.FS, name, relPath string) error {
+	if t.isCancelled() {
+		return ErrCancelled
+	}
+	t.setPhase(PhaseSend, relPath)
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return t.fsError(relPath, err)
+	}
+
+	switch {
+	case info.IsDir():
+		return t.sendFSDir(fsys, name, relPath, info)
+	case !info.Mode().IsRegular():
+		t.addWarning(relPath, "Skipped "+fileTypeName(info.Mode()))
+		t.countSkipped()
+		return nil
+	}
+
+	start := time.Now()
+	err = t.sendFSFile(fsys, name, relPath, info)
+	t.addResult(relPath, info.Size(), time.Since(start), skipCause(err))
+
+	return t.uploadFailure(err)
+}
+
+// Send a directory message for name, its entries, then the end of the
+// directory.
+func (t *transfer) sendFSDir(fsys fs.FS, name, relPath string, info fs.FileInfo) error {
+	if t.opts.NonRecursive {
+		return fmt.Errorf("Could not upload [%q]: directories can't be sent with NonRecursive", name)
+	}
+
+	remoteName, err := t.outgoingName(path.Base(name))
+	if err != nil {
+		return err
+	}
+	if err := t.sendFSTimes(name, info); err != nil {
+		return err
+	}
+	t.sendDirectoryMessage(t.scpStdinPipe, t.fsMode(info), remoteName)
+	if err := t.expectAck(pendingAck{action: fmt.Sprintf("create directory [%q]", name)}); err != nil {
+		return err
+	}
+	t.enterDir(relPath)
+
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		if err := t.fsError(relPath, err); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if err := t.sendFSItem(fsys, path.Join(name, entry.Name()), path.Join(relPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return t.endDirectory()
+}
+
+// Send the regular file name.
+func (t *transfer) sendFSFile(fsys fs.FS, name, relPath string, info fs.FileInfo) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return &skippableError{err}
+	}
+	defer f.Close()
+
+	if err := t.sendFSTimes(name, info); err != nil {
+		return err
+	}
+
+	return t.sendStream(f, name, path.Base(name), relPath, t.fsMode(info), info.Size())
+}
+
+// Mode to send for an item of a filesystem, with the FSModes option.
+func (t *transfer) fsMode(info fs.FileInfo) os.FileMode {
+	switch {
+	case t.opts.FSModes:
+		return info.Mode() & modeBits
+	case info.IsDir():
+		return 0755
+	}
+	return 0644
+}
+
+// Send the times of name with the PreserveTimes option, if it has any.
+func (t *transfer) sendFSTimes(name string, info fs.FileInfo) error {
+	if !t.opts.PreserveTimes || info.ModTime().IsZero() {
+		return nil
+	}
+	return t.sendTimes(name, info)
+}
+
+// Handle an error reading the filesystem like a local walk error, which
+// skips the item unless StopOnOSError is set.
+func (t *transfer) fsError(relPath string, err error) error {
+	t.setPhase(PhaseWalk, relPath)
+	t.outputInfo(fmt.Sprintf("Item error: %s", err))
+
+	if t.opts.StopOnOSError {
+		return err
+	}
+	t.addWarning(relPath, fmt.Sprintf("Skipped after error: %s", err))
+	t.countSkipped()
+	return nil
+}
@@ -0,0 +1,90 @@
+package goscp
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSendFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/index.html":   {Data: []byte("<p>"), Mode: 0644},
+		"assets/css/site.css": {Data: []byte("p{}"), Mode: 0600},
+		"assets/img":          {Mode: 0755 | fs.ModeDir},
+		"assets/img/logo.svg": {Data: []byte("svg"), Mode: 0644, ModTime: time.Unix(1700000000, 0)},
+		"assets/css":          {Mode: 0750 | fs.ModeDir},
+		"assets":              {Mode: 0755 | fs.ModeDir},
+		"robots.txt":          {Data: []byte("*"), Mode: 0644},
+	}
+
+	// Modes of embed.FS, read-only
+	embedded := fstest.MapFS{
+		"static":         {Mode: 0555 | fs.ModeDir},
+		"static/app.js":  {Data: []byte("js"), Mode: 0444},
+		"static/js":      {Mode: 0555 | fs.ModeDir},
+		"static/js/a.js": {Data: []byte("a"), Mode: 0444},
+	}
+
+	tests := []struct {
+		FS             fs.FS
+		Root           string
+		Options        TransferOptions
+		ExpectedOutput string
+	}{
+		{
+			Root:    "assets",
+			Options: TransferOptions{FSModes: true},
+			ExpectedOutput: "D0755 0 assets\n" +
+				"D0750 0 css\nC0600 3 site.css\np{}\x00E\n" +
+				"D0755 0 img\nC0644 3 logo.svg\nsvg\x00E\n" +
+				"C0644 3 index.html\n<p>\x00" +
+				"E\n",
+		},
+		{
+			// The root itself is left out
+			Root:    ".",
+			Options: TransferOptions{FSModes: true},
+			ExpectedOutput: "D0755 0 assets\n" +
+				"D0750 0 css\nC0600 3 site.css\np{}\x00E\n" +
+				"D0755 0 img\nC0644 3 logo.svg\nsvg\x00E\n" +
+				"C0644 3 index.html\n<p>\x00" +
+				"E\n" +
+				"C0644 1 robots.txt\n*\x00",
+		},
+		{
+			// Only files with a modification time get one
+			Root:           "assets/img",
+			Options:        TransferOptions{PreserveTimes: true},
+			ExpectedOutput: "D0755 0 img\nT1700000000 0 1700000000 0\nC0644 3 logo.svg\nsvg\x00E\n",
+		},
+		{
+			// Writable on the remote, so a later upload can replace them
+			FS:             embedded,
+			Root:           "static",
+			ExpectedOutput: "D0755 0 static\nC0644 2 app.js\njs\x00D0755 0 js\nC0644 1 a.js\na\x00E\nE\n",
+		},
+		{
+			FS:             embedded,
+			Root:           "static",
+			Options:        TransferOptions{FSModes: true},
+			ExpectedOutput: "D0555 0 static\nC0444 2 app.js\njs\x00D0555 0 js\nC0444 1 a.js\na\x00E\nE\n",
+		},
+	}
+
+	for _, v := range tests {
+		if v.FS == nil {
+			v.FS = fsys
+		}
+		out := &bytes.Buffer{}
+		c := &transfer{opts: v.Options, scpStdinPipe: nopWriteCloser{out}, scpStdoutPipe: acceptingRemote()}
+
+		if err := c.sendFS(v.FS, v.Root); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if out.String() != v.ExpectedOutput {
+			expectedError(t, out.String(), v.ExpectedOutput)
+		}
+	}
+}
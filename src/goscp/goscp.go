@@ -2,15 +2,17 @@ package goscp
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cheggaaa/pb"
@@ -19,50 +21,77 @@ import (
 
 var (
 	// SCP messages
-	fileCopyRx  = regexp.MustCompile(`C(?P<mode>\d{4}) (?P<length>\d+) (?P<filename>.+)`)
-	dirCopyRx   = regexp.MustCompile(`D(?P<mode>\d{4}) (?P<length>\d+) (?P<dirname>.+)`)
-	timestampRx = regexp.MustCompile(`T(?P<mtime>\d+) 0 (?P<atime>\d+) 0`)
-	endDir      = "E"
+	endDir = "E"
 )
 
+// Time a cancelled transfer has to stop the remote scp before the session
+// is closed.
+const cancelGracePeriod = 5 * time.Second
+
+// Parsed SCP protocol message.
+type message struct {
+	// Message type, one of 'C', 'D' or 'T'
+	Type byte
+
+	// File and directory messages
+	Mode   os.FileMode
+	Length int64
+	Name   string
+
+	// Name before the SanitizeName option rewrote it, empty if it didn't
+	SentName string
+
+	// Timestamp messages
+	Mtime int64
+	Atime int64
+}
+
 // Client wraps a ssh.Client and provides additional functionality.
+// Transfers keep their state to themselves, so a Client can run several
+// of them in parallel.
 type Client struct {
-	SSHClient       *ssh.Client
-	DestinationPath []string
+	SSHClient *ssh.Client
 
-	// Errors that have occurred while communicating with host
-	errors []error
+	// Defaults for transfers started without their own options
+	TransferOptions
 
-	// Verbose output when communicating with host
-	Verbose bool
+	// Records the throughput of successful transfers per host when set,
+	// see HostStats.EstimateDuration
+	Stats *HostStats
 
-	// Stop transfer on OS error - occurs during filepath.Walk
-	StopOnOSError bool
+	// Guards errors, warnings, transfers and closed
+	mu sync.Mutex
 
-	// Show progress bar
-	ShowProgressBar bool
+	// Errors that have occurred while communicating with host
+	errors []error
 
-	// Configurable progress bar
-	ProgressBar *pb.ProgressBar
+	// Non-fatal conditions noted by transfers
+	warnings []Warning
 
-	// Stdin for SSH session
-	scpStdinPipe io.WriteCloser
+	// Transfers in progress
+	transfers map[*transfer]struct{}
 
-	// Stdout for SSH session
-	scpStdoutPipe *readCanceller
+	// Capabilities found by Probe, and the lock held while probing
+	caps    *Capabilities
+	probeMu sync.Mutex
+
+	// Set by Close
+	closed bool
 }
 
 // NewClient returns a ssh.Client wrapper.
 // DestinationPath is set to the current directory by default.
 func NewClient(c *ssh.Client) *Client {
 	scpc := &Client{
-		SSHClient:       c,
-		DestinationPath: []string{"."},
-		ShowProgressBar: true,
+		SSHClient: c,
+		TransferOptions: TransferOptions{
+			DestinationPath: []string{"."},
+			ShowProgressBar: true,
+		},
 	}
 
 	// Total is set before progress starts
-	scpc.ProgressBar = scpc.newDefaultProgressBar(0)
+	scpc.ProgressBar = newDefaultProgressBar(0)
 
 	return scpc
 }
@@ -73,386 +102,1348 @@ func (c *Client) SetDestinationPath(path string) {
 }
 
 func (c *Client) addError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.errors = append(c.errors, err)
 }
 
 // GetLastError should be queried after a call to Download() or Upload().
 func (c *Client) GetLastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if len(c.errors) > 0 {
 		return c.errors[len(c.errors)-1]
 	}
 	return nil
 }
 
-// GetErrorStack returns all errors that have occurred so far.
+// GetErrorStack returns all errors that have occurred so far, from every
+// transfer.
 func (c *Client) GetErrorStack() []error {
-	return c.errors
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]error(nil), c.errors...)
+}
+
+// ErrorCount returns the number of errors on the stack.
+func (c *Client) ErrorCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.errors)
+}
+
+// ClearErrors empties the error stack, so errors of earlier transfers don't
+// show up in later GetLastError calls. Use Transfer.Errors for errors scoped
+// to a single transfer.
+func (c *Client) ClearErrors() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errors = nil
 }
 
-// Cancel an ongoing operation.
+// Cancel every ongoing operation. The remote scp is sent an error message
+// and given a few seconds to exit before the session is closed.
 func (c *Client) Cancel() {
-	if c.scpStdoutPipe != nil {
-		close(c.scpStdoutPipe.cancel)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for t := range c.transfers {
+		t.cancel()
 	}
 }
 
+// Close cancels every ongoing transfer, closes their sessions without
+// waiting for the remote scp and waits for them to end. Transfers started
+// afterwards fail with ErrClientClosed. The SSHClient is left open.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	transfers := make([]*transfer, 0, len(c.transfers))
+	for t := range c.transfers {
+		transfers = append(transfers, t)
+	}
+	c.mu.Unlock()
+
+	for _, t := range transfers {
+		t.cancel()
+		t.closeSession()
+	}
+	for _, t := range transfers {
+		<-t.done
+	}
+
+	return nil
+}
+
 // Download remotePath to c.DestinationPath.
-func (c *Client) Download(remotePath string) {
-	session, err := c.SSHClient.NewSession()
+// The first error that occurs is returned, GetErrorStack has all of them.
+func (c *Client) Download(remotePath string) error {
+	return c.DownloadContext(context.Background(), remotePath)
+}
+
+// DownloadContext downloads remotePath to c.DestinationPath, closing the
+// session and returning ctx.Err() if ctx is done before it completes.
+func (c *Client) DownloadContext(ctx context.Context, remotePath string) error {
+	return c.DownloadWithOptions(ctx, remotePath, c.TransferOptions)
+}
+
+// DownloadWithOptions works like DownloadContext but uses opts instead of
+// the Client's own options.
+func (c *Client) DownloadWithOptions(ctx context.Context, remotePath string, opts TransferOptions) error {
+	return c.StartDownload(ctx, remotePath, opts).Wait()
+}
+
+// StartDownload starts downloading remotePath with opts in the background
+// and returns a handle to supervise it.
+func (c *Client) StartDownload(ctx context.Context, remotePath string, opts TransferOptions) *Transfer {
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.download(ctx, []string{remotePath})
+	})
+}
+
+// DownloadPaths downloads several remote paths to c.DestinationPath in a
+// single session, like scp does with several sources.
+func (c *Client) DownloadPaths(ctx context.Context, remotePaths []string) error {
+	return c.StartDownloadPaths(ctx, remotePaths, c.TransferOptions).Wait()
+}
+
+// StartDownloadPaths starts downloading remotePaths with opts in the
+// background and returns a handle to supervise it.
+func (c *Client) StartDownloadPaths(ctx context.Context, remotePaths []string, opts TransferOptions) *Transfer {
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.download(ctx, remotePaths)
+	})
+}
+
+func (t *transfer) download(ctx context.Context, remotePaths []string) {
+	if len(remotePaths) == 0 {
+		t.addError(errors.New("No remote paths to download"))
+		return
+	}
+
+	session, err := t.openSession()
 	if err != nil {
-		c.addError(err)
+		t.addError(err)
 		return
 	}
 	defer session.Close()
 
-	go c.handleDownload(session)
-
-	cmd := fmt.Sprintf("scp -rf %s", fmt.Sprintf("%q", remotePath))
-	if err := session.Run(cmd); err != nil {
-		c.addError(err)
+	if err := t.openPipes(session); err != nil {
+		t.addError(err)
 		return
 	}
 
-	return
-}
-
-// handleDownload handles message parsing to and from the session.
-func (c *Client) handleDownload(session *ssh.Session) {
-	var err error
+	t.path = append([]string(nil), t.opts.DestinationPath...)
+	t.startSecurityCheck(remotePaths...)
+	t.dirStack = nil
+	t.traceLast = time.Time{}
+	session.Stderr = &promptWatcher{t: t, session: session}
 
-	c.scpStdinPipe, err = session.StdinPipe()
-	if err != nil {
-		c.addError(err)
-		return
+	encoded := make([]string, len(remotePaths))
+	for i, p := range remotePaths {
+		if encoded[i], err = t.encodeRemote(p); err != nil {
+			t.addError(err)
+			return
+		}
 	}
-	defer c.scpStdinPipe.Close()
 
-	r, err := session.StdoutPipe()
+	source := SourceCommand(encoded[0])
+	source.Paths = encoded[1:]
+	cmd := t.command(source)
+	err = t.runSession(ctx, session, cmd, t.handleDownload)
+	t.setPhase(PhaseFinish, "")
 	if err != nil {
-		c.addError(err)
+		t.addError(t.sessionError(ctx, err, strings.Join(remotePaths, " ")))
 		return
 	}
 
-	// Initialize transfer
-	c.sendAck(c.scpStdinPipe)
+	return
+}
 
-	// Wrapper to support cancellation
-	c.scpStdoutPipe = &readCanceller{
-		Reader: bufio.NewReader(r),
-		cancel: make(chan struct{}, 1),
-	}
+// handleDownload handles message parsing to and from the session.
+func (t *transfer) handleDownload() {
+	defer t.scpStdinPipe.Close()
+	defer t.notifyCancel()
+
+	// Initialize transfer
+	t.sendAck(t.scpStdinPipe)
 
 	for {
-		c.outputInfo("Reading message from source")
-		msg, err := c.scpStdoutPipe.ReadString('\n')
+		// Messages are read past the cancellation check of scpStdoutPipe
+		if t.isCancelled() {
+			t.addError(ErrCancelled)
+			return
+		}
+
+		t.outputInfo("Reading message from source")
+		if prompt, ok := t.readPrompt(); ok {
+			if err := t.answerPrompt(prompt); err != nil {
+				t.addError(err)
+				return
+			}
+			continue
+		}
+
+		msg, err := t.readMessage()
 		if err != nil {
 			if err != io.EOF {
-				c.addError(err)
+				t.addError(fmt.Errorf("Could not read message: %w", err))
+			} else if err := t.checkStrictEnd(msg); err != nil {
+				t.addError(err)
 			}
 			return
 		}
 
-		// Strip nulls and new lines
-		msg = strings.TrimSpace(strings.Trim(msg, "\x00"))
-		c.outputInfo(fmt.Sprintf("Received: %s", msg))
+		t.traceReceived(msg)
+		if err := t.checkStrictMessage(msg); err != nil {
+			t.addError(err)
+			return
+		}
 
-		// Confirm message
-		c.sendAck(c.scpStdinPipe)
+		msg = t.quirkMessage(messageText(msg))
+		if msg == "" {
+			// Stray line endings and acks
+			continue
+		}
+		t.outputInfo(fmt.Sprintf("Received: %s", msg))
+
+		if isLocaleWarning(msg) {
+			// Printed by the remote shell, not part of the protocol
+			t.addWarning("", fmt.Sprintf("Ignored remote locale warning: %s", msg))
+			continue
+		}
+		t.markStarted()
+		t.setPhase(PhaseReceive, "")
 
+		// Each handler confirms its message, or answers with an error
 		switch {
-		case c.isFileCopyMsg(msg):
+		case t.isFileCopyMsg(msg):
 			// Handle incoming file
-			err := c.file(msg)
+			err := t.file(msg)
 			if err != nil {
-				c.addError(err)
+				t.addError(err)
 				return
 			}
-		case c.isDirCopyMsg(msg):
+		case t.isDirCopyMsg(msg):
 			// Handling incoming directory
-			err := c.directory(msg)
+			err := t.directory(msg)
 			if err != nil {
-				c.addError(err)
+				t.addError(err)
 				return
 			}
 		case msg == endDir:
 			// Directory finished, go up a directory
-			c.upDirectory()
-		case c.isWarningMsg(msg):
-			c.addError(fmt.Errorf("Warning message: [%q]\n", msg))
-			return
-		case c.isErrorMsg(msg):
-			c.addError(fmt.Errorf("Error message: [%q]\n", msg))
+			t.upDirectory()
+			t.sendAck(t.scpStdinPipe)
+		case strings.HasPrefix(msg, "T"):
+			// Times of the next file or directory
+			if err := t.timestamp(msg); err != nil {
+				t.addError(err)
+				return
+			}
+		case t.isWarningMsg(msg):
+			// The remote skips the item it couldn't send
+			if !t.continueOnError() {
+				t.addError(t.remoteMessage(msg))
+				return
+			}
+			t.failItem(t.remoteMessage(msg))
+		case t.isErrorMsg(msg):
+			t.addError(t.remoteMessage(msg))
 			return
 		default:
-			c.addError(fmt.Errorf("Unhandled message: [%q]\n", msg))
+			t.addError(protocolErrorf("Unhandled message: [%q]", msg))
 			return
 		}
-
-		// Confirm message
-		c.sendAck(c.scpStdinPipe)
 	}
 }
 
 // Upload localPath to c.DestinationPath.
-func (c *Client) Upload(localPath string) {
-	session, err := c.SSHClient.NewSession()
+// The first error that occurs is returned, GetErrorStack has all of them.
+func (c *Client) Upload(localPath string) error {
+	return c.UploadContext(context.Background(), localPath)
+}
+
+// UploadContext uploads localPath to c.DestinationPath, closing the session
+// and returning ctx.Err() if ctx is done before it completes.
+func (c *Client) UploadContext(ctx context.Context, localPath string) error {
+	return c.UploadWithOptions(ctx, localPath, c.TransferOptions)
+}
+
+// UploadWithOptions works like UploadContext but uses opts instead of the
+// Client's own options.
+func (c *Client) UploadWithOptions(ctx context.Context, localPath string, opts TransferOptions) error {
+	return c.StartUpload(ctx, localPath, opts).Wait()
+}
+
+// StartUpload starts uploading localPath with opts in the background and
+// returns a handle to supervise it.
+func (c *Client) StartUpload(ctx context.Context, localPath string, opts TransferOptions) *Transfer {
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.upload(ctx, localPath)
+	})
+}
+
+func (t *transfer) upload(ctx context.Context, localPath string) {
+	session, err := t.openSession()
 	if err != nil {
-		c.addError(err)
+		t.addError(err)
 		return
 	}
 	defer session.Close()
 
-	go c.handleUpload(session, localPath)
+	remoteDest := filepath.Join(t.opts.DestinationPath...)
 
-	cmd := fmt.Sprintf("scp -rt %s", fmt.Sprintf("%q", filepath.Join(c.DestinationPath...)))
-	if err := session.Run(cmd); err != nil {
-		c.addError(err)
+	t.duplicates = nil
+	var destIsDir bool
+	if t.opts.DeduplicateUploads {
+		if shell := t.remoteShell(); shell != ShellPOSIX {
+			t.addError(refusedErrorf("Refusing DeduplicateUploads, the remote %s shell has no cp", shell))
+			return
+		}
+
+		t.duplicates, err = findDuplicates(localPath, t.opts.ExcludeMarkers, t.opts.PreserveTimes)
+		if err != nil {
+			t.addError(err)
+			return
+		}
+
+		if len(t.duplicates) > 0 {
+			destIsDir, err = t.remoteIsDir(ctx, filepath.ToSlash(remoteDest))
+			if err != nil {
+				t.addError(err)
+				return
+			}
+		}
+	}
+
+	ok := t.runUpload(ctx, session, remoteDest, func() error {
+		return t.sendTree(localPath)
+	})
+	if !ok {
 		return
 	}
 
+	if len(t.duplicates) > 0 {
+		if err := t.copyDuplicates(ctx, filepath.ToSlash(remoteDest), destIsDir, localPath); err != nil {
+			t.addError(err)
+			return
+		}
+	}
+
 	return
 }
 
-// handleDownload handles message parsing to and from the session.
-func (c *Client) handleUpload(session *ssh.Session, localPath string) {
-	var err error
+// Run cmd in session while handler speaks the protocol. If ctx is done or
+// the transfer is cancelled first, the handler gets cancelGracePeriod to
+// stop the remote scp before the session is closed.
+func (t *transfer) runSession(ctx context.Context, session *ssh.Session, cmd string, handler func()) error {
+	cmd, err := t.applyEnv(session, cmd)
+	if err != nil {
+		return err
+	}
+
+	ended := make(chan struct{})
+	t.sudoReady = make(chan struct{})
+	t.sudoAttempts = 0
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if t.waitSudo(ended) {
+			handler()
+		}
+	}()
+
+	stop := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.outputInfo(fmt.Sprintf("Cancelling transfer: %s", ctx.Err()))
+			t.cancel()
+		case <-t.cancelled:
+		case <-stop:
+			return
+		}
+
+		// The handler tells the remote and drains the session first
+		timer := time.NewTimer(cancelGracePeriod)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		}
 
-	c.scpStdinPipe, err = session.StdinPipe()
+		t.outputInfo("Closing session")
+		session.Close()
+		close(closed)
+	}()
+
+	if t.opts.StartTimeout > 0 {
+		go t.watchStart(session, t.opts.StartTimeout, stop)
+	}
+	if t.opts.StallTimeout > 0 {
+		t.activity.touch()
+		go t.watchStall(t.opts.StallTimeout, stop)
+	}
+
+	err = session.Run(cmd)
+	close(ended)
+	<-done
+	close(stop)
+
+	select {
+	case <-closed:
+		// Cancel without ctx is reported by the handler
+		return ctx.Err()
+	default:
+		return err
+	}
+}
+
+// Add remotePath to an error from running the remote scp. A non-zero exit
+// status becomes a RemoteError with the last message the remote sent.
+// Context errors are returned as they are.
+func (t *transfer) sessionError(ctx context.Context, err error, remotePath string) error {
+	if err == ctx.Err() {
+		return err
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		return &RemoteError{
+			Path:    remotePath,
+			Code:    exitErr.ExitStatus(),
+			Message: t.remoteText,
+			Err:     err,
+		}
+	}
+	return fmt.Errorf("Remote scp failed for [%q]: %w", remotePath, err)
+}
+
+// Close the session and fail with ErrStartTimeout if the remote scp doesn't
+// start speaking the protocol within timeout.
+func (t *transfer) watchStart(session io.Closer, timeout time.Duration, stop <-chan struct{}) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		t.addError(fmt.Errorf("%w: no response within %s", ErrStartTimeout, timeout))
+		session.Close()
+	case <-t.started:
+	case <-stop:
+	}
+}
+
+// Run the remote sink in session and send items to remoteDest with send.
+// Returns false if the transfer failed.
+func (t *transfer) runUpload(ctx context.Context, session *ssh.Session, remoteDest string, send func() error) bool {
+	if err := t.openPipes(session); err != nil {
+		t.addError(err)
+		return false
+	}
+
+	session.Stderr = &promptWatcher{t: t, session: session}
+
+	encoded, err := t.encodeRemote(remoteDest)
 	if err != nil {
-		c.addError(err)
-		return
+		t.addError(err)
+		return false
 	}
-	defer c.scpStdinPipe.Close()
 
-	r, err := session.StdoutPipe()
+	cmd := t.command(SinkCommand(encoded))
+	err = t.runSession(ctx, session, cmd, func() {
+		t.handleUpload(send)
+	})
+	t.setPhase(PhaseFinish, "")
 	if err != nil {
-		c.addError(err)
+		t.addError(t.sessionError(ctx, err, remoteDest))
+		return false
+	}
+
+	return true
+}
+
+// handleUpload sends items through the session with send.
+func (t *transfer) handleUpload(send func() error) {
+	defer t.scpStdinPipe.Close()
+	defer t.notifyCancel()
+
+	// The remote sink acknowledges that it is ready
+	if err := t.readAck(); err != nil {
+		t.addError(err)
+		return
+	}
+	t.markStarted()
+	t.setPhase(PhaseSend, "")
+
+	if err := send(); err != nil {
+		t.addError(err)
 		return
 	}
 
+	if err := t.flushAcks(); err != nil {
+		t.addError(err)
+	}
+}
+
+// Set up the session's stdin and stdout before the remote command starts.
+func (t *transfer) openPipes(session *ssh.Session) error {
+	var err error
+
+	t.scpStdinPipe, err = session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Could not open stdin: %w", err)
+	}
+
+	var r io.Reader
+	r, err = session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Could not open stdout: %w", err)
+	}
+
+	if t.opts.StallTimeout > 0 {
+		t.scpStdinPipe = &activityWriteCloser{WriteCloser: t.scpStdinPipe, a: &t.activity}
+		r = &activityReader{r: r, a: &t.activity}
+	}
+
+	if t.opts.BandwidthLimit > 0 || len(t.opts.BandwidthSchedule) > 0 {
+		t.scpStdinPipe = &limitedWriteCloser{WriteCloser: t.scpStdinPipe, l: newRateLimiter(t.bandwidthAt)}
+		r = &limitedReader{r: r, l: newRateLimiter(t.bandwidthAt)}
+	}
+
+	// Prompts are answered from the stderr watcher
+	t.scpStdinPipe = &lockedWriteCloser{w: t.scpStdinPipe}
+
 	// Wrapper to support cancellation
-	c.scpStdoutPipe = &readCanceller{
+	t.scpStdoutPipe = &readCanceller{
 		Reader: bufio.NewReader(r),
-		cancel: make(chan struct{}, 1),
+		cancel: t.cancelled,
+	}
+
+	return nil
+}
+
+// Bandwidth limit of the transfer at now.
+func (t *transfer) bandwidthAt(now time.Time) int64 {
+	return bandwidthAt(t.opts.BandwidthLimit, t.opts.BandwidthSchedule, now)
+}
+
+// Send localPath and everything below it.
+func (t *transfer) sendTree(localPath string) error {
+	// The remote scp refuses directories without -r, and only after the
+	// items before them were sent
+	if t.opts.NonRecursive {
+		if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+			return fmt.Errorf("Could not upload [%q]: directories can't be sent with NonRecursive", localPath)
+		}
 	}
 
-	// This has already been used in the cmd call below
-	// so it can be reused for 'end of directory' message handling
-	c.DestinationPath = []string{}
+	t.path = nil
+	t.uploadRoot = localPath
+	t.dirStack = nil
+	t.traceLast = time.Time{}
 
-	err = filepath.Walk(localPath, c.handleItem)
+	err := walkStream(localPath, t.handleItem)
 	if err != nil {
-		c.addError(err)
-		return
+		return err
+	}
+
+	// End transfer, closing every directory still open
+	for len(t.dirStack) > 0 {
+		if err := t.endDirectory(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send an end of directory message and wait for the remote to accept it.
+func (t *transfer) endDirectory() error {
+	t.sendEndOfDirectoryMessage(t.scpStdinPipe)
+	if err := t.expectAck(pendingAck{action: "end directory"}); err != nil {
+		return err
+	}
+	t.leaveDir()
+
+	return nil
+}
+
+// Read an acknowledgment message, returning the remote's warning or error
+// message instead if one is sent.
+func (t *transfer) readAck() error {
+	b, err := t.scpStdoutPipe.ReadByte()
+	if err != nil {
+		return fmt.Errorf("Could not read response: %w", err)
 	}
 
-	// End transfer
-	paths := strings.Split(c.DestinationPath[0], "/")
-	for range paths {
-		c.sendEndOfDirectoryMessage(c.scpStdinPipe)
+	if b == '\x00' {
+		t.traceReceived("\x00")
+		return nil
+	}
+
+	line, err := t.scpStdoutPipe.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("Could not read response: %w", err)
+	}
+	t.traceReceived(string(b) + line)
+	if err == io.EOF && t.opts.StrictProtocol {
+		return protocolErrorf("Truncated response: [%q]", string(b)+line)
 	}
+
+	if b == '\x01' || b == '\x02' {
+		return t.remoteMessage(string(b) + line)
+	}
+	if msg := messageText(string(b) + line); !t.opts.StrictProtocol && isLocaleWarning(msg) {
+		// Printed by the remote shell, the ack is still to come
+		t.addWarning("", fmt.Sprintf("Ignored remote locale warning: %s", msg))
+		return t.readAck()
+	}
+	return protocolErrorf("Unexpected response: [%q]", string(b)+line)
 }
 
 // Send an acknowledgment message.
-func (c *Client) sendAck(w io.Writer) {
+func (t *transfer) sendAck(w io.Writer) {
 	fmt.Fprint(w, "\x00")
+	t.trace(traceSent, "ack")
 }
 
-// Send an error message.
-func (c *Client) sendErr(w io.Writer) {
-	fmt.Fprint(w, "\x02")
+// Send an error message with the reason, which the remote logs before it
+// aborts the transfer.
+func (t *transfer) sendErrMsg(w io.Writer, text string) {
+	fmt.Fprintf(w, "\x02scp: %s\n", strings.Replace(text, "\n", " ", -1))
+	t.trace(traceSent, "error")
+}
+
+// Send a warning message, which makes the remote skip the current item.
+func (t *transfer) sendWarning(w io.Writer, err error) {
+	fmt.Fprintf(w, "\x01scp: %s\n", strings.Replace(err.Error(), "\n", " ", -1))
+	t.trace(traceSent, "warning")
 }
 
 // Check if an incoming message is a file copy message.
-func (c *Client) isFileCopyMsg(s string) bool {
+func (t *transfer) isFileCopyMsg(s string) bool {
 	return strings.HasPrefix(s, "C")
 }
 
 // Check if an incoming message is a directory copy message.
-func (c *Client) isDirCopyMsg(s string) bool {
+func (t *transfer) isDirCopyMsg(s string) bool {
 	return strings.HasPrefix(s, "D")
 }
 
 // Check if an incoming message is a warning.
-func (c *Client) isWarningMsg(s string) bool {
+func (t *transfer) isWarningMsg(s string) bool {
 	return strings.HasPrefix(s, "\x01")
 }
 
 // Check if an incoming message is an error.
-func (c *Client) isErrorMsg(s string) bool {
+func (t *transfer) isErrorMsg(s string) bool {
 	return strings.HasPrefix(s, "\x02")
 }
 
 // Send a directory message while in source mode.
-func (c *Client) sendDirectoryMessage(w io.Writer, mode os.FileMode, dirname string) {
-	msg := fmt.Sprintf("D0%o 0 %s", mode, dirname)
+func (t *transfer) sendDirectoryMessage(w io.Writer, mode os.FileMode, dirname string) {
+	msg := fmt.Sprintf("D%04o 0 %s", unixFromFileMode(mode), dirname)
 	fmt.Fprintln(w, msg)
-	c.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.trace(traceSent, fmt.Sprintf("%q", msg))
 }
 
 // Send a end of directory message while in source mode.
-func (c *Client) sendEndOfDirectoryMessage(w io.Writer) {
+func (t *transfer) sendEndOfDirectoryMessage(w io.Writer) {
 	msg := endDir
 	fmt.Fprintln(w, msg)
-	c.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.trace(traceSent, fmt.Sprintf("%q", msg))
 }
 
 // Send a file message while in source mode.
-func (c *Client) sendFileMessage(w io.Writer, mode os.FileMode, size int64, filename string) {
-	msg := fmt.Sprintf("C0%o %d %s", mode, size, filename)
+func (t *transfer) sendFileMessage(w io.Writer, mode os.FileMode, size int64, filename string) {
+	msg := fmt.Sprintf("C%04o %d %s", unixFromFileMode(mode), size, filename)
 	fmt.Fprintln(w, msg)
-	c.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.trace(traceSent, fmt.Sprintf("%q", msg))
 }
 
 // Handle directory copy message in sink mode.
-func (c *Client) directory(msg string) error {
-	parts, err := c.parseMessage(msg, dirCopyRx)
+func (t *transfer) directory(msg string) error {
+	times := t.takeTimes()
+
+	m, err := t.parseMessage(msg)
 	if err != nil {
 		return err
 	}
 
-	err = os.Mkdir(filepath.Join(c.DestinationPath...)+string(filepath.Separator)+parts["dirname"], 0755)
-	if err != nil {
+	err = t.incomingName(&m)
+	if err == ErrSkipFile {
+		// The remote leaves out the directory after a warning
+		t.outputInfo(fmt.Sprintf("Skipping directory: %s", m.Name))
+		t.sendWarning(t.scpStdinPipe, fmt.Errorf("%s: skipped", m.Name))
+		t.countSkipped()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	relPath := path.Join(t.downloadRelPath(), m.Name)
+	t.setPhase(PhaseReceive, relPath)
+
+	if err := t.checkMessage(m); err != nil {
 		return err
 	}
 
+	// The owner needs full access until the content is written
+	dirPath := filepath.Join(t.path...) + string(filepath.Separator) + m.Name
+	if t.tar != nil {
+		// Archived with its mode and times instead
+		if err := t.tar.writeDir(relPath, m.Mode&modeBits, times); err != nil {
+			return err
+		}
+	} else {
+		if err := t.confine(dirPath); err != nil {
+			return err
+		}
+		err = t.writeFS().Mkdir(dirPath, m.Mode.Perm()|0700)
+		if err != nil {
+			return t.skipItem(err)
+		}
+	}
+	t.sendAck(t.scpStdinPipe)
+
 	// Traverse into directory
-	c.DestinationPath = append(c.DestinationPath, parts["dirname"])
+	t.path = append(t.path, m.Name)
+	t.enterDir(t.downloadRelPath())
+	if t.tar != nil {
+		return nil
+	}
+
+	// Mode and times apply once the content no longer needs them
+	f := &t.dirStack[len(t.dirStack)-1]
+	f.localPath = filepath.Join(t.path...)
+	f.mode = m.Mode & modeBits
+	f.times = times
 
 	return nil
 }
 
 // Handle file copy message in sink mode.
-func (c *Client) file(msg string) error {
-	parts, err := c.parseMessage(msg, fileCopyRx)
+func (t *transfer) file(msg string) error {
+	times := t.takeTimes()
+
+	m, err := t.parseMessage(msg)
 	if err != nil {
 		return err
 	}
+	err = t.incomingName(&m)
+	relPath := path.Join(t.downloadRelPath(), m.Name)
+	t.setPhase(PhaseReceive, relPath)
+
+	start := time.Now()
+	if err == nil {
+		err = t.receiveFile(m, relPath, times)
+	} else if err == ErrSkipFile {
+		if err := t.discardFile(m); err != nil {
+			return err
+		}
+	}
+	if err == ErrSkipFile {
+		t.addResult(relPath, m.Length, time.Since(start), err)
+		t.countSkipped()
+		return nil
+	}
 
-	fileLen, _ := strconv.Atoi(parts["length"])
+	t.addResult(relPath, m.Length, time.Since(start), skipCause(err))
 
-	// Create local file
-	localFile, err := os.Create(filepath.Join(c.DestinationPath...) + string(filepath.Separator) + parts["filename"])
+	return t.skipItem(err)
+}
+
+// Check and write an incoming file, with times from its timestamp message
+// if one was sent.
+func (t *transfer) receiveFile(m message, relPath string, times *fileTimes) error {
+	if err := t.checkMessage(m); err != nil {
+		return err
+	}
+
+	h, err := t.beforeFile(m, relPath)
+	if err == ErrSkipFile {
+		if err := t.discardFile(m); err != nil {
+			return err
+		}
+		return ErrSkipFile
+	}
 	if err != nil {
 		return err
 	}
+
+	if h.Target == nil {
+		if err := t.confine(h.LocalPath); err != nil {
+			return err
+		}
+	}
+	if err := t.beginTarFile(h, times); err != nil {
+		return err
+	}
+
+	localFile, created, err := t.openTarget(h)
+	if err != nil {
+		return &skippableError{err}
+	}
 	defer localFile.Close()
 
-	var w io.Writer
-	if c.ShowProgressBar {
-		bar := c.newProgressBar(fileLen)
-		bar.Start()
-		defer bar.Finish()
+	// Ready for the content
+	t.sendAck(t.scpStdinPipe)
 
-		w = io.MultiWriter(localFile, bar)
-	} else {
-		w = localFile
+	var r io.Reader = &skipReader{r: t.scpStdoutPipe, t: t, relPath: relPath}
+	if t.opts.FileTimeout > 0 {
+		r = newTimeoutReader(r, relPath, t.opts.FileTimeout)
+	}
+
+	r, finish := t.progressReader(r, m.Name, m.Length)
+	defer finish()
+
+	t.progress.startFile(relPath)
+	r = io.TeeReader(r, &t.progress)
+
+	// localFile stays unwrapped so io.CopyN can use the io.ReaderFrom of
+	// files, unless write errors have to be survived
+	var w io.Writer = localFile
+	drain := &drainWriter{w: localFile}
+	if t.continueOnError() {
+		w = drain
 	}
 
-	if n, err := io.CopyN(w, c.scpStdoutPipe, int64(fileLen)); err != nil || n < int64(fileLen) {
-		c.sendErr(c.scpStdinPipe)
+	if n, err := io.CopyN(w, r, m.Length); err == ErrSkipFile {
+		return t.skipReceivedFile(h, m.Length-n, localFile, created)
+	} else if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if errors.Is(err, ErrFileTimeout) {
+			// Only closing the session stops a remote stuck on the file
+			t.readAbandoned = true
+			t.cancel()
+		} else if err != ErrCancelled {
+			t.sendErrMsg(t.scpStdinPipe, fmt.Sprintf("%s: %s", h.LocalPath, err))
+		}
+		return fmt.Errorf("Could not receive [%q]: %w", h.LocalPath, err)
+	}
+
+	// The remote reports whether it could read the whole file
+	if err := t.readAck(); err != nil {
+		err = fmt.Errorf("Could not receive [%q]: %w", h.LocalPath, err)
+
+		var remoteErr *RemoteMessageError
+		if errors.As(err, &remoteErr) && !remoteErr.Fatal {
+			return &skippableError{err}
+		}
 		return err
 	}
+	if drain.err != nil {
+		return &skippableError{drain.err}
+	}
+
+	t.sendAck(t.scpStdinPipe)
+	t.countFile(m.Length)
+	t.progress.finishFile()
+
+	if created {
+		t.applyTimes(relPath, h.LocalPath, times)
+	}
 
 	return nil
 }
 
+// Open where an incoming file is written, h.Target or a local file. created
+// is true when the file was created by the transfer.
+func (t *transfer) openTarget(h *FileHeader) (w io.WriteCloser, created bool, err error) {
+	if h.Target != nil {
+		return h.Target, false, nil
+	}
+
+	localFile, created, err := t.openLocalFile(h)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only local files can be preallocated
+	if f, ok := localFile.(*os.File); ok && h.Preallocate {
+		if err := preallocate(f, h.Size); err != nil {
+			localFile.Close()
+
+			// Don't leave an empty file behind
+			if created {
+				t.removeLocal(h.LocalPath)
+			}
+			return nil, false, err
+		}
+	}
+
+	return localFile, created, nil
+}
+
+// Open the local file for h from the PreopenedFiles or OpenFile options, or
+// create it at h.LocalPath in the WriteFS. created is true when the file
+// was created here.
+func (t *transfer) openLocalFile(h *FileHeader) (w io.WriteCloser, created bool, err error) {
+	if f, ok := t.opts.PreopenedFiles[h.RelPath]; ok {
+		return f, false, nil
+	}
+
+	if t.opts.OpenFile != nil {
+		f, err := t.opts.OpenFile(h)
+		if err != nil {
+			return nil, false, fmt.Errorf("Could not open destination for [%q]: %w", h.RelPath, err)
+		}
+		if f != nil {
+			return f, false, nil
+		}
+	}
+
+	fsys := t.writeFS()
+	w, err = fsys.Create(h.LocalPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Chmod isn't limited by the umask, unlike the mode given to open
+	if t.opts.PreserveMode {
+		if err := fsys.Chmod(h.LocalPath, h.Mode&modeBits); err != nil {
+			w.Close()
+			t.removeLocal(h.LocalPath)
+			return nil, false, fmt.Errorf("Could not set mode of [%q]: %w", h.LocalPath, err)
+		}
+	}
+
+	return w, true, nil
+}
+
+// Answer a failed item. With ContinueOnError, failures that leave the
+// protocol in sync are recorded and sent to the remote as a warning, which
+// makes it move on to the next item, and nil is returned. Otherwise err
+// stops the transfer.
+func (t *transfer) skipItem(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	skippable, ok := err.(*skippableError)
+	if !ok {
+		return err
+	}
+	if !t.continueOnError() {
+		return skippable.err
+	}
+
+	t.sendWarning(t.scpStdinPipe, skippable.err)
+	t.failItem(skippable.err)
+
+	return nil
+}
+
+// Keeps accepting data after a write error so the rest of a file can be
+// read off the connection. The first error is kept in err.
+type drainWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (d *drainWriter) Write(p []byte) (int, error) {
+	if d.err == nil {
+		_, d.err = d.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// Take the message out of a raw line, dropping the line ending, CRLF
+// included, and the NUL bytes of acks around it. Names in file and directory
+// messages are kept byte for byte like OpenSSH does, spaces included, other
+// messages are trimmed.
+func messageText(raw string) string {
+	msg := strings.TrimLeft(raw, "\x00")
+	msg = strings.TrimSuffix(msg, "\n")
+	msg = strings.TrimRight(msg, "\x00")
+	msg = strings.TrimSuffix(msg, "\r")
+	msg = strings.TrimRight(msg, "\x00")
+
+	if strings.HasPrefix(msg, "C") || strings.HasPrefix(msg, "D") {
+		return msg
+	}
+	return strings.TrimSpace(strings.Trim(msg, "\x00"))
+}
+
 // Break down incoming protocol messages.
-func (c *Client) parseMessage(msg string, rx *regexp.Regexp) (map[string]string, error) {
-	parts := make(map[string]string)
-	matches := rx.FindStringSubmatch(msg)
-	if len(matches) == 0 {
-		return parts, errors.New("Could not parse protocol message: " + msg)
+//
+// File and directory messages have the form "C<mode> <length> <name>" and
+// timestamp messages "T<mtime> 0 <atime> 0".
+func (t *transfer) parseMessage(msg string) (message, error) {
+	m := message{}
+	parseErr := protocolErrorf("Could not parse protocol message: %s", msg)
+
+	if len(msg) == 0 {
+		return m, parseErr
+	}
+	m.Type = msg[0]
+
+	switch m.Type {
+	case 'C', 'D':
+		rest := msg[1:]
+		// Four octal digits usually, but a leading zero before the special
+		// bits is optional
+		n := strings.IndexByte(rest, ' ')
+		if n < 4 || n > 5 || !isDigits(rest[:n]) {
+			return m, parseErr
+		}
+
+		mode, err := strconv.ParseUint(rest[:n], 8, 32)
+		if err != nil || mode > 07777 {
+			return m, parseErr
+		}
+		m.Mode = fileModeFromUnix(uint32(mode))
+
+		rest = rest[n+1:]
+		i := strings.IndexByte(rest, ' ')
+		if i < 1 || !isDigits(rest[:i]) || i == len(rest)-1 {
+			return m, parseErr
+		}
+
+		m.Length, err = strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return m, parseErr
+		}
+		m.Name = rest[i+1:]
+	case 'T':
+		fields := strings.Split(msg[1:], " ")
+		if len(fields) != 4 || fields[1] != "0" || fields[3] != "0" || !isDigits(fields[0]) || !isDigits(fields[2]) {
+			return m, parseErr
+		}
+
+		var err error
+		if m.Mtime, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+			return m, parseErr
+		}
+		if m.Atime, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return m, parseErr
+		}
+	default:
+		return m, parseErr
 	}
 
-	for i, name := range rx.SubexpNames() {
-		parts[name] = matches[i]
+	return m, nil
+}
+
+// Check that s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if len(s) == 0 {
+		return false
 	}
-	return parts, nil
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 // Go back up one directory.
-func (c *Client) upDirectory() {
-	if len(c.DestinationPath) > 0 {
-		c.DestinationPath = c.DestinationPath[:len(c.DestinationPath)-1]
+func (t *transfer) upDirectory() {
+	if len(t.path) > 0 {
+		t.path = t.path[:len(t.path)-1]
+	}
+
+	if len(t.dirStack) > 0 {
+		f := t.dirStack[len(t.dirStack)-1]
+		t.applyDirMode(f)
+		t.applyTimes(f.path, f.localPath, f.times)
+	}
+	t.leaveDir()
+}
+
+// Give a downloaded directory its mode once its content is written. With
+// PreserveMode it is set exactly, otherwise only owner permissions missing
+// from the mode are removed, as the directory was created with them.
+func (t *transfer) applyDirMode(f dirFrame) {
+	if f.localPath == "" {
+		return
+	}
+
+	mode := f.mode
+	if !t.opts.PreserveMode {
+		if mode&0700 == 0700 {
+			return
+		}
+
+		info, ok, err := t.statLocal(f.localPath)
+		if err != nil {
+			t.addWarning(f.path, fmt.Sprintf("Could not set mode: %s", err))
+			return
+		}
+		if ok {
+			mode = info.Mode().Perm() &^ (0700 &^ mode)
+		}
+	}
+
+	if err := t.writeFS().Chmod(f.localPath, mode); err != nil {
+		t.addWarning(f.path, fmt.Sprintf("Could not set mode: %s", err))
 	}
 }
 
 // Handle each item coming through filepath.Walk.
-func (c *Client) handleItem(path string, info os.FileInfo, err error) error {
+func (t *transfer) handleItem(path string, info os.FileInfo, err error) error {
+	if t.isCancelled() {
+		return ErrCancelled
+	}
+
 	if err != nil {
 		// OS error
-		c.outputInfo(fmt.Sprintf("Item error: %s", err))
+		t.setPhase(PhaseWalk, t.uploadRelPath(path))
+		t.outputInfo(fmt.Sprintf("Item error: %s", err))
 
-		if c.StopOnOSError {
+		if t.opts.StopOnOSError {
 			return err
 		}
+		t.addWarning(t.uploadRelPath(path), fmt.Sprintf("Skipped after error: %s", err))
+		t.countSkipped()
 		return nil
 	}
 
+	t.setPhase(PhaseSend, t.uploadRelPath(path))
+
+	// Files and directories both can follow a subdirectory in walk order
+	if err := t.leaveDirectories(path); err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		// Send the file a link points at, links to directories are not followed
+		if target, err := os.Stat(path); err == nil && !target.IsDir() {
+			info = target
+		}
+	}
+
 	if info.IsDir() {
+		if marker, ok := findMarker(path, t.opts.ExcludeMarkers); ok {
+			t.outputInfo(fmt.Sprintf("Skipping directory marked by %s: %s", marker, path))
+			t.countSkipped()
+			return filepath.SkipDir
+		}
+
 		// Handle directories
-		if len(c.DestinationPath) != 0 {
-			// If not first directory
-			currentPath := strings.Split(filepath.Join(c.DestinationPath...), "/")
-			newPath := strings.Split(path, "/")
-
-			// <= slashes = going back up
-			if len(newPath) <= len(currentPath) {
-				// Send EOD messages for the amount of directories we go up
-				for i := len(newPath) - 1; i < len(currentPath); i++ {
-					c.sendEndOfDirectoryMessage(c.scpStdinPipe)
-				}
+		t.path = append(t.path[:0], path)
+		if t.opts.PreserveTimes {
+			if err := t.sendTimes(path, info); err != nil {
+				return err
 			}
 		}
-		c.DestinationPath = []string{path}
-		c.sendDirectoryMessage(c.scpStdinPipe, 0644, filepath.Base(path))
+		name, err := t.outgoingName(filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		t.sendDirectoryMessage(t.scpStdinPipe, info.Mode()&modeBits, name)
+		if err := t.expectAck(pendingAck{action: fmt.Sprintf("create directory [%q]", path)}); err != nil {
+			return err
+		}
+		t.enterDir(t.uploadRelPath(path))
+	} else if !info.Mode().IsRegular() {
+		// Devices, sockets, pipes and dangling links have no content to send
+		t.addWarning(t.uploadRelPath(path), "Skipped "+fileTypeName(info.Mode()))
+		t.countSkipped()
+	} else if original, ok := t.duplicates[path]; ok {
+		t.outputInfo(fmt.Sprintf("Skipping duplicate of %s: %s", original, path))
 	} else {
 		// Handle regular files
-		targetItem, err := os.Open(path)
-		if err != nil {
+		start := time.Now()
+		size, err := t.sendFile(path, info)
+		t.addResult(t.uploadRelPath(path), size, time.Since(start), skipCause(err))
+
+		if err := t.uploadFailure(err); err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// Send a regular file, returning the size announced to the remote. A
+// *SizeChangedError is returned after the file has been sent in full.
+func (t *transfer) sendFile(path string, info os.FileInfo) (int64, error) {
+	targetItem, err := os.Open(path)
+	if err != nil {
+		return 0, &skippableError{err}
+	}
+	defer targetItem.Close()
+
+	// The open file's size is more recent than the walk's
+	size := info.Size()
+	if current, err := targetItem.Stat(); err == nil {
+		size = current.Size()
+	}
+
+	if t.opts.PreserveTimes {
+		if err := t.sendTimes(path, info); err != nil {
+			return 0, err
+		}
+	}
+
+	return size, t.sendStream(targetItem, path, filepath.Base(path), t.uploadRelPath(path), info.Mode()&modeBits, size)
+}
 
-		c.sendFileMessage(c.scpStdinPipe, 0644, info.Size(), filepath.Base(path))
+// Send a file message for name and size bytes of r as its content. path
+// names the source in output and errors.
+func (t *transfer) sendStream(r io.Reader, path, name, relPath string, mode os.FileMode, size int64) error {
+	name, nameErr := t.outgoingName(name)
+	if nameErr != nil {
+		return &skippableError{nameErr}
+	}
+	t.sendFileMessage(t.scpStdinPipe, mode, size, name)
 
-		if info.Size() > 0 {
-			var w io.Writer
-			if c.ShowProgressBar {
-				bar := c.newProgressBar(int(info.Size()))
-				bar.Start()
-				defer bar.Finish()
+	// The remote answers with a warning instead when it can't create the
+	// file, and doesn't expect its content. Warnings leave it ready for the
+	// next item. The content waits for the answer even with PipelineDepth.
+	ack := pendingAck{relPath: relPath, action: fmt.Sprintf("receive [%q]", path), skippable: true}
+	if err := t.awaitAck(ack); err != nil {
+		return err
+	}
+	t.progress.startFile(relPath)
 
-				w = io.MultiWriter(c.scpStdinPipe, bar)
+	var err error
+	if size > 0 {
+		w, finish := t.progressWriter(stdinWriter{t.scpStdinPipe}, path, size)
+		defer finish()
+
+		t.outputInfo(fmt.Sprintf("Sending file: %s", path))
+		t.midContent = true
+		w = &writeCanceller{Writer: io.MultiWriter(w, &t.progress), cancel: t.cancelled}
+
+		// Checked for growth once the announced size is sent
+		f, _ := r.(*os.File)
+		r = &skipReader{r: r, t: t, relPath: relPath}
+		if t.opts.FileTimeout > 0 {
+			r = newTimeoutReader(r, path, t.opts.FileTimeout)
+		}
+
+		err = t.sendContent(w, r, f, path, size)
+		if errors.Is(err, ErrFileTimeout) || err == ErrSkipFile {
+			// The content was padded, the remote is told the file failed
+			t.midContent = false
+			t.sendWarning(t.scpStdinPipe, err)
+			if err == ErrSkipFile {
+				t.clearSkip()
+				t.progress.abandonFile()
 			} else {
-				w = c.scpStdinPipe
+				t.progress.finishFile()
 			}
 
-			c.outputInfo(fmt.Sprintf("Sending file: %s", path))
-			if _, err := io.Copy(w, targetItem); err != nil {
-				c.sendErr(c.scpStdinPipe)
-				return err
+			// The remote still answers for the file, only a fatal answer
+			// matters more than the timeout
+			ackErr := t.expectAck(ack)
+			if _, skip := ackErr.(*skippableError); ackErr != nil && !skip {
+				return ackErr
+			}
+			return err
+		}
+		if _, ok := err.(*SizeChangedError); err != nil && !ok {
+			if err != ErrCancelled {
+				t.sendErrMsg(t.scpStdinPipe, fmt.Sprintf("%s: %s", path, err))
 			}
+			return fmt.Errorf("Could not send [%q]: %w", path, t.remoteWriteError(err))
+		}
+		t.midContent = false
+
+		t.sendAck(t.scpStdinPipe)
+	} else {
+		t.outputInfo(fmt.Sprintf("Sending empty file: %s", path))
+		t.sendAck(t.scpStdinPipe)
+	}
+
+	// The remote reports errors writing the file, e.g. a full disk
+	if ackErr := t.expectAck(ack); ackErr != nil {
+		t.progress.abandonFile()
+		return ackErr
+	}
+	t.countFile(size)
+	t.progress.finishFile()
+
+	return err
+}
+
+// Send exactly size bytes of r, the length announced to the remote. A file
+// that changed size meanwhile is cut short or padded with zero bytes to keep
+// the protocol in sync, and reported with a *SizeChangedError. f is the file
+// r reads, if any, whose growth is only seen by its current size.
+func (t *transfer) sendContent(w io.Writer, r io.Reader, f *os.File, path string, size int64) error {
+	n, err := io.CopyN(w, r, size)
+	if errors.Is(err, ErrFileTimeout) || err == ErrSkipFile {
+		if _, err := io.CopyN(w, zeroReader{}, size-n); err != nil {
+			return err
+		}
+		return err
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if n < size {
+		if _, err := io.CopyN(w, zeroReader{}, size-n); err != nil {
+			return err
+		}
+		return &SizeChangedError{Path: path, Size: size, CurrentSize: n}
+	}
+
+	if f == nil {
+		return nil
+	}
+	if current, err := f.Stat(); err == nil && current.Size() != size {
+		return &SizeChangedError{Path: path, Size: size, CurrentSize: current.Size()}
+	}
+
+	return nil
+}
+
+// Send end of directory messages for the directories the walk has left
+// before reaching path.
+func (t *transfer) leaveDirectories(path string) error {
+	if len(t.path) == 0 {
+		// First item
+		return nil
+	}
 
-			c.sendAck(c.scpStdinPipe)
-		} else {
-			c.outputInfo(fmt.Sprintf("Sending empty file: %s", path))
-			c.sendAck(c.scpStdinPipe)
+	currentDepth := strings.Count(filepath.Join(t.path...), "/") + 1
+	newDepth := strings.Count(path, "/") + 1
+
+	// <= slashes = going back up
+	if newDepth <= currentDepth {
+		// Send EOD messages for the amount of directories we go up
+		for i := newDepth - 1; i < currentDepth; i++ {
+			if err := t.endDirectory(); err != nil {
+				return err
+			}
 		}
+		t.path = append(t.path[:0], filepath.Dir(path))
 	}
 
 	return nil
 }
 
-func (c *Client) outputInfo(s ...string) {
-	if c.Verbose {
+func (t *transfer) outputInfo(s ...string) {
+	if t.opts.Verbose {
 		log.Println(s)
 	}
 }
 
 // Create a default progress bar.
-func (c *Client) newDefaultProgressBar(fileLength int) *pb.ProgressBar {
-	bar := pb.New(fileLength)
+func newDefaultProgressBar(fileLength int64) *pb.ProgressBar {
+	bar := pb.New64(fileLength)
 	bar.ShowSpeed = true
 	bar.ShowTimeLeft = true
 	bar.ShowCounters = true
@@ -465,27 +1456,147 @@ func (c *Client) newDefaultProgressBar(fileLength int) *pb.ProgressBar {
 }
 
 // Creates a new progress bar based on the current settings.
-func (c *Client) newProgressBar(fileLength int) *pb.ProgressBar {
-	bar := pb.New(fileLength)
-	bar.ShowPercent = c.ProgressBar.ShowPercent
-	bar.ShowCounters = c.ProgressBar.ShowCounters
-	bar.ShowSpeed = c.ProgressBar.ShowSpeed
-	bar.ShowTimeLeft = c.ProgressBar.ShowTimeLeft
-	bar.ShowBar = c.ProgressBar.ShowBar
-	bar.ShowFinalTime = c.ProgressBar.ShowFinalTime
-	bar.Output = c.ProgressBar.Output
-	bar.Callback = c.ProgressBar.Callback
-	bar.NotPrint = c.ProgressBar.NotPrint
-	bar.Units = c.ProgressBar.Units
-	bar.ForceWidth = c.ProgressBar.ForceWidth
-	bar.ManualUpdate = c.ProgressBar.ManualUpdate
-	bar.SetRefreshRate(c.ProgressBar.RefreshRate)
-	bar.SetWidth(c.ProgressBar.Width)
-	bar.SetMaxWidth(c.ProgressBar.Width)
+func (t *transfer) newProgressBar(fileLength int64) *pb.ProgressBar {
+	if t.opts.ProgressBar == nil {
+		return newDefaultProgressBar(fileLength)
+	}
+
+	bar := pb.New64(fileLength)
+	bar.ShowPercent = t.opts.ProgressBar.ShowPercent
+	bar.ShowCounters = t.opts.ProgressBar.ShowCounters
+	bar.ShowSpeed = t.opts.ProgressBar.ShowSpeed
+	bar.ShowTimeLeft = t.opts.ProgressBar.ShowTimeLeft
+	bar.ShowBar = t.opts.ProgressBar.ShowBar
+	bar.ShowFinalTime = t.opts.ProgressBar.ShowFinalTime
+	bar.Output = t.opts.ProgressBar.Output
+	bar.Callback = t.opts.ProgressBar.Callback
+	bar.NotPrint = t.opts.ProgressBar.NotPrint
+	bar.Units = t.opts.ProgressBar.Units
+	bar.ForceWidth = t.opts.ProgressBar.ForceWidth
+	bar.ManualUpdate = t.opts.ProgressBar.ManualUpdate
+	bar.SetRefreshRate(t.opts.ProgressBar.RefreshRate)
+	bar.SetWidth(t.opts.ProgressBar.Width)
+	bar.SetMaxWidth(t.opts.ProgressBar.Width)
 
 	return bar
 }
 
+// Writer for progress output from the ProgressOutputs option, nil when
+// unset. Writes go to every writer in turn, one at a time.
+func (t *transfer) progressOutput() io.Writer {
+	if len(t.opts.ProgressOutputs) == 0 {
+		return nil
+	}
+	return &lockedWriter{mu: &t.progressMu, w: io.MultiWriter(t.opts.ProgressOutputs...)}
+}
+
+// Writer allowing one write at a time.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// Start a progress bar for a file. The returned func finishes the bar.
+func (t *transfer) startProgressBar(fileLength int64) (*pb.ProgressBar, func()) {
+	bar := t.newProgressBar(fileLength)
+
+	out := t.progressOutput()
+	if out != nil {
+		// The bar ends its last line on stdout unless NotPrint is set
+		bar.Output = out
+		bar.NotPrint = true
+	}
+
+	// pb's own refresher isn't waited for by Finish, this one is
+	refresh := !bar.ManualUpdate
+	bar.ManualUpdate = true
+	bar.Start()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if !refresh {
+			return
+		}
+
+		rate := bar.RefreshRate
+		if rate <= 0 {
+			rate = pb.DEFAULT_REFRESH_RATE
+		}
+		ticker := time.NewTicker(rate)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				bar.Update()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return bar, func() {
+		close(stop)
+		<-stopped
+
+		bar.Finish()
+		if out != nil {
+			fmt.Fprintln(out)
+		}
+	}
+}
+
+// Create a plain progress counter for a file.
+func (t *transfer) newPlainProgressCounter(name string, fileLength int64) *plainProgressCounter {
+	counter := newPlainProgressCounter(t.opts.PlainProgress, name, fileLength)
+	counter.out = t.progressOutput()
+
+	return counter
+}
+
+// Wrap the network side of a download with a progress bar when enabled.
+// The returned func finishes the bar.
+func (t *transfer) progressReader(r io.Reader, name string, fileLength int64) (io.Reader, func()) {
+	if !t.opts.ShowProgressBar || fileLength < t.opts.ProgressMinSize {
+		return r, func() {}
+	}
+
+	if t.opts.PlainProgress != nil {
+		counter := t.newPlainProgressCounter(name, fileLength)
+		return io.TeeReader(r, counter), counter.finish
+	}
+
+	bar, finish := t.startProgressBar(fileLength)
+
+	return bar.NewProxyReader(r), finish
+}
+
+// Wrap the network side of an upload with a progress bar when enabled.
+// The returned func finishes the bar.
+func (t *transfer) progressWriter(w io.Writer, name string, fileLength int64) (io.Writer, func()) {
+	if !t.opts.ShowProgressBar || fileLength < t.opts.ProgressMinSize {
+		return w, func() {}
+	}
+
+	if t.opts.PlainProgress != nil {
+		counter := t.newPlainProgressCounter(name, fileLength)
+		return io.MultiWriter(w, counter), counter.finish
+	}
+
+	bar, finish := t.startProgressBar(fileLength)
+
+	return io.MultiWriter(w, bar), finish
+}
+
 // Wrapper to support cancellation.
 type readCanceller struct {
 	*bufio.Reader
@@ -498,8 +1609,26 @@ type readCanceller struct {
 func (r *readCanceller) Read(p []byte) (n int, err error) {
 	select {
 	case <-r.cancel:
-		return 0, errors.New("Transfer cancelled")
+		return 0, ErrCancelled
 	default:
 		return r.Reader.Read(p)
 	}
 }
+
+// Wrapper to stop writing file content on cancellation.
+type writeCanceller struct {
+	io.Writer
+
+	// Cancel an ongoing transfer
+	cancel chan struct{}
+}
+
+// Additional cancellation check.
+func (w *writeCanceller) Write(p []byte) (n int, err error) {
+	select {
+	case <-w.cancel:
+		return 0, ErrCancelled
+	default:
+		return w.Writer.Write(p)
+	}
+}
@@ -3,6 +3,8 @@ package goscp
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,13 +13,20 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
 var (
 	// Items created during testing
 	created []string
+
+	// Regex versions of the protocol messages, used to cross-check parseMessage
+	fileCopyRx  = regexp.MustCompile(`^C(?P<mode>[0-7]{4,5}) (?P<length>\d+) (?P<filename>.+)$`)
+	dirCopyRx   = regexp.MustCompile(`^D(?P<mode>[0-7]{4,5}) (?P<length>\d+) (?P<dirname>.+)$`)
+	timestampRx = regexp.MustCompile(`^T(?P<mtime>\d+) 0 (?P<atime>\d+) 0$`)
 )
 
 func TestMain(m *testing.M) {
@@ -49,6 +58,20 @@ func expectedError(t *testing.T, received, expected interface{}) {
 	t.Errorf("received: %q, expected: %q", received, expected)
 }
 
+// Break down protocol messages using the regex definitions.
+func parseMessageRx(msg string, rx *regexp.Regexp) (map[string]string, error) {
+	parts := make(map[string]string)
+	matches := rx.FindStringSubmatch(msg)
+	if len(matches) == 0 {
+		return parts, errors.New("Could not parse protocol message: " + msg)
+	}
+
+	for i, name := range rx.SubexpNames() {
+		parts[name] = matches[i]
+	}
+	return parts, nil
+}
+
 func TestUpDirectory(t *testing.T) {
 	tests := []struct {
 		Input    []string
@@ -71,55 +94,222 @@ func TestUpDirectory(t *testing.T) {
 		},
 	}
 
-	c := Client{}
+	c := &transfer{}
 	for _, v := range tests {
-		c.DestinationPath = v.Input
+		c.path = v.Input
 		c.upDirectory()
 
 		// Check paths match
-		if !reflect.DeepEqual(c.DestinationPath, v.Expected) {
-			expectedError(t, c.DestinationPath, v.Expected)
+		if !reflect.DeepEqual(c.path, v.Expected) {
+			expectedError(t, c.path, v.Expected)
 		}
 	}
 }
 
+func TestTransferErrors(t *testing.T) {
+	c := &Client{}
+	c.addError(errors.New("previous transfer"))
+
+	// Errors of other transfers don't count
+	tr := c.startTransfer(TransferOptions{})
+	if err := tr.contextError(context.Background()); err != nil {
+		expectedError(t, err, nil)
+	}
+
+	tr.addError(errors.New("root cause"))
+	tr.addError(errors.New("Process exited with status 1"))
+	if err := tr.contextError(context.Background()); err == nil || err.Error() != "root cause" {
+		expectedError(t, err, "root cause")
+	}
+
+	// The full stack is kept
+	if len(c.GetErrorStack()) != 3 {
+		expectedError(t, len(c.GetErrorStack()), 3)
+	}
+
+	c.endTransfer(tr)
+	if len(c.transfers) != 0 {
+		expectedError(t, len(c.transfers), 0)
+	}
+
+	// Scoped to the transfer
+	errs := (&Transfer{t: tr}).Errors()
+	if len(errs) != 2 || errs[0].Error() != "root cause" {
+		expectedError(t, errs, "[root cause Process exited with status 1]")
+	}
+
+	if c.ErrorCount() != 3 {
+		expectedError(t, c.ErrorCount(), 3)
+	}
+	c.ClearErrors()
+	if c.ErrorCount() != 0 || c.GetLastError() != nil {
+		expectedError(t, c.GetErrorStack(), nil)
+	}
+}
+
+func TestContextCancelledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Returns before a session is opened
+	c := Client{}
+	if err := c.DownloadContext(ctx, "/remote"); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+	if err := c.UploadContext(ctx, "local"); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+}
+
+func TestSendTreeKeepsOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscp-options")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("hi"), 0644)
+
+	c := &Client{}
+	c.SetDestinationPath("/remote")
+	tr := c.startTransfer(c.TransferOptions)
+	tr.scpStdinPipe = nopWriteCloser{&bytes.Buffer{}}
+	tr.scpStdoutPipe = acceptingRemote()
+	if err := tr.sendTree(dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// Walk bookkeeping doesn't leak into the options
+	if !reflect.DeepEqual(c.DestinationPath, []string{"/remote"}) {
+		expectedError(t, c.DestinationPath, []string{"/remote"})
+	}
+	if !reflect.DeepEqual(tr.opts.DestinationPath, []string{"/remote"}) {
+		expectedError(t, tr.opts.DestinationPath, []string{"/remote"})
+	}
+}
+
+func TestContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Not cancelled
+	c := &transfer{}
+	c.addError(errors.New("root cause"))
+	if err := c.contextError(ctx); err == nil || err.Error() != "root cause" {
+		expectedError(t, err, "root cause")
+	}
+
+	// Cancellation wins over errors it caused
+	cancel()
+	c = &transfer{}
+	c.addError(errors.New("read on closed session"))
+	c.addError(ctx.Err())
+	if err := c.contextError(ctx); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+}
+
 func TestParseMessage(t *testing.T) {
 	tests := []struct {
 		Input         string
 		Regex         *regexp.Regexp
-		Expected      map[string]string
+		Expected      message
 		ExpectedError string
 	}{
 		{
 			// Create file message
 			Input: "C0644 25 helloworld.txt",
 			Regex: fileCopyRx,
-			Expected: map[string]string{
-				"":         "C0644 25 helloworld.txt",
-				"mode":     "0644",
-				"length":   "25",
-				"filename": "helloworld.txt",
+			Expected: message{
+				Type:   'C',
+				Mode:   0644,
+				Length: 25,
+				Name:   "helloworld.txt",
+			},
+		},
+		{
+			// Create file message with spaces in the name
+			Input: "C0644 25 hello world.txt",
+			Regex: fileCopyRx,
+			Expected: message{
+				Type:   'C',
+				Mode:   0644,
+				Length: 25,
+				Name:   "hello world.txt",
 			},
 		},
 		{
 			// Create directory message
 			Input: "D0755 0 mydir",
 			Regex: dirCopyRx,
-			Expected: map[string]string{
-				"":        "D0755 0 mydir",
-				"mode":    "0755",
-				"length":  "0",
-				"dirname": "mydir",
+			Expected: message{
+				Type:   'D',
+				Mode:   0755,
+				Length: 0,
+				Name:   "mydir",
+			},
+		},
+		{
+			// Leading and trailing spaces belong to the name
+			Input: "C0644 25  hello world.txt ",
+			Regex: fileCopyRx,
+			Expected: message{
+				Type:   'C',
+				Mode:   0644,
+				Length: 25,
+				Name:   " hello world.txt ",
+			},
+		},
+		{
+			// Names are bytes, not necessarily UTF-8
+			Input: "C0644 25 caf\xe9.txt",
+			Regex: fileCopyRx,
+			Expected: message{
+				Type:   'C',
+				Mode:   0644,
+				Length: 25,
+				Name:   "caf\xe9.txt",
+			},
+		},
+		{
+			// Setuid file
+			Input: "C4755 8 run.sh",
+			Regex: fileCopyRx,
+			Expected: message{
+				Type:   'C',
+				Mode:   os.ModeSetuid | 0755,
+				Length: 8,
+				Name:   "run.sh",
+			},
+		},
+		{
+			// Setgid file with a leading zero
+			Input: "C02755 8 run.sh",
+			Regex: fileCopyRx,
+			Expected: message{
+				Type:   'C',
+				Mode:   os.ModeSetgid | 0755,
+				Length: 8,
+				Name:   "run.sh",
+			},
+		},
+		{
+			// Sticky directory
+			Input: "D1777 0 tmp",
+			Regex: dirCopyRx,
+			Expected: message{
+				Type:   'D',
+				Mode:   os.ModeSticky | 0777,
+				Length: 0,
+				Name:   "tmp",
 			},
 		},
 		{
 			// Timestamp message
 			Input: "T1234567890 0 9876543210 0",
 			Regex: timestampRx,
-			Expected: map[string]string{
-				"":      "T1234567890 0 9876543210 0",
-				"mtime": "1234567890",
-				"atime": "9876543210",
+			Expected: message{
+				Type:  'T',
+				Mtime: 1234567890,
+				Atime: 9876543210,
 			},
 		},
 		{
@@ -128,11 +318,42 @@ func TestParseMessage(t *testing.T) {
 			Regex:         fileCopyRx,
 			ExpectedError: "Could not parse protocol message: Invalid msg",
 		},
+		{
+			// Missing name
+			Input:         "C0644 25 ",
+			Regex:         fileCopyRx,
+			ExpectedError: "Could not parse protocol message: C0644 25 ",
+		},
+		{
+			// Signed length
+			Input:         "C0644 -25 name",
+			Regex:         fileCopyRx,
+			ExpectedError: "Could not parse protocol message: C0644 -25 name",
+		},
+		{
+			// Too many mode digits
+			Input:         "C007755 8 run.sh",
+			Regex:         fileCopyRx,
+			ExpectedError: "Could not parse protocol message: C007755 8 run.sh",
+		},
+		{
+			// Truncated timestamp message
+			Input:         "T1234567890 0 9876543210",
+			Regex:         timestampRx,
+			ExpectedError: "Could not parse protocol message: T1234567890 0 9876543210",
+		},
 	}
 
-	c := Client{}
+	c := &transfer{}
 	for _, v := range tests {
-		output, err := c.parseMessage(v.Input, v.Regex)
+		output, err := c.parseMessage(v.Input)
+
+		// The regex parser must agree on what is a valid message
+		parts, rxErr := parseMessageRx(v.Input, v.Regex)
+		if (err == nil) != (rxErr == nil) {
+			expectedError(t, err, rxErr)
+		}
+
 		if err != nil {
 			if err.Error() != v.ExpectedError {
 				expectedError(t, err, v.ExpectedError)
@@ -140,10 +361,73 @@ func TestParseMessage(t *testing.T) {
 			continue
 		}
 
-		// Check parts match
+		// Check message matches
 		if !reflect.DeepEqual(output, v.Expected) {
 			expectedError(t, output, v.Expected)
 		}
+
+		// Check message matches the regex parser
+		for name, value := range parts {
+			var field string
+			switch name {
+			case "mode":
+				field = fmt.Sprintf("%04o", unixFromFileMode(output.Mode))
+				if len(value) > 4 {
+					value = strings.TrimPrefix(value, "0")
+				}
+			case "length":
+				field = fmt.Sprint(output.Length)
+			case "filename", "dirname":
+				field = output.Name
+			case "mtime":
+				field = fmt.Sprint(output.Mtime)
+			case "atime":
+				field = fmt.Sprint(output.Atime)
+			default:
+				continue
+			}
+
+			if field != value {
+				expectedError(t, field, value)
+			}
+		}
+	}
+}
+
+func TestSendModes(t *testing.T) {
+	tests := []struct {
+		Mode     os.FileMode
+		Dir      bool
+		Expected string
+	}{
+		{Mode: 0644, Expected: "C0644 5 name\n"},
+		{Mode: 0, Expected: "C0000 5 name\n"},
+		{Mode: os.ModeSetuid | 0755, Expected: "C4755 5 name\n"},
+		{Mode: os.ModeSetuid | os.ModeSetgid | 0750, Expected: "C6750 5 name\n"},
+		{Mode: os.ModeSticky | 0777, Dir: true, Expected: "D1777 0 name\n"},
+	}
+
+	c := &transfer{}
+	for _, v := range tests {
+		buf := &bytes.Buffer{}
+		if v.Dir {
+			c.sendDirectoryMessage(buf, v.Mode, "name")
+		} else {
+			c.sendFileMessage(buf, v.Mode, 5, "name")
+		}
+
+		if buf.String() != v.Expected {
+			expectedError(t, buf.String(), v.Expected)
+		}
+
+		// What is sent must parse back to the same mode
+		m, err := c.parseMessage(strings.TrimSuffix(buf.String(), "\n"))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if m.Mode != v.Mode {
+			expectedError(t, m.Mode, v.Mode)
+		}
 	}
 }
 
@@ -167,12 +451,12 @@ func TestDirectory(t *testing.T) {
 	}
 
 	for _, v := range tests {
-		c := Client{}
-		c.SetDestinationPath(v.StartPath)
+		c := &transfer{scpStdinPipe: nopWriteCloser{&bytes.Buffer{}}}
+		c.path = []string{v.StartPath}
 		c.directory(v.InputPath)
 
 		// Check dir was created
-		path := filepath.Join(c.DestinationPath...)
+		path := filepath.Join(c.path...)
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			expectedError(t, err, path)
 			continue
@@ -180,9 +464,54 @@ func TestDirectory(t *testing.T) {
 		created = append(created, path)
 
 		// Check destination paths match
-		if !reflect.DeepEqual(c.DestinationPath, v.ExpectedDestinationPath) {
-			expectedError(t, c.DestinationPath, v.ExpectedDestinationPath)
+		if !reflect.DeepEqual(c.path, v.ExpectedDestinationPath) {
+			expectedError(t, c.path, v.ExpectedDestinationPath)
+		}
+	}
+}
+
+func TestDirectoryModes(t *testing.T) {
+	for _, preserve := range []bool{true, false} {
+		tmp, err := ioutil.TempDir("", "goscp-dirmode")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
 		}
+
+		input := "D0500 0 ro\nC0644 2 a.txt\nhi\x00E\nD0750 0 priv\nE\n"
+		c := &transfer{
+			opts:          TransferOptions{PreserveMode: preserve},
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+		}
+		c.path = []string{tmp}
+		c.rootDepth = 1
+		c.handleDownload()
+
+		if len(c.errors) != 0 {
+			t.Error("Unexpected errors:", c.errors)
+		}
+
+		// The content of a read-only directory is still written
+		if content, _ := ioutil.ReadFile(filepath.Join(tmp, "ro", "a.txt")); string(content) != "hi" {
+			expectedError(t, string(content), "hi")
+		}
+
+		for name, mode := range map[string]os.FileMode{"ro": 0500, "priv": 0750} {
+			info, err := os.Stat(filepath.Join(tmp, name))
+			if err != nil {
+				t.Error("Unexpected error:", err)
+				continue
+			}
+
+			// Without PreserveMode the umask may remove more
+			got := info.Mode().Perm()
+			if preserve && got != mode || !preserve && got&^mode != 0 {
+				expectedError(t, got, mode)
+			}
+		}
+
+		os.Chmod(filepath.Join(tmp, "ro"), 0700)
+		os.RemoveAll(tmp)
 	}
 }
 
@@ -214,10 +543,11 @@ func TestFile(t *testing.T) {
 	}
 
 	for _, v := range tests {
-		c := Client{}
-		c.SetDestinationPath(v.StartPath)
+		c := &transfer{scpStdinPipe: nopWriteCloser{&bytes.Buffer{}}}
+		c.path = []string{v.StartPath}
 
-		dummy := bytes.NewBuffer([]byte(v.FileContent))
+		// Content is followed by the source's status byte
+		dummy := bytes.NewBuffer([]byte(v.FileContent + "\x00"))
 		rdr := &readCanceller{Reader: bufio.NewReader(dummy)}
 		c.scpStdoutPipe = rdr
 
@@ -239,11 +569,132 @@ func TestFile(t *testing.T) {
 	}
 }
 
+func TestPreserveMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-mode")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		PreserveMode bool
+		Message      string
+		ExpectedMode os.FileMode
+	}{
+		{
+			PreserveMode: true,
+			Message:      "C0750 2 run.sh",
+			ExpectedMode: 0750,
+		},
+		{
+			// Read-only files can still be written
+			PreserveMode: true,
+			Message:      "C0400 2 secret.txt",
+			ExpectedMode: 0400,
+		},
+		{
+			// Default permissions, less the umask
+			Message:      "C0777 2 plain.txt",
+			ExpectedMode: 0666,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{
+			opts:          TransferOptions{PreserveMode: v.PreserveMode},
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader("hi\x00"))},
+		}
+		c.path = []string{tmp}
+
+		if err := c.file(v.Message); err != nil {
+			t.Error("Unexpected error:", err)
+			continue
+		}
+
+		name := strings.Fields(v.Message)[2]
+		info, err := os.Stat(filepath.Join(tmp, name))
+		if err != nil {
+			t.Error("Unexpected error:", err)
+			continue
+		}
+		if v.PreserveMode && info.Mode().Perm() != v.ExpectedMode {
+			expectedError(t, info.Mode().Perm(), v.ExpectedMode)
+		}
+		if !v.PreserveMode && info.Mode().Perm()&^v.ExpectedMode != 0 {
+			expectedError(t, info.Mode().Perm(), v.ExpectedMode)
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-continue")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// A directory in the way makes creating a.txt fail
+	if err := os.Mkdir(filepath.Join(tmp, "a.txt"), 0755); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	input := "C0644 3 a.txt\n\x01scp: b.txt: Permission denied\nC0644 2 c.txt\nhi\x00"
+	createErr := fmt.Sprintf("open %s/a.txt: is a directory", tmp)
+
+	tests := []struct {
+		ContinueOnError bool
+		ExpectedOutput  string
+		ExpectedErrors  int
+		ExpectedFile    bool
+	}{
+		{
+			// Stop at the first failing item
+			ContinueOnError: false,
+			ExpectedOutput:  "\x00",
+			ExpectedErrors:  1,
+		},
+		{
+			// Skip a.txt locally and b.txt remotely
+			ContinueOnError: true,
+			ExpectedOutput:  "\x00\x01scp: " + createErr + "\n\x00\x00",
+			ExpectedErrors:  2,
+			ExpectedFile:    true,
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		c := &transfer{
+			opts:          TransferOptions{ContinueOnError: v.ContinueOnError},
+			scpStdinPipe:  nopWriteCloser{out},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+		}
+		c.path = []string{tmp}
+		c.handleDownload()
+
+		if out.String() != v.ExpectedOutput {
+			expectedError(t, out.String(), v.ExpectedOutput)
+		}
+		if len(c.errors) != v.ExpectedErrors {
+			expectedError(t, c.errors, v.ExpectedErrors)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(tmp, "c.txt"))
+		if v.ExpectedFile && string(content) != "hi" {
+			expectedError(t, string(content), "hi")
+		}
+		if !v.ExpectedFile && err == nil {
+			expectedError(t, string(content), "no file")
+		}
+	}
+}
+
 func TestHandleItem(t *testing.T) {
 	tests := []struct {
 		Type                    string
 		Name                    string
 		Content                 []byte
+		Mode                    os.FileMode
 		ExpectedMessages        []string
 		DestinationPath         []string
 		ExpectedDestinationPath []string
@@ -259,6 +710,18 @@ func TestHandleItem(t *testing.T) {
 				"\x00\n",
 			},
 		},
+		{
+			// Executable file keeps its mode
+			Type:    "file",
+			Name:    "goscp-test-script.sh",
+			Content: []byte("exit 0\n"),
+			Mode:    0755,
+			ExpectedMessages: []string{
+				"C0755 7 goscp-test-script.sh\n",
+				"exit 0\n",
+				"\x00\n",
+			},
+		},
 		{
 			// Empty file creation
 			Type:    "file",
@@ -277,7 +740,7 @@ func TestHandleItem(t *testing.T) {
 			ExpectedMessages: []string{
 				"E\n",
 				"E\n",
-				"D0644 0 two\n",
+				"D0755 0 two\n",
 			},
 			DestinationPath:         []string{"goscp-test-dir", "hello", "one"},
 			ExpectedDestinationPath: []string{"goscp-test-dir/two"},
@@ -288,7 +751,7 @@ func TestHandleItem(t *testing.T) {
 			Name: "goscp-test-dir/one",
 			ExpectedMessages: []string{
 				"E\n",
-				"D0644 0 one\n",
+				"D0755 0 one\n",
 			},
 			DestinationPath:         []string{"goscp-test-dir", "two"},
 			ExpectedDestinationPath: []string{"goscp-test-dir/one"},
@@ -298,7 +761,7 @@ func TestHandleItem(t *testing.T) {
 			Type: "directory",
 			Name: "goscp-test-dir/one/two",
 			ExpectedMessages: []string{
-				"D0644 0 two\n",
+				"D0755 0 two\n",
 			},
 			DestinationPath:         []string{"goscp-test-dir", "one"},
 			ExpectedDestinationPath: []string{"goscp-test-dir/one/two"},
@@ -309,7 +772,7 @@ func TestHandleItem(t *testing.T) {
 			Name: "goscp-test-dir",
 			ExpectedMessages: []string{
 				"E\n",
-				"D0644 0 goscp-test-dir\n",
+				"D0755 0 goscp-test-dir\n",
 			},
 			DestinationPath:         []string{"."},
 			ExpectedDestinationPath: []string{"goscp-test-dir"},
@@ -318,10 +781,7 @@ func TestHandleItem(t *testing.T) {
 
 	for _, v := range tests {
 		r, w := io.Pipe()
-		c := Client{
-			scpStdinPipe:    w,
-			ShowProgressBar: false,
-		}
+		c := &transfer{scpStdinPipe: w, scpStdoutPipe: acceptingRemote()}
 
 		filePath := v.Name
 		var stats os.FileInfo
@@ -331,6 +791,10 @@ func TestHandleItem(t *testing.T) {
 				t.Error("Unexpected error:", err)
 			}
 
+			if v.Mode == 0 {
+				v.Mode = 0644
+			}
+			f.Chmod(v.Mode)
 			f.Write(v.Content)
 			f.Close()
 		} else if v.Type == "directory" {
@@ -338,8 +802,9 @@ func TestHandleItem(t *testing.T) {
 			if err != nil {
 				t.Error("Unexpected error:", err)
 			}
+			os.Chmod(filePath, 0755)
 
-			c.DestinationPath = v.DestinationPath
+			c.path = v.DestinationPath
 		}
 
 		created = append(created, filePath)
@@ -373,8 +838,8 @@ func TestHandleItem(t *testing.T) {
 			// Output one more newline for convenience in reading from the pipe
 			fmt.Fprintf(c.scpStdinPipe, "\n")
 		} else if v.Type == "directory" {
-			if !reflect.DeepEqual(c.DestinationPath, v.ExpectedDestinationPath) {
-				expectedError(t, c.DestinationPath, v.ExpectedDestinationPath)
+			if !reflect.DeepEqual(c.path, v.ExpectedDestinationPath) {
+				expectedError(t, c.path, v.ExpectedDestinationPath)
 			}
 		}
 
@@ -382,23 +847,219 @@ func TestHandleItem(t *testing.T) {
 	}
 }
 
+func TestUploadAcks(t *testing.T) {
+	tests := []struct {
+		Remote         string
+		ExpectedOutput string
+		ExpectedError  bool
+		ExpectedCause  error
+		Skippable      bool
+	}{
+		{
+			// Accepted
+			Remote:         "\x00\x00",
+			ExpectedOutput: "C0644 3 a.txt\nabc\x00",
+		},
+		{
+			// The remote can't create the file and doesn't want its content
+			Remote:         "\x01scp: a.txt: Permission denied\n",
+			ExpectedError:  true,
+			ExpectedOutput: "C0644 3 a.txt\n",
+			ExpectedCause:  ErrPermissionDenied,
+			Skippable:      true,
+		},
+		{
+			// The remote fails to write the content
+			Remote:         "\x00\x01scp: a.txt: No space left on device\n",
+			ExpectedError:  true,
+			ExpectedOutput: "C0644 3 a.txt\nabc\x00",
+			Skippable:      true,
+		},
+		{
+			// Fatal error
+			Remote:         "\x02scp: protocol error\n",
+			ExpectedError:  true,
+			ExpectedOutput: "C0644 3 a.txt\n",
+		},
+		{
+			// The remote went away
+			Remote:         "\x00",
+			ExpectedError:  true,
+			ExpectedOutput: "C0644 3 a.txt\nabc\x00",
+			ExpectedCause:  io.EOF,
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		c := &transfer{
+			scpStdinPipe:  nopWriteCloser{out},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(v.Remote))},
+		}
+
+		err := c.sendStream(strings.NewReader("abc"), "a.txt", "a.txt", "a.txt", 0644, 3)
+		if out.String() != v.ExpectedOutput {
+			expectedError(t, out.String(), v.ExpectedOutput)
+		}
+		if (err != nil) != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*skippableError); ok != v.Skippable {
+			expectedError(t, ok, v.Skippable)
+		}
+		if v.ExpectedCause != nil && !errors.Is(err, v.ExpectedCause) {
+			expectedError(t, err, v.ExpectedCause)
+		}
+		if !strings.Contains(err.Error(), "a.txt") {
+			expectedError(t, err, "error naming a.txt")
+		}
+	}
+}
+
+func TestUploadReadError(t *testing.T) {
+	out := &bytes.Buffer{}
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+
+	r := io.MultiReader(strings.NewReader("ab"), iotest.ErrReader(errors.New("input/output error\nat sector 7")))
+	err := c.sendStream(r, "a.txt", "a.txt", "a.txt", 0644, 3)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// The remote is told why, on a single line
+	expected := "C0644 3 a.txt\nab\x02scp: a.txt: input/output error at sector 7\n"
+	if out.String() != expected {
+		expectedError(t, out.String(), expected)
+	}
+}
+
+func TestUploadDirectoryRefused(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-refused")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader("\x01scp: dir: Permission denied\n"))},
+	}
+
+	err = c.sendTree(tmp)
+	if !errors.Is(err, ErrPermissionDenied) {
+		expectedError(t, err, ErrPermissionDenied)
+	}
+	if len(c.dirStack) != 0 {
+		expectedError(t, c.dirStack, "no directory entered")
+	}
+}
+
+func TestSendContentSizeChanged(t *testing.T) {
+	tests := []struct {
+		Content       string
+		Size          int64
+		Expected      string
+		ExpectedError error
+	}{
+		{
+			Content:  "hello",
+			Size:     5,
+			Expected: "hello",
+		},
+		{
+			// Shrank after the C message was sent
+			Content:       "hel",
+			Size:          5,
+			Expected:      "hel\x00\x00",
+			ExpectedError: &SizeChangedError{Size: 5, CurrentSize: 3},
+		},
+		{
+			// Grew after the C message was sent
+			Content:       "hello world",
+			Size:          5,
+			Expected:      "hello",
+			ExpectedError: &SizeChangedError{Size: 5, CurrentSize: 11},
+		},
+	}
+
+	for _, v := range tests {
+		f, err := ioutil.TempFile("", "goscp-size")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		f.WriteString(v.Content)
+		f.Seek(0, io.SeekStart)
+
+		c := &transfer{}
+		out := &bytes.Buffer{}
+		err = c.sendContent(out, f, f, f.Name(), v.Size)
+
+		if out.String() != v.Expected {
+			expectedError(t, out.String(), v.Expected)
+		}
+
+		if v.ExpectedError != nil {
+			v.ExpectedError.(*SizeChangedError).Path = f.Name()
+		}
+		if !reflect.DeepEqual(err, v.ExpectedError) {
+			expectedError(t, err, v.ExpectedError)
+		}
+
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestSendStreamGrown(t *testing.T) {
+	f, err := ioutil.TempFile("", "goscp-size")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Grew after the C message announced 5 bytes, with the readers of the
+	// skip and timeout options in between
+	f.WriteString("hello world")
+	f.Seek(0, io.SeekStart)
+
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts:          TransferOptions{FileTimeout: time.Minute},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	err = c.sendStream(f, f.Name(), "grown.txt", "grown.txt", 0644, 5)
+
+	var sizeErr *SizeChangedError
+	if !errors.As(err, &sizeErr) || sizeErr.CurrentSize != 11 {
+		expectedError(t, err, &SizeChangedError{Path: f.Name(), Size: 5, CurrentSize: 11})
+	}
+	if expected := "C0644 5 grown.txt\nhello\x00"; out.String() != expected {
+		expectedError(t, out.String(), expected)
+	}
+}
+
 func TestCancel(t *testing.T) {
 	// Send creation message
 	// Cancel
-	// Send another creation message
+	// Nothing more is sent
 	testsMessages := []string{
 		"C0644 15 goscp-cancel.txt",
 		"Cancel incoming\x00",
-		"C0644 15 goscp-cancel.txt",
-		"Transfer cancelled",
-		"io: read/write on closed pipe",
 	}
 
 	r, w := io.Pipe()
-	c := Client{
-		scpStdinPipe:    w,
-		ShowProgressBar: false,
-	}
+	client := &Client{}
+	c := client.startTransfer(TransferOptions{})
+	c.scpStdinPipe = w
+	c.scpStdoutPipe = acceptingRemote()
 
 	filePath := "goscp-cancel.txt"
 	f, err := os.Create(filePath)
@@ -413,31 +1074,23 @@ func TestCancel(t *testing.T) {
 	stats, _ := os.Stat(filePath)
 	msgCounter := 0
 
+	done := make(chan struct{})
 	go func() {
-		c.scpStdoutPipe = &readCanceller{
-			Reader: bufio.NewReader(r),
-			cancel: make(chan struct{}, 1),
-		}
-
-		scanner := bufio.NewScanner(c.scpStdoutPipe)
+		defer close(done)
 
+		scanner := bufio.NewScanner(r)
 		for scanner.Scan() {
 			txt := scanner.Text()
 
+			if msgCounter >= len(testsMessages) {
+				expectedError(t, txt, "nothing")
+				continue
+			}
 			if txt != testsMessages[msgCounter] {
 				expectedError(t, txt, testsMessages[msgCounter])
 			}
 			msgCounter++
 		}
-
-		err := scanner.Err()
-		if err != nil {
-			if err.Error() != testsMessages[msgCounter] {
-				expectedError(t, err.Error(), testsMessages[msgCounter])
-			}
-			msgCounter++
-		}
-		c.scpStdinPipe.Close()
 	}()
 
 	err = c.handleItem(filePath, stats, nil)
@@ -448,18 +1101,169 @@ func TestCancel(t *testing.T) {
 	// Output one more newline for convenience in reading from the pipe
 	fmt.Fprintf(c.scpStdinPipe, "\n")
 
-	go c.Cancel()
-
-	time.Sleep(time.Millisecond * 100)
+	client.Cancel()
 
 	err = c.handleItem(filePath, stats, nil)
+	if err != ErrCancelled {
+		expectedError(t, err, ErrCancelled)
+	}
+
+	w.Close()
+	<-done
+
+	if msgCounter != len(testsMessages) {
+		expectedError(t, msgCounter, len(testsMessages))
+	}
+}
+
+func TestNotifyCancel(t *testing.T) {
+	tests := []struct {
+		Upload         bool
+		Input          string
+		ExpectedOutput string
+	}{
+		{
+			// The source gets an error instead of the next ack
+			Input:          "C0644 5 a.txt\nabcde\x00",
+			ExpectedOutput: "\x00\x02scp: Transfer cancelled\n",
+		},
+		{
+			// The sink gets an error instead of the next item
+			Upload:         true,
+			Input:          "\x00",
+			ExpectedOutput: "\x02scp: Transfer cancelled\n",
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		in := strings.NewReader(v.Input)
+
+		c := (&Client{}).startTransfer(TransferOptions{})
+		c.scpStdinPipe = nopWriteCloser{out}
+		c.scpStdoutPipe = &readCanceller{Reader: bufio.NewReader(in), cancel: c.cancelled}
+		c.cancel()
+
+		if v.Upload {
+			c.handleUpload(func() error { return c.sendTree(".") })
+		} else {
+			c.handleDownload()
+		}
+
+		if out.String() != v.ExpectedOutput {
+			expectedError(t, out.String(), v.ExpectedOutput)
+		}
+		if in.Len() != 0 || c.scpStdoutPipe.Buffered() != 0 {
+			expectedError(t, in.Len(), "session drained")
+		}
+		if len(c.errors) == 0 || !errors.Is(c.errors[0], ErrCancelled) {
+			expectedError(t, c.errors, ErrCancelled)
+		}
+	}
+}
+
+func TestProgressWrappers(t *testing.T) {
+	f, err := ioutil.TempFile("", "goscp-progress")
 	if err != nil {
-		if err.Error() != testsMessages[msgCounter] {
-			expectedError(t, err.Error(), testsMessages[msgCounter])
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	c := &transfer{opts: NewClient(nil).TransferOptions}
+	c.opts.ProgressBar.NotPrint = true
+
+	// No progress bar, the file is passed through untouched
+	c.opts.ShowProgressBar = false
+	r, finish := c.progressReader(f, "f", 10)
+	if r != io.Reader(f) {
+		expectedError(t, r, f)
+	}
+	finish()
+
+	w, finish := c.progressWriter(f, "f", 10)
+	if w != io.Writer(f) {
+		expectedError(t, w, f)
+	}
+	finish()
+
+	// Progress bar wraps the network side
+	c.opts.ShowProgressBar = true
+	r, finish = c.progressReader(bytes.NewBufferString("hello"), "hello", 5)
+	if _, err := io.Copy(f, r); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	finish()
+
+	info, _ := f.Stat()
+	if info.Size() != 5 {
+		expectedError(t, info.Size(), 5)
+	}
+}
+
+func TestProgressBarLargeFile(t *testing.T) {
+	// Larger than an int on 32-bit platforms
+	var size int64 = 5 << 30
+
+	c := &transfer{opts: NewClient(nil).TransferOptions}
+	if bar := c.newProgressBar(size); bar.Total != size {
+		expectedError(t, bar.Total, size)
+	}
+
+	c.opts.ProgressBar = nil
+	if bar := c.newProgressBar(size); bar.Total != size {
+		expectedError(t, bar.Total, size)
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	tests := []struct {
+		Raw      string
+		Expected string
+	}{
+		{Raw: "C0644 3 a.txt\n", Expected: "C0644 3 a.txt"},
+		{Raw: "\x00\x00C0644 3  a.txt \n", Expected: "C0644 3  a.txt "},
+		{Raw: "D0755 0 dir\r\n", Expected: "D0755 0 dir"},
+		{Raw: "E \n", Expected: "E"},
+		{Raw: "\x00T1 0 2 0\n", Expected: "T1 0 2 0"},
+		{Raw: "C0644 3 a.txt\x00\r\x00\n", Expected: "C0644 3 a.txt"},
+		{Raw: "\x00\r\n", Expected: ""},
+	}
+
+	for _, v := range tests {
+		if msg := messageText(v.Raw); msg != v.Expected {
+			expectedError(t, msg, v.Expected)
 		}
-		msgCounter++
 	}
+}
 
-	// Output one more newline for convenience in reading from the pipe
-	fmt.Fprintf(c.scpStdinPipe, "\n")
+func TestDownloadNamesVerbatim(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-names")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	names := []string{" lead.txt", "trail.txt ", "caf\xe9.txt"}
+	input := ""
+	for _, name := range names {
+		input += "C0644 2 " + name + "\nhi\x00"
+	}
+
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.opts.Security = PermissiveSecurity()
+	c.path = []string{tmp}
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(tmp, name)); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	}
 }
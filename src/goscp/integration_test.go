@@ -0,0 +1,353 @@
+//go:build integration
+
+package goscp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Runs against a real OpenSSH server, by default the container built from
+// testdata/sshd. Set GOSCP_SSH_ADDR, GOSCP_SSH_USER and GOSCP_SSH_PASSWORD
+// to use another one.
+func dialIntegration(t *testing.T) *ssh.Client {
+	addr := envOr("GOSCP_SSH_ADDR", "localhost:2222")
+	// The vendored ssh package accepts any host key without a callback
+	config := &ssh.ClientConfig{
+		User: envOr("GOSCP_SSH_USER", "goscp"),
+		Auth: []ssh.AuthMethod{ssh.Password(envOr("GOSCP_SSH_PASSWORD", "goscp"))},
+	}
+
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Skip("No SSH server for integration tests:", err)
+	}
+	return client
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Run cmd on the remote host and return its trimmed output.
+func remoteOutput(t *testing.T, client *ssh.Client, cmd string) string {
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer session.Close()
+
+	out, err := session.Output(cmd)
+	if err != nil {
+		t.Fatalf("Unexpected error running %s: %s", cmd, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Read every file and directory below root, keyed by slash separated
+// relative path. Directories map to nil.
+func readTree(t *testing.T, root string) map[string][]byte {
+	tree := make(map[string][]byte)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			tree[rel] = nil
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(p)
+		if content == nil {
+			content = []byte{}
+		}
+		tree[rel] = content
+		return err
+	})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return tree
+}
+
+func TestIntegrationRoundTrip(t *testing.T) {
+	sshClient := dialIntegration(t)
+	defer sshClient.Close()
+
+	tmp, err := ioutil.TempDir("", "goscp-integration")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	remoteDir := remoteOutput(t, sshClient, "mktemp -d")
+	defer remoteOutput(t, sshClient, "rm -rf "+QuotePOSIX(remoteDir))
+
+	root := filepath.Join(tmp, "root")
+	files := map[string][]byte{
+		"a.txt":                   []byte("hello\n"),
+		"empty.txt":               {},
+		"sub/b.txt":               []byte("nested"),
+		"sub/deeper/c.bin":        bytes.Repeat([]byte{0, 1, 2, 255}, 64*1024),
+		"sub/empty-dir":           nil,
+		"ünïcødé ✓/名前.txt":        []byte("unicode"),
+		"spaces and 'quotes'.txt": []byte("quoted"),
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if content == nil {
+			os.MkdirAll(p, 0755)
+			continue
+		}
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, content, 0644); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	c := NewClient(sshClient)
+	c.ShowProgressBar = false
+
+	// Upload into the existing remote directory
+	c.SetDestinationPath(remoteDir)
+	if err := c.Upload(root); err != nil {
+		t.Fatal("Upload failed:", err, c.GetErrorStack())
+	}
+
+	remoteFiles := remoteOutput(t, sshClient, "cd "+QuotePOSIX(remoteDir)+" && find root -type f | wc -l")
+	if remoteFiles != "6" {
+		expectedError(t, remoteFiles, "6")
+	}
+
+	// Download it back and compare
+	back := filepath.Join(tmp, "back")
+	os.Mkdir(back, 0755)
+	c.SetDestinationPath(back)
+	if err := c.Download(path.Join(remoteDir, "root")); err != nil {
+		t.Fatal("Download failed:", err, c.GetErrorStack())
+	}
+
+	expected := readTree(t, root)
+	received := readTree(t, filepath.Join(back, "root"))
+	if !reflect.DeepEqual(received, expected) {
+		for name := range expected {
+			if !bytes.Equal(received[name], expected[name]) || (received[name] == nil) != (expected[name] == nil) {
+				t.Errorf("Mismatch for %q", name)
+			}
+		}
+		for name := range received {
+			if _, ok := expected[name]; !ok {
+				t.Errorf("Unexpected item %q", name)
+			}
+		}
+	}
+}
+
+func TestIntegrationSingleFiles(t *testing.T) {
+	sshClient := dialIntegration(t)
+	defer sshClient.Close()
+
+	tmp, err := ioutil.TempDir("", "goscp-integration")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	remoteDir := remoteOutput(t, sshClient, "mktemp -d")
+	defer remoteOutput(t, sshClient, "rm -rf "+QuotePOSIX(remoteDir))
+
+	tests := []struct {
+		Name    string
+		Content string
+	}{
+		{Name: "empty", Content: ""},
+		{Name: "one-byte", Content: "x"},
+		{Name: "ünïcødé.txt", Content: "unicode name"},
+	}
+
+	for _, v := range tests {
+		local := filepath.Join(tmp, v.Name)
+		ioutil.WriteFile(local, []byte(v.Content), 0644)
+
+		c := NewClient(sshClient)
+		c.ShowProgressBar = false
+		c.SetDestinationPath(remoteDir)
+		if err := c.Upload(local); err != nil {
+			t.Fatal("Upload failed:", err)
+		}
+
+		remote := remoteOutput(t, sshClient, "cat "+QuotePOSIX(path.Join(remoteDir, v.Name)))
+		if remote != v.Content {
+			expectedError(t, remote, v.Content)
+		}
+
+		// Download over the original
+		os.Remove(local)
+		c.SetDestinationPath(tmp)
+		if err := c.Download(path.Join(remoteDir, v.Name)); err != nil {
+			t.Fatal("Download failed:", err)
+		}
+		if content, _ := ioutil.ReadFile(local); string(content) != v.Content {
+			expectedError(t, string(content), v.Content)
+		}
+
+		content, err := c.DownloadBytes(path.Join(remoteDir, v.Name))
+		if err != nil {
+			t.Fatal("DownloadBytes failed:", err)
+		}
+		if string(content) != v.Content {
+			expectedError(t, string(content), v.Content)
+		}
+	}
+}
+
+func TestIntegrationBytes(t *testing.T) {
+	sshClient := dialIntegration(t)
+	defer sshClient.Close()
+
+	remoteDir := remoteOutput(t, sshClient, "mktemp -d")
+	defer remoteOutput(t, sshClient, "rm -rf "+QuotePOSIX(remoteDir))
+
+	c := NewClient(sshClient)
+	c.ShowProgressBar = false
+	c.SetDestinationPath(remoteDir)
+	if err := c.UploadBytes([]byte("key=value\n"), "app.conf", 0600); err != nil {
+		t.Fatal("UploadBytes failed:", err)
+	}
+
+	if mode := remoteOutput(t, sshClient, "stat -c %a "+QuotePOSIX(path.Join(remoteDir, "app.conf"))); mode != "600" {
+		expectedError(t, mode, "600")
+	}
+
+	content, err := c.DownloadBytes(path.Join(remoteDir, "app.conf"))
+	if err != nil {
+		t.Fatal("DownloadBytes failed:", err)
+	}
+	if string(content) != "key=value\n" {
+		expectedError(t, string(content), "key=value\n")
+	}
+}
+
+func TestIntegrationProbe(t *testing.T) {
+	client := dialIntegration(t)
+	defer client.Close()
+
+	c := NewClient(client)
+	caps, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if !strings.HasPrefix(caps.Server, "OpenSSH") {
+		expectedError(t, caps.Server, "OpenSSH server")
+	}
+	if caps.Shell != ShellPOSIX {
+		expectedError(t, caps.Shell, ShellPOSIX)
+	}
+	if !caps.Supports(FlagPreserve) || !caps.Supports(FlagRecursive) {
+		expectedError(t, caps.Options, "options with -p and -r")
+	}
+
+	// Probed once per client
+	if again, _ := c.Probe(context.Background()); again != caps {
+		expectedError(t, again, caps)
+	}
+}
+
+func TestIntegrationPreserve(t *testing.T) {
+	sshClient := dialIntegration(t)
+	defer sshClient.Close()
+
+	tmp, err := ioutil.TempDir("", "goscp-integration")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	remoteDir := remoteOutput(t, sshClient, "mktemp -d")
+	defer remoteOutput(t, sshClient, "rm -rf "+QuotePOSIX(remoteDir))
+
+	// Modes the umask would change, and times well in the past
+	root := filepath.Join(tmp, "root")
+	items := []struct {
+		Name  string
+		Mode  os.FileMode
+		Mtime time.Time
+	}{
+		{Name: "private.txt", Mode: 0600, Mtime: time.Unix(1500000000, 0)},
+		{Name: "run.sh", Mode: 0775, Mtime: time.Unix(1400000000, 0)},
+		{Name: "shared", Mode: 0770, Mtime: time.Unix(1300000000, 0)},
+		{Name: "shared/group.txt", Mode: 0664, Mtime: time.Unix(1200000000, 0)},
+	}
+	os.Mkdir(root, 0755)
+	for _, v := range items {
+		p := filepath.Join(root, filepath.FromSlash(v.Name))
+		if v.Name == "shared" {
+			os.Mkdir(p, 0700)
+		} else if err := ioutil.WriteFile(p, []byte(v.Name), 0600); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	// Children first, so that writing them leaves directory times alone
+	for i := len(items) - 1; i >= 0; i-- {
+		p := filepath.Join(root, filepath.FromSlash(items[i].Name))
+		os.Chmod(p, items[i].Mode)
+		os.Chtimes(p, items[i].Mtime, items[i].Mtime)
+	}
+
+	c := NewClient(sshClient)
+	c.ShowProgressBar = false
+	opts := c.TransferOptions
+	opts.PreserveTimes = true
+	opts.PreserveMode = true
+
+	// Upload keeps times and modes on the remote host
+	opts.DestinationPath = []string{remoteDir}
+	if err := c.UploadWithOptions(context.Background(), root, opts); err != nil {
+		t.Fatal("Upload failed:", err, c.GetErrorStack())
+	}
+	for _, v := range items {
+		stat := remoteOutput(t, sshClient, "stat -c '%a %Y' "+QuotePOSIX(path.Join(remoteDir, "root", v.Name)))
+		expected := fmt.Sprintf("%o %d", v.Mode, v.Mtime.Unix())
+		if stat != expected {
+			t.Errorf("%s: received %q, expected %q", v.Name, stat, expected)
+		}
+	}
+
+	// Download brings them back
+	back := filepath.Join(tmp, "back")
+	os.Mkdir(back, 0755)
+	opts.DestinationPath = []string{back}
+	if err := c.DownloadWithOptions(context.Background(), path.Join(remoteDir, "root"), opts); err != nil {
+		t.Fatal("Download failed:", err, c.GetErrorStack())
+	}
+	for _, v := range items {
+		info, err := os.Stat(filepath.Join(back, "root", filepath.FromSlash(v.Name)))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if info.Mode().Perm() != v.Mode {
+			t.Errorf("%s: received mode %o, expected %o", v.Name, info.Mode().Perm(), v.Mode)
+		}
+		if !info.ModTime().Equal(v.Mtime) {
+			t.Errorf("%s: received mtime %s, expected %s", v.Name, info.ModTime(), v.Mtime)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package goscp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Uploadable is a file to upload that doesn't have to exist on the local
+// filesystem, e.g. generated content or a stream from another service.
+type Uploadable interface {
+	// Name of the file on the remote host
+	Name() string
+
+	// Exact number of bytes Open's reader returns
+	Size() int64
+
+	// Permission bits of the remote file, 0644 when zero
+	Mode() os.FileMode
+
+	// Open the content, the reader is closed once it has been sent
+	Open() (io.ReadCloser, error)
+}
+
+// Downloadable receives a downloaded file in place of a local file. Set it
+// as FileHeader.Target from the BeforeFile option.
+type Downloadable interface {
+	// Receives the content in order
+	io.Writer
+
+	// Called once the file is done, also when it failed
+	io.Closer
+}
+
+// LocalItem returns an Uploadable for a local file or directory, which
+// uploads like Upload, so filesystem items can be mixed with others in
+// UploadItems.
+func LocalItem(localPath string) Uploadable {
+	return localItem(localPath)
+}
+
+type localItem string
+
+func (i localItem) Name() string {
+	return filepath.Base(string(i))
+}
+
+func (i localItem) Size() int64 {
+	if info, err := os.Stat(string(i)); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (i localItem) Mode() os.FileMode {
+	if info, err := os.Stat(string(i)); err == nil {
+		return info.Mode() & modeBits
+	}
+	return 0644
+}
+
+func (i localItem) Open() (io.ReadCloser, error) {
+	return os.Open(string(i))
+}
+
+// UploadItems uploads items to c.DestinationPath in a single session.
+func (c *Client) UploadItems(ctx context.Context, items []Uploadable) error {
+	return c.StartUploadItems(ctx, items, c.TransferOptions).Wait()
+}
+
+// StartUploadItems starts uploading items with opts in the background and
+// returns a handle to supervise it. DeduplicateUploads doesn't apply.
+func (c *Client) StartUploadItems(ctx context.Context, items []Uploadable, opts TransferOptions) *Transfer {
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.uploadItems(ctx, items)
+	})
+}
+
+func (t *transfer) uploadItems(ctx context.Context, items []Uploadable) {
+	session, err := t.openSession()
+	if err != nil {
+		t.addError(err)
+		return
+	}
+	defer session.Close()
+
+	remoteDest := filepath.Join(t.opts.DestinationPath...)
+	t.runUpload(ctx, session, remoteDest, func() error {
+		return t.sendItems(items)
+	})
+}
+
+// Send each item, local items with everything below them.
+func (t *transfer) sendItems(items []Uploadable) error {
+	for _, item := range items {
+		if t.isCancelled() {
+			return ErrCancelled
+		}
+
+		if local, ok := item.(localItem); ok {
+			if err := t.sendTree(string(local)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		t.setPhase(PhaseSend, item.Name())
+
+		start := time.Now()
+		err := t.sendItem(item)
+		t.addResult(item.Name(), item.Size(), time.Since(start), skipCause(err))
+
+		if err := t.uploadFailure(err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send a single non-filesystem item.
+func (t *transfer) sendItem(item Uploadable) error {
+	if !isSafeName(item.Name()) {
+		return fmt.Errorf("Invalid item name: [%q]", item.Name())
+	}
+
+	r, err := item.Open()
+	if err != nil {
+		return &skippableError{fmt.Errorf("Could not open [%q]: %w", item.Name(), err)}
+	}
+	defer r.Close()
+
+	mode := item.Mode() & modeBits
+	if mode == 0 {
+		mode = 0644
+	}
+
+	return t.sendStream(r, item.Name(), item.Name(), item.Name(), mode, item.Size())
+}
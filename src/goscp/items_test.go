@@ -0,0 +1,114 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Uploadable backed by a string.
+type stringItem struct {
+	name    string
+	content string
+}
+
+func (i stringItem) Name() string {
+	return i.name
+}
+
+func (i stringItem) Size() int64 {
+	return int64(len(i.content))
+}
+
+func (i stringItem) Mode() os.FileMode {
+	return 0600
+}
+
+func (i stringItem) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(i.content)), nil
+}
+
+// Downloadable backed by a buffer.
+type bufferTarget struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferTarget) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSendItems(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-items")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	os.Mkdir(filepath.Join(tmp, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(tmp, "dir", "a.txt"), []byte("abc"), 0644)
+
+	tests := []struct {
+		Items          []Uploadable
+		ExpectedOutput string
+		ExpectedError  bool
+	}{
+		{
+			// Local tree followed by generated content
+			Items: []Uploadable{
+				LocalItem(filepath.Join(tmp, "dir")),
+				stringItem{name: "generated.txt", content: "hello"},
+			},
+			ExpectedOutput: "D0755 0 dir\nC0644 3 a.txt\nabc\x00E\nC0600 5 generated.txt\nhello\x00",
+		},
+		{
+			// Names can't leave the destination
+			Items:         []Uploadable{stringItem{name: "../escape", content: "x"}},
+			ExpectedError: true,
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		c := &transfer{scpStdinPipe: nopWriteCloser{out}, scpStdoutPipe: acceptingRemote()}
+
+		err := c.sendItems(v.Items)
+		if (err != nil) != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+		if out.String() != v.ExpectedOutput {
+			expectedError(t, out.String(), v.ExpectedOutput)
+		}
+	}
+}
+
+func TestDownloadTarget(t *testing.T) {
+	target := &bufferTarget{}
+
+	c := &transfer{
+		opts: TransferOptions{
+			BeforeFile: func(h *FileHeader) error {
+				h.Target = target
+				return nil
+			},
+		},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader("C0644 5 a.txt\nabcde\x00"))},
+	}
+	c.path = []string{"/nonexistent"}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if target.String() != "abcde" || !target.closed {
+		expectedError(t, target.String(), "abcde, closed")
+	}
+}
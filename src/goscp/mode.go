@@ -0,0 +1,46 @@
+package goscp
+
+import "os"
+
+// Unix permission bits above the rwx triplets, as they appear in protocol
+// messages.
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+// Mode bits that travel in protocol messages: the permissions plus the
+// setuid, setgid and sticky bits.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Convert a protocol mode to an os.FileMode. The special bits live outside
+// os.ModePerm so they can't simply be cast.
+func fileModeFromUnix(mode uint32) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&unixSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&unixSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&unixSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// Convert an os.FileMode to the mode sent in protocol messages.
+func unixFromFileMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= unixSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= unixSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= unixSticky
+	}
+	return mode
+}
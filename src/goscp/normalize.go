@@ -0,0 +1,41 @@
+package goscp
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms for the NormalizeNames option.
+const (
+	// NormalizeNFC composes characters, as Linux and Windows tools write them
+	NormalizeNFC = "NFC"
+
+	// NormalizeNFD decomposes characters, as macOS HFS+ stores them
+	NormalizeNFD = "NFD"
+)
+
+// Normalize a name with the NormalizeNames option.
+func (t *transfer) normalizeName(name string) string {
+	switch t.opts.NormalizeNames {
+	case NormalizeNFC:
+		return norm.NFC.String(name)
+	case NormalizeNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// Local name of an incoming item, with the RemoteCharset, NormalizeNames
+// and SanitizeName options.
+func (t *transfer) incomingName(m *message) error {
+	if err := t.decodeName(m); err != nil {
+		return err
+	}
+	m.Name = t.normalizeName(m.Name)
+	return t.sanitizeName(m)
+}
+
+// Name of an outgoing item as sent, with the NormalizeNames and
+// RemoteCharset options.
+func (t *transfer) outgoingName(name string) (string, error) {
+	return t.encodeRemote(t.normalizeName(name))
+}
@@ -0,0 +1,96 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	nfcName = "caf\u00e9.txt"
+	nfdName = "cafe\u0301.txt"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		Form     string
+		Name     string
+		Expected string
+	}{
+		{Form: "", Name: nfdName, Expected: nfdName},
+		{Form: NormalizeNFC, Name: nfdName, Expected: nfcName},
+		{Form: NormalizeNFC, Name: nfcName, Expected: nfcName},
+		{Form: NormalizeNFD, Name: nfcName, Expected: nfdName},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: TransferOptions{NormalizeNames: v.Form}}
+		if name := c.normalizeName(v.Name); name != v.Expected {
+			expectedError(t, name, v.Expected)
+		}
+	}
+}
+
+func TestNormalizeNames(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-normalize")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Download
+	input := "C0644 2 " + nfdName + "\nhi\x00"
+	c := &transfer{
+		opts:          TransferOptions{NormalizeNames: NormalizeNFC},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.startSecurityCheck("/srv/" + nfdName)
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, nfcName)); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	// Download of a name requested in the other form
+	input = "C0644 2 " + nfcName + "\nhi\x00"
+	c = &transfer{
+		opts:          TransferOptions{NormalizeNames: NormalizeNFD},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.startSecurityCheck("/srv/" + nfcName)
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, nfdName)); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	// Upload
+	out := &bytes.Buffer{}
+	c = &transfer{
+		opts:          TransferOptions{NormalizeNames: NormalizeNFD},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	if err := c.sendTree(filepath.Join(tmp, nfcName)); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expectedOutput := "C0644 2 " + nfdName + "\nhi\x00"
+	if out.String() != expectedOutput {
+		expectedError(t, out.String(), expectedOutput)
+	}
+}
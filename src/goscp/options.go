@@ -0,0 +1,247 @@
+package goscp
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cheggaaa/pb"
+)
+
+// TransferOptions configures a transfer. The options embedded in Client are
+// used by Download and Upload; DownloadWithOptions and UploadWithOptions
+// take their own, so one Client can serve transfers with different options
+// without changing it between calls.
+type TransferOptions struct {
+	// Remote directory for uploads, local directory for downloads
+	DestinationPath []string
+
+	// Verbose output when communicating with host
+	Verbose bool
+
+	// Log only protocol messages and acknowledgements, with direction and
+	// timing. File content and progress are never logged.
+	Trace bool
+
+	// Logger for trace output, defaults to the standard logger
+	TraceLogger *log.Logger
+
+	// Environment variables for the remote scp command, e.g. LC_ALL=C.
+	// Names are letters, digits and underscores, not starting with a digit.
+	Env map[string]string
+
+	// Fail on any deviation from the protocol instead of tolerating what
+	// some scp implementations and shells send, e.g. stray NUL bytes or
+	// whitespace, locale warnings, or a session ending mid-directory
+	StrictProtocol bool
+
+	// Remote scp program, e.g. "/usr/local/bin/scp" when scp isn't in the
+	// PATH. "scp" when empty.
+	RemoteProgram string
+
+	// Flags added before those of the transfer, e.g. "-v"
+	RemoteFlags []string
+
+	// Replaces the whole remote command, for wrappers like rssh or
+	// scponly. "{flags}" is replaced with the transfer's flags, e.g.
+	// "-r -t", and "{path}" with the quoted remote path, e.g.
+	// "/usr/local/bin/scp {flags} {path}".
+	CommandTemplate string
+
+	// Protocol deviations to accept from servers other than OpenSSH, see
+	// QuirksFor
+	Quirks Quirks
+
+	// Probe the remote scp once per Client, see Client.Probe, and adjust
+	// the commands of transfers to it
+	ProbeRemote bool
+
+	// Run scp without -r, so only single files are transferred like with
+	// plain scp. Directories are copied recursively by default.
+	NonRecursive bool
+
+	// Fail uploads unless the remote destination is an existing directory,
+	// with ErrNotDirectory or ErrRemoteNotFound, instead of creating a file
+	// by its name. Passes -d to the remote scp.
+	TargetIsDirectory bool
+
+	// Stop transfer on OS error - occurs during filepath.Walk
+	StopOnOSError bool
+
+	// Skip every item that fails, in both directions, and only fail the
+	// transfer with a *SoftFailError when more than MaxFailures items
+	// failed. Errors that break the session, and security refusals,
+	// still stop it.
+	SoftFail    bool
+	MaxFailures int
+
+	// Skip downloaded items that can't be written locally, or that the
+	// remote fails to read, instead of stopping the transfer. Skipped items
+	// are recorded as errors.
+	ContinueOnError bool
+
+	// Skip uploading directories that contain a file with one of these
+	// names, e.g. ".nobackup" or "CACHEDIR.TAG"
+	ExcludeMarkers []string
+
+	// Send up to this many upload messages ahead of the remote's
+	// acknowledgements, which saves a round trip per file on high latency
+	// links. File content still waits for the remote to accept the file's
+	// header, only the acknowledgements of sent content, times and
+	// directories are read later. An error in those then stops the upload,
+	// and files sent after the failed one end with ErrUnconfirmed in the
+	// results. 0 waits for each acknowledgement.
+	PipelineDepth int
+
+	// Most file outcomes kept for Transfer.Results, so that memory doesn't
+	// grow with the number of files. Once reached, the oldest successful
+	// files are dropped first. 0 keeps DefaultMaxResults, negative keeps
+	// every one.
+	MaxResults int
+
+	// Send files with identical content and mode, and modification time
+	// with PreserveTimes, only once per upload and recreate the copies with
+	// cp on the remote host, with the Env and Sudo options.
+	// Uploads fail with ErrRefused when ProbeRemote or Quirks tell of a
+	// shell other than POSIX.
+	DeduplicateUploads bool
+
+	// Time the remote scp has to start speaking the protocol, 0 for no
+	// limit. Catches login shells and scripts that hang before running scp,
+	// independently of how long the transfer itself takes.
+	StartTimeout time.Duration
+
+	// Time the whole transfer may take, 0 for no limit. The transfer is
+	// cancelled and fails with ErrTimeout when it runs longer.
+	Timeout time.Duration
+
+	// Time a single file may take, 0 for no limit. An uploaded file that
+	// takes longer is cut short, padded and reported as failed, and the
+	// upload continues. A download can't skip a file the remote is stuck
+	// on, so it is cancelled.
+	FileTimeout time.Duration
+
+	// Time the transfer may go without moving any data, 0 for no limit.
+	// A stalled transfer is cancelled and fails with ErrStalled, which
+	// suits long transfers over flaky links better than Timeout.
+	StallTimeout time.Duration
+
+	// Maximum bytes per second in each direction, 0 for no limit
+	BandwidthLimit int64
+
+	// Bandwidth limit applied by the remote scp in Kbit/s, its -l flag,
+	// 0 for none. Unlike BandwidthLimit it caps the remote host's own
+	// scp process.
+	RemoteBandwidthLimit int64
+
+	// Limits for times of day, overriding BandwidthLimit. The first window
+	// containing the current time applies.
+	BandwidthSchedule []BandwidthWindow
+
+	// Show progress bar
+	ShowProgressBar bool
+
+	// Configurable progress bar
+	ProgressBar *pb.ProgressBar
+
+	// Files smaller than this are sent without progress output, which
+	// avoids creating a progress bar per file on trees of many small files
+	ProgressMinSize int64
+
+	// Print plain progress lines instead of the progress bar when set
+	PlainProgress *PlainProgress
+
+	// Where the progress bar or plain progress lines are written, e.g.
+	// os.Stderr to keep stdout clean. Output goes to every writer and
+	// overrides the Output of ProgressBar and PlainProgress. Defaults to
+	// their own Output when empty.
+	ProgressOutputs []io.Writer
+
+	// Called when the remote command prints an interactive prompt, e.g. a
+	// sudo password request. The answer is written to the command's stdin.
+	// Transfers fail with ErrInteractivePrompt when nil.
+	PromptCallback func(prompt string) (string, error)
+
+	// Run the remote scp with sudo, e.g. to write root-owned paths. The
+	// remote needs a POSIX shell. sudo's password prompt is answered with
+	// SudoPassword, or PromptCallback when nil, and the protocol only
+	// starts once sudo has run scp.
+	Sudo         bool
+	SudoPassword func() (string, error)
+
+	// Reserve the space of each downloaded file before writing it, which
+	// reduces fragmentation and fails early when the disk is full
+	Preallocate bool
+
+	// Give downloaded files and directories the permissions sent by the
+	// remote, instead of those less the umask. Applies to files the
+	// transfer creates, not to PreopenedFiles or those from OpenFile.
+	PreserveMode bool
+
+	// Pass -C to the remote scp, for servers that compress better on
+	// their side than the SSH connection does
+	Compress bool
+
+	// Keep modification and access times, like scp -p. Downloaded files
+	// and directories get the remote times, uploads send the local ones.
+	PreserveTimes bool
+
+	// Download protections against misbehaving or hostile hosts
+	Security Security
+
+	// Called whenever an error is recorded, with the item being transferred
+	// relative to the transfer root, empty if none, and the phase of the
+	// transfer. Cancel the transfer from here to stop it on any error.
+	OnError func(relPath string, phase Phase, err error)
+
+	// Called with the name of each downloaded file and directory, to
+	// enforce naming policies. The returned name is used instead, and still
+	// goes through the Security checks. Return ErrSkipFile to skip the item
+	// or any other error to stop the transfer.
+	SanitizeName func(name string) (string, error)
+
+	// Encoding of names on the remote when it isn't UTF-8, e.g. Latin1.
+	// Incoming names are decoded before NormalizeNames and SanitizeName,
+	// outgoing names and remote paths are encoded.
+	RemoteCharset Charset
+
+	// Unicode form of names in both directions, NormalizeNFC or
+	// NormalizeNFD, so names from macOS and Linux don't end up as lookalike
+	// duplicates. Names are kept as they are when empty.
+	NormalizeNames string
+
+	// Called for each downloaded file before its content is read. Change
+	// the header to redirect or preallocate the file, return ErrSkipFile to
+	// skip it or any other error to stop the transfer.
+	BeforeFile func(h *FileHeader) error
+
+	// Already open files for expected downloads, by path relative to the
+	// transfer root. Content is written from the file's current offset and
+	// the file is closed once received. This lets a process with more
+	// rights open the destinations, e.g. passing them over a Unix socket.
+	PreopenedFiles map[string]*os.File
+
+	// Opens the local file for each downloaded file not in PreopenedFiles,
+	// instead of creating it at the header's LocalPath. A nil file falls
+	// back to creating it.
+	OpenFile func(h *FileHeader) (*os.File, error)
+
+	// Filesystem downloads write to instead of the local one, e.g. an
+	// in-memory one. Preallocate and ConfineToDestination only apply to the
+	// local filesystem.
+	WriteFS WriteFS
+
+	// Send the modes an fs.FS reports with UploadFS, instead of 0644 for
+	// files and 0755 for directories. embed.FS reports read-only modes,
+	// which the remote would give what it creates.
+	FSModes bool
+
+	// Called when a directory is entered during a transfer, with its path
+	// relative to the transfer root
+	OnDirStart func(relPath string)
+
+	// Called when everything in a directory has been transferred, with
+	// aggregate stats for the directory and everything below it
+	OnDirEnd func(relPath string, stats DirStats)
+}
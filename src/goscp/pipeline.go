@@ -0,0 +1,109 @@
+package goscp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Acknowledgement the remote owes for a message sent during an upload.
+type pendingAck struct {
+	// File the message was about, empty for directories
+	relPath string
+
+	// What the remote was asked to do, for errors
+	action string
+
+	// A warning instead of the ack only fails the file
+	skippable bool
+}
+
+// Build the error for a failed acknowledgement.
+func (a pendingAck) error(err error) error {
+	err = fmt.Errorf("Remote could not %s: %w", a.action, err)
+
+	var remoteErr *RemoteMessageError
+	if a.skippable && errors.As(err, &remoteErr) && !remoteErr.Fatal {
+		return &skippableError{err}
+	}
+	return err
+}
+
+// Wait for the acknowledgement of the message just sent. With the
+// PipelineDepth option, up to that many are left unread and any error is
+// fatal, as the remote may have misread what was sent after it.
+func (t *transfer) expectAck(a pendingAck) error {
+	if t.opts.PipelineDepth <= 0 {
+		if err := t.readAck(); err != nil {
+			return a.error(err)
+		}
+		return nil
+	}
+
+	a.skippable = false
+	t.pendingAcks = append(t.pendingAcks, a)
+	if len(t.pendingAcks) > t.opts.PipelineDepth {
+		return t.collectAck()
+	}
+	return nil
+}
+
+// Wait for the acknowledgement of a message whose answer decides what is
+// sent next, like a file header the remote may refuse without reading the
+// content. The pending ones are read first, a warning only fails the item.
+func (t *transfer) awaitAck(a pendingAck) error {
+	if err := t.flushAcks(); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return a.error(err)
+	}
+	return nil
+}
+
+// Read the oldest pending acknowledgement.
+func (t *transfer) collectAck() error {
+	a := t.pendingAcks[0]
+	t.pendingAcks = t.pendingAcks[1:]
+
+	if err := t.readAck(); err != nil {
+		err = a.error(err)
+		t.unconfirm(a, err)
+		return err
+	}
+	return nil
+}
+
+// Read every pending acknowledgement.
+func (t *transfer) flushAcks() error {
+	for len(t.pendingAcks) > 0 {
+		if err := t.collectAck(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fail the results of the file whose message failed with err, and of the
+// files sent after it, which the remote may not have stored.
+func (t *transfer) unconfirm(failed pendingAck, err error) {
+	errs := make(map[string]error)
+	if failed.relPath != "" {
+		errs[failed.relPath] = err
+	}
+	for _, a := range t.pendingAcks {
+		if _, ok := errs[a.relPath]; !ok && a.relPath != "" {
+			errs[a.relPath] = ErrUnconfirmed
+		}
+	}
+	t.pendingAcks = nil
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.results {
+		r := &t.results[i]
+		if err, ok := errs[r.RelPath]; ok && r.Err == nil {
+			r.Err = err
+		}
+	}
+}
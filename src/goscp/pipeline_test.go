@@ -0,0 +1,111 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPipelinedUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscp-pipeline")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("abc"), 0644)
+	}
+
+	file := "C0644 3 x.txt\nabc\x00"
+	tests := []struct {
+		PipelineDepth   int
+		SoftFail        bool
+		Remote          string
+		ExpectedOutput  string
+		ExpectedError   error
+		ExpectedResults []error
+	}{
+		{
+			// Sequential
+			Remote:          strings.Repeat("\x00", 8),
+			ExpectedOutput:  file + file + file + "E\n",
+			ExpectedResults: []error{nil, nil, nil},
+		},
+		{
+			PipelineDepth:   3,
+			Remote:          strings.Repeat("\x00", 8),
+			ExpectedOutput:  file + file + file + "E\n",
+			ExpectedResults: []error{nil, nil, nil},
+		},
+		{
+			// The remote refuses b.txt without reading content, none is sent
+			PipelineDepth:   100,
+			Remote:          "\x00\x00\x00\x01scp: b.txt: Permission denied\n",
+			ExpectedOutput:  file + "C0644 3 x.txt\n",
+			ExpectedError:   ErrPermissionDenied,
+			ExpectedResults: []error{nil, ErrPermissionDenied},
+		},
+		{
+			// Refused and skipped, the next file follows as usual
+			PipelineDepth:   100,
+			SoftFail:        true,
+			Remote:          "\x00\x00\x00\x01scp: b.txt: Permission denied\n\x00\x00\x00",
+			ExpectedOutput:  file + "C0644 3 x.txt\n" + file + "E\n",
+			ExpectedResults: []error{nil, ErrPermissionDenied, nil},
+		},
+		{
+			// The remote fails writing b.txt, which is only read once the
+			// next header was sent
+			PipelineDepth:   100,
+			Remote:          "\x00\x00\x00\x00\x01scp: b.txt: Permission denied\n",
+			ExpectedOutput:  file + file + "C0644 3 x.txt\n",
+			ExpectedError:   ErrPermissionDenied,
+			ExpectedResults: []error{nil, ErrPermissionDenied, ErrPermissionDenied},
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		c := &transfer{
+			opts:          TransferOptions{PipelineDepth: v.PipelineDepth, SoftFail: v.SoftFail},
+			scpStdinPipe:  nopWriteCloser{out},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(v.Remote))},
+		}
+
+		err := c.sendTree(dir)
+		if err == nil {
+			err = c.flushAcks()
+		}
+		if !errors.Is(err, v.ExpectedError) {
+			expectedError(t, err, v.ExpectedError)
+		}
+
+		expectedOutput := "D0700 0 " + filepath.Base(dir) + "\n" + v.ExpectedOutput
+		output := out.String()
+		for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			output = strings.Replace(output, name, "x.txt", 1)
+		}
+		if output != expectedOutput {
+			expectedError(t, output, expectedOutput)
+		}
+
+		if len(c.results) != len(v.ExpectedResults) {
+			expectedError(t, c.results, v.ExpectedResults)
+			continue
+		}
+		for i, r := range c.results {
+			if !errors.Is(r.Err, v.ExpectedResults[i]) || (r.Err == nil) != (v.ExpectedResults[i] == nil) {
+				expectedError(t, r, v.ExpectedResults[i])
+			}
+		}
+		if len(c.pendingAcks) != 0 {
+			expectedError(t, c.pendingAcks, "no pending acknowledgements")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package goscp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// PlainProgress prints one line per step instead of a live progress bar,
+// which keeps CI and other non-interactive logs readable.
+type PlainProgress struct {
+	// Print a line each time this many percent of a file has been sent
+	// or received, 0 to disable
+	Percent int
+
+	// Print a line at least this often while a file is in progress,
+	// 0 to disable
+	Interval time.Duration
+
+	// Where lines are printed, defaults to os.Stdout
+	Output io.Writer
+}
+
+// Counts bytes for a single file and prints plain progress lines.
+type plainProgressCounter struct {
+	p     *PlainProgress
+	name  string
+	total int64
+
+	// Overrides p.Output when set
+	out io.Writer
+
+	current     int64
+	lastPercent int64
+	start       time.Time
+	lastPrint   time.Time
+}
+
+func newPlainProgressCounter(p *PlainProgress, name string, total int64) *plainProgressCounter {
+	now := time.Now()
+	return &plainProgressCounter{
+		p:         p,
+		name:      name,
+		total:     total,
+		start:     now,
+		lastPrint: now,
+	}
+}
+
+func (w *plainProgressCounter) Write(b []byte) (int, error) {
+	w.current += int64(len(b))
+
+	now := time.Now()
+	percent := w.percent()
+	step := int64(w.p.Percent)
+
+	switch {
+	case step > 0 && percent/step > w.lastPercent/step:
+	case w.p.Interval > 0 && now.Sub(w.lastPrint) >= w.p.Interval:
+	default:
+		return len(b), nil
+	}
+
+	w.lastPercent = percent
+	w.lastPrint = now
+	w.printf("%s: %d%% (%d/%d bytes)", w.name, percent, w.current, w.total)
+
+	return len(b), nil
+}
+
+// Print the final line for the file.
+func (w *plainProgressCounter) finish() {
+	w.printf("%s: done (%d bytes in %s)", w.name, w.current, time.Since(w.start).Round(time.Millisecond))
+}
+
+func (w *plainProgressCounter) percent() int64 {
+	if w.total <= 0 {
+		return 100
+	}
+	return w.current * 100 / w.total
+}
+
+func (w *plainProgressCounter) printf(format string, a ...interface{}) {
+	out := w.out
+	if out == nil {
+		out = w.p.Output
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format+"\n", a...)
+}
@@ -0,0 +1,139 @@
+package goscp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cheggaaa/pb"
+)
+
+func TestPlainProgress(t *testing.T) {
+	tests := []struct {
+		Progress PlainProgress
+		Writes   []int
+		Total    int64
+		Expected []string
+	}{
+		{
+			// Every 25 percent
+			Progress: PlainProgress{Percent: 25},
+			Writes:   []int{10, 10, 10, 30, 40},
+			Total:    100,
+			Expected: []string{
+				"file.txt: 30% (30/100 bytes)",
+				"file.txt: 60% (60/100 bytes)",
+				"file.txt: 100% (100/100 bytes)",
+			},
+		},
+		{
+			// Interval not yet reached
+			Progress: PlainProgress{Interval: time.Hour},
+			Writes:   []int{50, 50},
+			Total:    100,
+		},
+		{
+			// Every write once the interval has passed
+			Progress: PlainProgress{Interval: time.Nanosecond},
+			Writes:   []int{50, 50},
+			Total:    100,
+			Expected: []string{
+				"file.txt: 50% (50/100 bytes)",
+				"file.txt: 100% (100/100 bytes)",
+			},
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		v.Progress.Output = out
+
+		counter := newPlainProgressCounter(&v.Progress, "file.txt", v.Total)
+		for _, n := range v.Writes {
+			time.Sleep(time.Millisecond)
+			counter.Write(make([]byte, n))
+		}
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if out.Len() == 0 {
+			lines = nil
+		}
+		if len(lines) != len(v.Expected) {
+			expectedError(t, lines, v.Expected)
+			continue
+		}
+		for i := range lines {
+			if lines[i] != v.Expected[i] {
+				expectedError(t, lines[i], v.Expected[i])
+			}
+		}
+
+		// Final line is always printed
+		out.Reset()
+		counter.finish()
+		if !strings.HasPrefix(out.String(), "file.txt: done (100 bytes in ") {
+			expectedError(t, out.String(), "file.txt: done (100 bytes in ...)")
+		}
+	}
+}
+
+func TestPlainProgressWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	c := &transfer{opts: TransferOptions{
+		ShowProgressBar: true,
+		PlainProgress:   &PlainProgress{Percent: 50, Output: out},
+	}}
+
+	dst := &bytes.Buffer{}
+	w, finish := c.progressWriter(dst, "upload.txt", 4)
+	io.WriteString(w, "data")
+	finish()
+
+	if dst.String() != "data" {
+		expectedError(t, dst.String(), "data")
+	}
+	if !strings.HasPrefix(out.String(), "upload.txt: 100% (4/4 bytes)\nupload.txt: done (4 bytes in ") {
+		expectedError(t, out.String(), "upload.txt: 100% (4/4 bytes)\nupload.txt: done (4 bytes in ...)")
+	}
+}
+
+func TestProgressOutputs(t *testing.T) {
+	first, second := &bytes.Buffer{}, &bytes.Buffer{}
+	own := &bytes.Buffer{}
+
+	tests := []TransferOptions{
+		{
+			ShowProgressBar: true,
+			PlainProgress:   &PlainProgress{Percent: 50, Output: own},
+			ProgressOutputs: []io.Writer{first, second},
+		},
+		{
+			ShowProgressBar: true,
+			ProgressBar:     &pb.ProgressBar{ShowCounters: true, Output: own},
+			ProgressOutputs: []io.Writer{first, second},
+		},
+	}
+
+	for _, v := range tests {
+		first.Reset()
+		second.Reset()
+		own.Reset()
+
+		c := &transfer{opts: v}
+		w, finish := c.progressWriter(&bytes.Buffer{}, "upload.txt", 4)
+		io.WriteString(w, "data")
+		finish()
+
+		if first.Len() == 0 || first.String() != second.String() {
+			expectedError(t, []string{first.String(), second.String()}, "progress output on both writers")
+		}
+		if !strings.HasSuffix(first.String(), "\n") {
+			expectedError(t, first.String(), "output ending with a newline")
+		}
+		if own.Len() != 0 {
+			expectedError(t, own.String(), "")
+		}
+	}
+}
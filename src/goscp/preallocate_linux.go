@@ -0,0 +1,24 @@
+package goscp
+
+import (
+	"os"
+	"syscall"
+)
+
+// Reserve size bytes for f.
+func preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP {
+		// Not supported by the filesystem, fall back to extending the file
+		return f.Truncate(size)
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package goscp
+
+import (
+	"os"
+)
+
+// Reserve size bytes for f. Without fallocate the file is only extended,
+// which reserves space on some filesystems but not all.
+func preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return f.Truncate(size)
+}
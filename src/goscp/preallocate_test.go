@@ -0,0 +1,63 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	f, err := ioutil.TempFile("", "goscp-prealloc")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tests := []struct {
+		Size         int64
+		ExpectedSize int64
+	}{
+		{Size: 0, ExpectedSize: 0},
+		{Size: 4096, ExpectedSize: 4096},
+	}
+
+	for _, v := range tests {
+		if err := preallocate(f, v.Size); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		info, _ := f.Stat()
+		if info.Size() != v.ExpectedSize {
+			expectedError(t, info.Size(), v.ExpectedSize)
+		}
+	}
+}
+
+func TestPreallocateDownload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-prealloc")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	c := &transfer{
+		opts:          TransferOptions{Preallocate: true},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader("C0644 5 a.txt\nabcde\x00"))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(tmp, "a.txt")); string(content) != "abcde" {
+		expectedError(t, string(content), "abcde")
+	}
+}
@@ -0,0 +1,70 @@
+package goscp
+
+import (
+	"sync"
+)
+
+// Progress is a snapshot of a running transfer.
+type Progress struct {
+	// Files completed
+	Files int
+
+	// Directories entered
+	Dirs int
+
+	// Bytes of file content sent or received
+	Bytes int64
+
+	// File in progress relative to the transfer root, empty between files
+	Current string
+}
+
+// Counts transferred content, safe to read while the transfer writes.
+type progressCounter struct {
+	mu sync.Mutex
+	p  Progress
+}
+
+func (c *progressCounter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.p.Bytes += int64(len(b))
+	c.mu.Unlock()
+
+	return len(b), nil
+}
+
+// Track the start of a file.
+func (c *progressCounter) startFile(relPath string) {
+	c.mu.Lock()
+	c.p.Current = relPath
+	c.mu.Unlock()
+}
+
+// Track entering a directory.
+func (c *progressCounter) startDir() {
+	c.mu.Lock()
+	c.p.Dirs++
+	c.mu.Unlock()
+}
+
+// Track the end of the current file.
+func (c *progressCounter) finishFile() {
+	c.mu.Lock()
+	c.p.Files++
+	c.p.Current = ""
+	c.mu.Unlock()
+}
+
+// Track the end of the current file when it failed.
+func (c *progressCounter) abandonFile() {
+	c.mu.Lock()
+	c.p.Current = ""
+	c.mu.Unlock()
+}
+
+func (c *progressCounter) snapshot() Progress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.p
+}
@@ -0,0 +1,133 @@
+package goscp
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Longest line tail checked for a prompt.
+const maxPromptLength = 256
+
+var (
+	// Tail of a line asking for a secret or a confirmation
+	promptRx = regexp.MustCompile(`(?i)(password|passphrase|passcode)[^:\n]*: *$|\(yes/no[^)\n]*\)\? *$`)
+)
+
+// Check for a prompt in output that doesn't start with a protocol message.
+// The prompt is consumed from the reader when found.
+func (t *transfer) readPrompt() (string, bool) {
+	b, err := t.scpStdoutPipe.Peek(1)
+	if err != nil || isProtocolByte(b[0]) {
+		return "", false
+	}
+
+	buf, _ := t.scpStdoutPipe.Peek(t.scpStdoutPipe.Buffered())
+	if !promptRx.Match(buf) {
+		return "", false
+	}
+
+	t.scpStdoutPipe.Discard(len(buf))
+	return string(buf), true
+}
+
+// Answer a prompt through the PromptCallback option, or fail with
+// ErrInteractivePrompt.
+func (t *transfer) answerPrompt(prompt string) error {
+	prompt = strings.TrimSpace(prompt)
+	t.outputInfo(fmt.Sprintf("Remote prompt: %s", prompt))
+
+	if handled, err := t.answerSudo(prompt); handled {
+		return err
+	}
+
+	if t.opts.PromptCallback == nil {
+		return fmt.Errorf("%w: [%q]", ErrInteractivePrompt, prompt)
+	}
+
+	answer, err := t.opts.PromptCallback(prompt)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(t.scpStdinPipe, answer)
+	return err
+}
+
+// Stdin of the remote command, shared by the handler speaking the protocol
+// and the stderr watcher answering prompts. Each write goes through whole.
+type lockedWriteCloser struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func (l *lockedWriteCloser) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+func (l *lockedWriteCloser) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Close()
+}
+
+// Check if b can start a message from the remote scp process.
+func isProtocolByte(b byte) bool {
+	switch b {
+	case 'C', 'D', 'E', 'T', '\x00', '\x01', '\x02':
+		return true
+	}
+	return false
+}
+
+// Watches the remote command's stderr for prompts, as most programs print
+// them there rather than on stdout.
+type promptWatcher struct {
+	t       *transfer
+	session *ssh.Session
+
+	// Current, unterminated line
+	line []byte
+}
+
+func (w *promptWatcher) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			w.line = append(w.line, b)
+			continue
+		}
+
+		if !w.t.checkSudoReady(string(w.line)) {
+			w.t.outputInfo(fmt.Sprintf("Remote stderr: %s", w.line))
+			w.t.setRemoteText(string(w.line))
+		}
+		w.line = w.line[:0]
+	}
+
+	// Prompts are short, only the tail of a long line matters
+	if len(w.line) > maxPromptLength {
+		w.line = append(w.line[:0], w.line[len(w.line)-maxPromptLength:]...)
+	}
+
+	if promptRx.Match(w.line) {
+		prompt := string(w.line)
+		w.line = w.line[:0]
+
+		if err := w.t.answerPrompt(prompt); err != nil {
+			w.t.addError(err)
+
+			// Unblocks the transfer waiting on protocol data
+			w.session.Close()
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,216 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadPrompt(t *testing.T) {
+	tests := []struct {
+		Input          string
+		ExpectedPrompt string
+		ExpectedFound  bool
+	}{
+		{
+			// sudo password prompt
+			Input:          "[sudo] password for deploy: ",
+			ExpectedPrompt: "[sudo] password for deploy: ",
+			ExpectedFound:  true,
+		},
+		{
+			// Prompt after a banner
+			Input:          "Authorized use only\nPassword:",
+			ExpectedPrompt: "Authorized use only\nPassword:",
+			ExpectedFound:  true,
+		},
+		{
+			// Host key confirmation
+			Input:          "Are you sure you want to continue connecting (yes/no/[fingerprint])? ",
+			ExpectedPrompt: "Are you sure you want to continue connecting (yes/no/[fingerprint])? ",
+			ExpectedFound:  true,
+		},
+		{
+			// Protocol message
+			Input: "C0644 8 password: ",
+		},
+		{
+			// Other noise
+			Input: "stty: not a tty\n",
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{}
+		c.scpStdoutPipe = &readCanceller{Reader: bufio.NewReader(bytes.NewBufferString(v.Input))}
+
+		prompt, found := c.readPrompt()
+		if found != v.ExpectedFound {
+			expectedError(t, found, v.ExpectedFound)
+		}
+		if prompt != v.ExpectedPrompt {
+			expectedError(t, prompt, v.ExpectedPrompt)
+		}
+
+		// Non-prompt output is left for the protocol reader
+		if !found && c.scpStdoutPipe.Buffered() != len(v.Input) {
+			expectedError(t, c.scpStdoutPipe.Buffered(), len(v.Input))
+		}
+	}
+}
+
+func TestAnswerPrompt(t *testing.T) {
+	// No callback
+	c := &transfer{}
+	err := c.answerPrompt("[sudo] password for deploy: ")
+	if !errors.Is(err, ErrInteractivePrompt) {
+		expectedError(t, err, ErrInteractivePrompt)
+	}
+
+	// Answered by callback
+	stdin := &bytes.Buffer{}
+	c = &transfer{
+		scpStdinPipe: nopWriteCloser{stdin},
+		opts: TransferOptions{
+			PromptCallback: func(prompt string) (string, error) {
+				if prompt != "[sudo] password for deploy:" {
+					expectedError(t, prompt, "[sudo] password for deploy:")
+				}
+				return "hunter2", nil
+			},
+		},
+	}
+	if err := c.answerPrompt("[sudo] password for deploy: "); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if stdin.String() != "hunter2\n" {
+		expectedError(t, stdin.String(), "hunter2\n")
+	}
+}
+
+func TestPromptWatcher(t *testing.T) {
+	c := &transfer{}
+	w := &promptWatcher{t: c}
+
+	// Prompt split across writes
+	w.Write([]byte("sudo: unable to resolve host\n[sudo] pass"))
+	if len(c.errors) != 0 {
+		t.Error("Unexpected error:", c.errors)
+	}
+
+	stdin := &bytes.Buffer{}
+	c.scpStdinPipe = nopWriteCloser{stdin}
+	c.opts.PromptCallback = func(prompt string) (string, error) {
+		return "hunter2", nil
+	}
+
+	w.Write([]byte("word for deploy: "))
+	if stdin.String() != "hunter2\n" {
+		expectedError(t, stdin.String(), "hunter2\n")
+	}
+}
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Output of a remote that acknowledges everything it is sent.
+func acceptingRemote() *readCanceller {
+	return &readCanceller{Reader: bufio.NewReader(zeroReader{})}
+}
+
+func TestSudo(t *testing.T) {
+	stdin := &bytes.Buffer{}
+	c := &transfer{
+		opts: TransferOptions{
+			Sudo: true,
+			SudoPassword: func() (string, error) {
+				return "hunter2", nil
+			},
+		},
+		scpStdinPipe: nopWriteCloser{stdin},
+		sudoReady:    make(chan struct{}),
+	}
+
+	cmd := c.command(SinkCommand("/root/data"))
+	expected := `sudo -S -p 'goscp sudo password: ' sh -c 'echo goscp-sudo-ready >&2 && exec scp -r -t /root/data'`
+	if cmd != expected {
+		expectedError(t, cmd, expected)
+	}
+
+	// Nothing starts before sudo has run the command
+	ended := make(chan struct{})
+	close(ended)
+	if c.waitSudo(ended) {
+		expectedError(t, true, false)
+	}
+
+	w := &promptWatcher{t: c}
+	w.Write([]byte(sudoPrompt))
+	if stdin.String() != "hunter2\n" {
+		expectedError(t, stdin.String(), "hunter2\n")
+	}
+
+	w.Write([]byte(sudoReadyLine + "\n"))
+	if !c.waitSudo(nil) {
+		expectedError(t, false, true)
+	}
+	if c.remoteText != "" {
+		expectedError(t, c.remoteText, "")
+	}
+
+	// A second prompt means the password was wrong
+	if err := c.answerPrompt(sudoPrompt); err != ErrSudoRejected {
+		expectedError(t, err, ErrSudoRejected)
+	}
+}
+
+// Writer failing when writes overlap.
+type overlapWriter struct {
+	busy    int32
+	overlap int32
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if !atomic.CompareAndSwapInt32(&w.busy, 0, 1) {
+		atomic.StoreInt32(&w.overlap, 1)
+		return len(p), nil
+	}
+	time.Sleep(time.Millisecond)
+	atomic.StoreInt32(&w.busy, 0)
+	return len(p), nil
+}
+
+func (w *overlapWriter) Close() error {
+	return nil
+}
+
+func TestLockedWriteCloser(t *testing.T) {
+	w := &overlapWriter{}
+	stdin := &lockedWriteCloser{w: w}
+
+	// The handler and the stderr watcher write at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				stdin.Write([]byte("x"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&w.overlap) != 0 {
+		t.Error("Writes overlapped")
+	}
+}
@@ -0,0 +1,117 @@
+package goscp
+
+import (
+	"strings"
+)
+
+// QuotePOSIX quotes s so that a POSIX shell (sh, bash, dash, zsh) passes it
+// through as a single literal argument. Strings made up only of characters
+// that are never special to the shell are returned unchanged.
+func QuotePOSIX(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if isShellSafe(s) {
+		return s
+	}
+
+	// Single quotes disable all expansion; embedded single quotes have to
+	// end the quoted section, be escaped and reopen it
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// QuoteWindows quotes s so that it is read back as a single argument by
+// programs that split their command line with the Microsoft C runtime rules
+// (CommandLineToArgvW), which includes Windows OpenSSH's scp.exe.
+//
+// It is not safe for cmd.exe on its own: cmd.exe interprets & | < > ^ ( )
+// outside the quotes and expands %VARIABLE% references inside them. Use
+// QuoteCmd when the remote shell is cmd.exe and QuotePowerShell when it is
+// PowerShell.
+func QuoteWindows(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+
+	backslashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			// Backslashes preceding a quote are doubled and the quote escaped
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteByte(s[i])
+	}
+
+	// Backslashes preceding the closing quote are doubled
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// QuoteCmd quotes s for a command line run by cmd.exe, the default shell of
+// Windows OpenSSH servers. s is quoted with QuoteWindows for the program,
+// then every character cmd.exe treats specially is escaped with a caret,
+// quotes included so cmd.exe never sees a quoted section. A caret after a
+// percent sign breaks up %VARIABLE% references.
+func QuoteCmd(s string) string {
+	quoted := QuoteWindows(s)
+
+	var b strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		c := quoted[i]
+		switch {
+		case c == '%':
+			b.WriteString("%^")
+			continue
+		case strings.IndexByte(`^&|<>()"!`, c) >= 0:
+			b.WriteByte('^')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// PowerShell reads these as single quotes too.
+var powerShellQuotes = strings.NewReplacer(
+	"'", "''",
+	"\u2018", "\u2018\u2018",
+	"\u2019", "\u2019\u2019",
+	"\u201a", "\u201a\u201a",
+	"\u201b", "\u201b\u201b",
+)
+
+// QuotePowerShell quotes s as a PowerShell verbatim string, which is how
+// Windows OpenSSH servers configured with PowerShell as the default shell
+// need their arguments quoted. Single quotes are doubled, the typographic
+// ones PowerShell also accepts included.
+func QuotePowerShell(s string) string {
+	return "'" + powerShellQuotes.Replace(s) + "'"
+}
+
+// Check if s contains only characters that no shell treats specially.
+func isShellSafe(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("_-./,:=+@%", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
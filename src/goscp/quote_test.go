@@ -0,0 +1,182 @@
+package goscp
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestQuotePOSIX(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			// Nothing to quote
+			Input:    "/var/www/media-2016/images.tar.gz",
+			Expected: "/var/www/media-2016/images.tar.gz",
+		},
+		{
+			// Empty argument
+			Input:    "",
+			Expected: "''",
+		},
+		{
+			// Spaces
+			Input:    "/tmp/my files",
+			Expected: "'/tmp/my files'",
+		},
+		{
+			// Expansion characters
+			Input:    "/tmp/$HOME/`id`/~",
+			Expected: "'/tmp/$HOME/`id`/~'",
+		},
+		{
+			// Embedded single quotes
+			Input:    "/tmp/it's",
+			Expected: `'/tmp/it'\''s'`,
+		},
+	}
+
+	for _, v := range tests {
+		output := QuotePOSIX(v.Input)
+		if output != v.Expected {
+			expectedError(t, output, v.Expected)
+		}
+	}
+}
+
+func TestQuotePOSIXShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	inputs := []string{
+		"plain",
+		"with space",
+		"it's",
+		"$HOME `id` $(id) \\ \" ; & | > < * ? ~ ! #",
+		"new\nline",
+	}
+
+	for _, v := range inputs {
+		output, err := exec.Command("sh", "-c", "printf %s "+QuotePOSIX(v)).Output()
+		if err != nil {
+			t.Error("Unexpected error:", err)
+			continue
+		}
+
+		if string(output) != v {
+			expectedError(t, string(output), v)
+		}
+	}
+}
+
+func TestQuoteWindows(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			// Nothing to quote
+			Input:    `C:\Users\goscp\file.txt`,
+			Expected: `C:\Users\goscp\file.txt`,
+		},
+		{
+			// Empty argument
+			Input:    "",
+			Expected: `""`,
+		},
+		{
+			// Spaces
+			Input:    `C:\Program Files\goscp`,
+			Expected: `"C:\Program Files\goscp"`,
+		},
+		{
+			// Trailing backslash
+			Input:    `C:\Program Files\`,
+			Expected: `"C:\Program Files\\"`,
+		},
+		{
+			// Embedded quotes
+			Input:    `say "hi" \"there\"`,
+			Expected: `"say \"hi\" \\\"there\\\""`,
+		},
+	}
+
+	for _, v := range tests {
+		output := QuoteWindows(v.Input)
+		if output != v.Expected {
+			expectedError(t, output, v.Expected)
+		}
+	}
+}
+
+func TestQuoteCmd(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			Input:    `C:\Users\goscp\file.txt`,
+			Expected: `C:\Users\goscp\file.txt`,
+		},
+		{
+			// A second command
+			Input:    "a&calc",
+			Expected: "a^&calc",
+		},
+		{
+			Input:    `x|y<z>w^(v)!`,
+			Expected: `x^|y^<z^>w^^^(v^)^!`,
+		},
+		{
+			// Quotes are escaped too, so the content stays outside cmd.exe's
+			// quoted sections
+			Input:    `C:\My Files\a&b`,
+			Expected: `^"C:\My Files\a^&b^"`,
+		},
+		{
+			Input:    "%PATH% 100%",
+			Expected: `^"%^PATH%^ 100%^^"`,
+		},
+	}
+
+	for _, v := range tests {
+		output := QuoteCmd(v.Input)
+		if output != v.Expected {
+			expectedError(t, output, v.Expected)
+		}
+	}
+}
+
+func TestQuotePowerShell(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			Input:    `C:\Users\goscp`,
+			Expected: `'C:\Users\goscp'`,
+		},
+		{
+			Input:    "",
+			Expected: "''",
+		},
+		{
+			Input:    "it's $env:HOME",
+			Expected: "'it''s $env:HOME'",
+		},
+		{
+			// Typographic quotes end the string too
+			Input:    "a\u2018b\u2019c\u201ad\u201be",
+			Expected: "'a\u2018\u2018b\u2019\u2019c\u201a\u201ad\u201b\u201be'",
+		},
+	}
+
+	for _, v := range tests {
+		output := QuotePowerShell(v.Input)
+		if output != v.Expected {
+			expectedError(t, output, v.Expected)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package goscp
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// BandwidthWindow applies a bandwidth limit during part of every day.
+type BandwidthWindow struct {
+	// Start and end of the window as time since local midnight. A window
+	// that ends before it starts wraps around midnight, e.g. 22:00 to 06:00.
+	Start time.Duration
+	End   time.Duration
+
+	// Maximum bytes per second during the window, 0 for no limit
+	Limit int64
+}
+
+// Check if the window contains the time of day d.
+func (w BandwidthWindow) contains(d time.Duration) bool {
+	if w.Start <= w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// Return the limit in effect at now: that of the first window in schedule
+// containing now, otherwise limit.
+func bandwidthAt(limit int64, schedule []BandwidthWindow, now time.Time) int64 {
+	y, m, d := now.Date()
+	timeOfDay := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+
+	for _, w := range schedule {
+		if w.contains(timeOfDay) {
+			return w.Limit
+		}
+	}
+	return limit
+}
+
+// Limits throughput to a number of bytes per second, allowing bursts of up
+// to one second's worth after idle periods such as waiting for an ack.
+type rateLimiter struct {
+	// Limit in effect at a time, 0 for no limit
+	rate func(time.Time) int64
+
+	mu sync.Mutex
+
+	// Bytes that can be passed without waiting, negative when in debt
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate func(time.Time) int64) *rateLimiter {
+	return &rateLimiter{rate: rate, last: time.Now()}
+}
+
+// Largest read or write passed at once, so that waits stay short and
+// progress output stays smooth.
+func (l *rateLimiter) chunk(n int) int {
+	if rate := l.rate(time.Now()); rate > 0 && int64(n) > rate {
+		return int(rate)
+	}
+	return n
+}
+
+// Account for n bytes, sleeping until they fit the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	rate := float64(l.rate(now))
+	if rate == 0 {
+		// Unlimited, start from scratch once a limit applies again
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / rate * float64(time.Second)))
+	}
+}
+
+// Reader limited by a rateLimiter.
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:r.l.chunk(len(p))])
+	r.l.wait(n)
+	return n, err
+}
+
+// WriteCloser limited by a rateLimiter.
+type limitedWriteCloser struct {
+	io.WriteCloser
+	l *rateLimiter
+}
+
+func (w *limitedWriteCloser) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := w.WriteCloser.Write(p[written : written+w.l.chunk(len(p)-written)])
+		written += n
+		w.l.wait(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,90 @@
+package goscp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3000)
+
+	// Read side
+	start := time.Now()
+	r := &limitedReader{r: bytes.NewReader(data), l: newRateLimiter(fixedRate(10000))}
+	if n, err := io.Copy(ioutil.Discard, r); err != nil || n != 3000 {
+		t.Error("Unexpected result:", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		expectedError(t, elapsed, 300*time.Millisecond)
+	}
+
+	// Write side
+	out := &bytes.Buffer{}
+	start = time.Now()
+	w := &limitedWriteCloser{WriteCloser: nopWriteCloser{out}, l: newRateLimiter(fixedRate(10000))}
+	if n, err := w.Write(data); err != nil || n != 3000 {
+		t.Error("Unexpected result:", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		expectedError(t, elapsed, 300*time.Millisecond)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		expectedError(t, out.Len(), len(data))
+	}
+
+	// Reads and writes are split into chunks of at most one second's worth
+	l := newRateLimiter(fixedRate(100))
+	if n := l.chunk(32 * 1024); n != 100 {
+		expectedError(t, n, 100)
+	}
+	if n := l.chunk(50); n != 50 {
+		expectedError(t, n, 50)
+	}
+}
+
+func fixedRate(rate int64) func(time.Time) int64 {
+	return func(time.Time) int64 {
+		return rate
+	}
+}
+
+func TestBandwidthAt(t *testing.T) {
+	schedule := []BandwidthWindow{
+		// Unthrottled at night
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 0},
+		{Start: 9 * time.Hour, End: 17 * time.Hour, Limit: 1 << 20},
+	}
+
+	tests := []struct {
+		Hour     int
+		Minute   int
+		Expected int64
+	}{
+		{Hour: 23, Expected: 0},
+		{Hour: 2, Expected: 0},
+		{Hour: 5, Minute: 59, Expected: 0},
+		{Hour: 6, Expected: 5 << 20},
+		{Hour: 9, Expected: 1 << 20},
+		{Hour: 16, Minute: 59, Expected: 1 << 20},
+		{Hour: 17, Expected: 5 << 20},
+		{Hour: 22, Expected: 0},
+	}
+
+	for _, v := range tests {
+		now := time.Date(2020, 3, 1, v.Hour, v.Minute, 0, 0, time.Local)
+		if limit := bandwidthAt(5<<20, schedule, now); limit != v.Expected {
+			expectedError(t, limit, v.Expected)
+		}
+	}
+
+	// Unlimited windows pass everything at once
+	l := newRateLimiter(fixedRate(0))
+	start := time.Now()
+	l.wait(1 << 30)
+	if n := l.chunk(1 << 20); n != 1<<20 || time.Since(start) > time.Second {
+		expectedError(t, n, 1<<20)
+	}
+}
@@ -0,0 +1,54 @@
+package goscp
+
+import (
+	"errors"
+	"io"
+)
+
+// Writer to the remote's stdin that marks its errors, to tell them apart
+// from errors reading the local file.
+type stdinWriter struct {
+	w io.Writer
+}
+
+func (w stdinWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		err = &stdinWriteError{err}
+	}
+	return n, err
+}
+
+// Error writing to the remote's stdin.
+type stdinWriteError struct {
+	err error
+}
+
+func (e *stdinWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stdinWriteError) Unwrap() error {
+	return e.err
+}
+
+// Explain a failed write to the remote. It usually stopped reading because
+// it gave up, and said why on stdout before exiting, which is more useful
+// than the write error.
+func (t *transfer) remoteWriteError(err error) error {
+	var writeErr *stdinWriteError
+	if !errors.As(err, &writeErr) {
+		return err
+	}
+
+	// Answers to earlier messages come first
+	if ackErr := t.flushAcks(); ackErr != nil {
+		return ackErr
+	}
+
+	var msgErr *RemoteMessageError
+	if ackErr := t.readAck(); errors.As(ackErr, &msgErr) {
+		return msgErr
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Stdin of a remote that stops reading after limit bytes.
+type closedStdin struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *closedStdin) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.limit {
+		return 0, io.EOF
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *closedStdin) Close() error {
+	return nil
+}
+
+func TestRemoteWriteError(t *testing.T) {
+	tests := []struct {
+		Remote        string
+		Pipeline      int
+		ExpectedError string
+		ExpectedCause error
+	}{
+		{
+			// The remote explains why it stopped reading
+			Remote:        "\x00\x02scp: /data/a.txt: Permission denied\n",
+			ExpectedError: `Could not send ["a.txt"]: Error message: ["scp: /data/a.txt: Permission denied"]`,
+			ExpectedCause: ErrPermissionDenied,
+		},
+		{
+			// With pipelining the ack of the file is still pending
+			Remote:        "\x00\x02scp: /data/a.txt: Permission denied\n",
+			Pipeline:      2,
+			ExpectedError: `Could not send ["a.txt"]: Error message: ["scp: /data/a.txt: Permission denied"]`,
+			ExpectedCause: ErrPermissionDenied,
+		},
+		{
+			// Nothing to explain it, the write error is kept
+			Remote:        "\x00",
+			ExpectedError: `Could not send ["a.txt"]: EOF`,
+			ExpectedCause: io.EOF,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{
+			opts:          TransferOptions{PipelineDepth: v.Pipeline},
+			scpStdinPipe:  &closedStdin{limit: len("C0644 3 a.txt\n")},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(v.Remote))},
+		}
+
+		err := c.sendStream(strings.NewReader("abc"), "a.txt", "a.txt", "a.txt", 0644, 3)
+		if err == nil || err.Error() != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+		if !errors.Is(err, v.ExpectedCause) {
+			expectedError(t, err, v.ExpectedCause)
+		}
+	}
+}
+
+func TestRemoteWriteErrorLocalRead(t *testing.T) {
+	// Errors reading the local file leave the remote alone
+	readErr := errors.New("disk failure")
+	c := &transfer{
+		scpStdinPipe:  &closedStdin{limit: 1 << 10},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader("\x00\x02scp: unread\n"))},
+	}
+
+	err := c.remoteWriteError(readErr)
+	if err != readErr {
+		expectedError(t, err, readErr)
+	}
+	if line, _ := c.scpStdoutPipe.ReadString('\n'); line != "\x00\x02scp: unread\n" {
+		expectedError(t, line, "\x00\x02scp: unread\n")
+	}
+}
@@ -0,0 +1,61 @@
+package goscp
+
+import (
+	"time"
+)
+
+// TransferReport summarizes what a transfer did.
+type TransferReport struct {
+	// Files transferred in full
+	Files int
+
+	// Directories transferred
+	Dirs int
+
+	// Bytes of file content transferred
+	Bytes int64
+
+	// Items left out: failed items the transfer went on after, files
+	// skipped by BeforeFile, excluded directories and special files
+	Skipped int
+
+	// Time from start to finish, or so far while running
+	Elapsed time.Duration
+}
+
+// Throughput returns the average rate in bytes per second.
+func (r TransferReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// Count an item left out of the transfer.
+func (t *transfer) countSkipped() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.skipped++
+}
+
+// Report returns a summary of the transfer, final once it has finished.
+func (tr *Transfer) Report() TransferReport {
+	p := tr.t.progress.snapshot()
+
+	tr.t.mu.Lock()
+	defer tr.t.mu.Unlock()
+
+	end := tr.t.finishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	return TransferReport{
+		Files:   p.Files,
+		Dirs:    p.Dirs,
+		Bytes:   p.Bytes,
+		Skipped: tr.t.skipped + len(tr.t.failures),
+		Elapsed: end.Sub(tr.t.startedAt),
+	}
+}
@@ -0,0 +1,59 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransferReport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-report")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	input := "D0755 0 dir\nC0644 3 a.txt\nabc\x00\x01scp: b.txt: Permission denied\nC0644 2 c.txt\nhi\x00E\n"
+
+	c := &transfer{
+		opts:          TransferOptions{ContinueOnError: true},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.startedAt = time.Now().Add(-time.Second)
+	c.handleDownload()
+	c.finishedAt = c.startedAt.Add(time.Second)
+
+	r := (&Transfer{t: c}).Report()
+	expected := TransferReport{Files: 2, Dirs: 1, Bytes: 5, Skipped: 1, Elapsed: time.Second}
+	if r != expected {
+		expectedError(t, r, expected)
+	}
+	if r.Throughput() != 5 {
+		expectedError(t, r.Throughput(), 5)
+	}
+}
+
+func TestThroughputZeroElapsed(t *testing.T) {
+	r := TransferReport{Bytes: 10}
+	if r.Throughput() != 0 {
+		expectedError(t, r.Throughput(), 0)
+	}
+}
+
+func TestReportItemWarnings(t *testing.T) {
+	c := &transfer{}
+	c.addWarning("a.txt", "Could not set times: operation not permitted")
+	c.addWarning("b.txt", "Could not set mode: operation not permitted")
+
+	r := (&Transfer{t: c}).Report()
+	if r.Skipped != 0 {
+		expectedError(t, r.Skipped, 0)
+	}
+}
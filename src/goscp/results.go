@@ -0,0 +1,83 @@
+package goscp
+
+import (
+	"time"
+)
+
+// DefaultMaxResults is the number of file outcomes a transfer keeps when
+// the MaxResults option is 0.
+const DefaultMaxResults = 10000
+
+// FileResult is the outcome of transferring a single file.
+type FileResult struct {
+	// File relative to the transfer root
+	RelPath string
+
+	// Size announced for the file
+	Size int64
+
+	// Time spent on the file
+	Duration time.Duration
+
+	// Why the file failed, nil on success
+	Err error
+}
+
+// Record the outcome of a file.
+func (t *transfer) addResult(relPath string, size int64, d time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if max := t.maxResults(); max > 0 && len(t.results) >= max {
+		t.results = dropResults(t.results)
+	}
+	t.results = append(t.results, FileResult{RelPath: relPath, Size: size, Duration: d, Err: err})
+}
+
+// Number of results kept with the MaxResults option, 0 for no limit.
+func (t *transfer) maxResults() int {
+	switch {
+	case t.opts.MaxResults < 0:
+		return 0
+	case t.opts.MaxResults == 0:
+		return DefaultMaxResults
+	}
+	return t.opts.MaxResults
+}
+
+// Make room in full results by dropping the older half of the successful
+// ones, or the older half of all when too few succeeded. The newest are
+// kept, as pipelined acknowledgements may still fail them.
+func dropResults(results []FileResult) []FileResult {
+	succeeded := 0
+	for _, r := range results {
+		if r.Err == nil {
+			succeeded++
+		}
+	}
+
+	drop := (succeeded + 1) / 2
+	if drop == 0 {
+		return append(results[:0], results[(len(results)+1)/2:]...)
+	}
+
+	kept := results[:0]
+	for _, r := range results {
+		if r.Err == nil && drop > 0 {
+			drop--
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// Results returns the outcome of the files the transfer has attempted so
+// far, in transfer order, so that failed files can be retried selectively.
+// Past the MaxResults option, older successful files are left out.
+func (tr *Transfer) Results() []FileResult {
+	tr.t.mu.Lock()
+	defer tr.t.mu.Unlock()
+
+	return append([]FileResult(nil), tr.t.results...)
+}
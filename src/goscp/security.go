@@ -0,0 +1,189 @@
+package goscp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Security limits what a remote host can do to the local filesystem during
+// a download. The zero value is the safe default: names are validated and
+// the top-level item must match the requested path, with no size or count
+// limits and no symlink checks.
+type Security struct {
+	// Accept names that are empty, ".", ".." or contain path separators.
+	// Such names let a hostile remote write outside the destination path.
+	AllowUnsafeNames bool `json:"allowUnsafeNames,omitempty"`
+
+	// Accept a top-level item whose name differs from the requested remote
+	// path, e.g. a request for "notes.txt" answered with ".bashrc"
+	AllowUnexpectedNames bool `json:"allowUnexpectedNames,omitempty"`
+
+	// Maximum size of a single file in bytes, 0 for no limit
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+
+	// Maximum total size of all files in bytes, 0 for no limit
+	MaxTotalSize int64 `json:"maxTotalSize,omitempty"`
+
+	// Maximum number of files and directories, 0 for no limit
+	MaxEntries int `json:"maxEntries,omitempty"`
+
+	// Maximum directory nesting below the destination path, 0 for no limit
+	MaxDepth int `json:"maxDepth,omitempty"`
+
+	// Resolve symlinks in every path about to be created and refuse those
+	// that end up outside the destination path, e.g. through a link that
+	// already exists below it
+	ConfineToDestination bool `json:"confineToDestination,omitempty"`
+}
+
+// PermissiveSecurity returns the legacy policy that trusts everything the
+// remote host sends, matching goscp releases before download protections
+// existed. Only use it with fully trusted hosts.
+func PermissiveSecurity() Security {
+	return Security{
+		AllowUnsafeNames:     true,
+		AllowUnexpectedNames: true,
+	}
+}
+
+// Reset the per-download security bookkeeping for remotePath.
+func (t *transfer) startSecurityCheck(remotePaths ...string) {
+	t.rootDepth = len(t.path)
+	t.expectedNames = nil
+	t.entries = 0
+	t.received = 0
+
+	for _, p := range remotePaths {
+		name := path.Base(p)
+
+		// "." and ".." are sent by name rather than as given
+		if name == "." || name == ".." || name == "/" {
+			t.expectedNames = nil
+			return
+		}
+		// Received names are normalized before they're checked
+		t.expectedNames = append(t.expectedNames, t.normalizeName(name))
+	}
+}
+
+// Check if name was requested at the root of a download.
+func (t *transfer) isExpectedName(name string) bool {
+	if len(t.expectedNames) == 0 {
+		return true
+	}
+
+	for _, expected := range t.expectedNames {
+		if name == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// Check an incoming file or directory message against the Security option.
+func (t *transfer) checkMessage(m message) error {
+	s := t.opts.Security
+
+	if err := t.checkStream(m); err != nil {
+		return err
+	}
+
+	if !s.AllowUnsafeNames && !isSafeName(m.Name) {
+		return refusedErrorf("Refusing unsafe name from remote: [%q]", m.Name)
+	}
+
+	depth := len(t.path) - t.rootDepth
+	// Requested names are compared with the name before SanitizeName
+	sent := m.Name
+	if m.SentName != "" {
+		sent = m.SentName
+	}
+	if !s.AllowUnexpectedNames && depth == 0 && !t.isExpectedName(sent) {
+		return refusedErrorf("Refusing unexpected name from remote: [%q], requested %q", m.Name, t.expectedNames)
+	}
+
+	if s.MaxDepth > 0 && m.Type == 'D' && depth >= s.MaxDepth {
+		return refusedErrorf("Refusing directory beyond maximum depth %d: [%q]", s.MaxDepth, m.Name)
+	}
+
+	t.entries++
+	if s.MaxEntries > 0 && t.entries > s.MaxEntries {
+		return refusedErrorf("Refusing more than %d entries: [%q]", s.MaxEntries, m.Name)
+	}
+
+	if m.Type == 'C' {
+		if s.MaxFileSize > 0 && m.Length > s.MaxFileSize {
+			return refusedErrorf("Refusing file larger than %d bytes: [%q]", s.MaxFileSize, m.Name)
+		}
+
+		t.received += m.Length
+		if s.MaxTotalSize > 0 && t.received > s.MaxTotalSize {
+			return refusedErrorf("Refusing more than %d bytes in total: [%q]", s.MaxTotalSize, m.Name)
+		}
+	}
+
+	return nil
+}
+
+// Check that name refers to an entry directly inside the current directory.
+// Besides separators and dot-dot, NUL bytes would cut the name short in
+// system calls, and a volume name like "C:" escapes the directory on
+// Windows.
+func isSafeName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+
+	if strings.IndexByte(name, 0) >= 0 || filepath.VolumeName(name) != "" {
+		return false
+	}
+
+	return !strings.ContainsAny(name, "/"+string(filepath.Separator))
+}
+
+// Check with the ConfineToDestination option that p, about to be created,
+// stays below the destination path once symlinks are resolved. An existing
+// link at p itself would be followed when opening it, so it is resolved too.
+func (t *transfer) confine(p string) error {
+	if !t.opts.Security.ConfineToDestination || t.opts.WriteFS != nil {
+		return nil
+	}
+
+	outside := refusedErrorf("Refusing path outside the destination: [%q]", p)
+
+	root, err := filepath.EvalSymlinks(filepath.Join(t.path[:t.rootDepth]...))
+	if err != nil {
+		return fmt.Errorf("Could not resolve destination: %w", err)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("Could not resolve destination: %w", err)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return outside
+	}
+	target := filepath.Join(parent, filepath.Base(p))
+
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// Dangling links are refused, opening them creates their target
+		if target, err = filepath.EvalSymlinks(target); err != nil {
+			return outside
+		}
+	}
+
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return outside
+	}
+
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return outside
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsSafeName(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected bool
+	}{
+		{Input: "file.txt", Expected: true},
+		{Input: "..file", Expected: true},
+		{Input: "file name", Expected: true},
+		{Input: "", Expected: false},
+		{Input: ".", Expected: false},
+		{Input: "..", Expected: false},
+		{Input: "../../.ssh/authorized_keys", Expected: false},
+		{Input: "/etc/passwd", Expected: false},
+		{Input: "dir/file", Expected: false},
+		{Input: "file\x00.txt", Expected: false},
+	}
+
+	for _, v := range tests {
+		if output := isSafeName(v.Input); output != v.Expected {
+			t.Errorf("%q: received: %v, expected: %v", v.Input, output, v.Expected)
+		}
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	tests := []struct {
+		Security        Security
+		RemotePath      string
+		DestinationPath []string
+		Messages        []string
+		ExpectedError   string
+	}{
+		{
+			// Regular directory tree
+			RemotePath:      "/var/www/media",
+			DestinationPath: []string{"."},
+			Messages:        []string{"D0755 0 media", "C0644 10 a.txt"},
+		},
+		{
+			// Traversal in a file name
+			RemotePath:      "/var/www/media",
+			DestinationPath: []string{".", "media"},
+			Messages:        []string{"C0644 10 ../../.ssh/authorized_keys"},
+			ExpectedError:   `Refusing unsafe name from remote: ["../../.ssh/authorized_keys"]`,
+		},
+		{
+			// Traversal in a directory name
+			RemotePath:      "/var/www/media",
+			DestinationPath: []string{".", "media"},
+			Messages:        []string{"D0755 0 .."},
+			ExpectedError:   `Refusing unsafe name from remote: [".."]`,
+		},
+		{
+			// Legacy permissive policy
+			Security:        PermissiveSecurity(),
+			RemotePath:      "/var/www/media",
+			DestinationPath: []string{"."},
+			Messages:        []string{"D0755 0 ..", "C0644 10 .bashrc"},
+		},
+		{
+			// Top level name differs from the request
+			RemotePath:      "/home/user/notes.txt",
+			DestinationPath: []string{"."},
+			Messages:        []string{"C0644 10 .bashrc"},
+			ExpectedError:   `Refusing unexpected name from remote: [".bashrc"], requested ["notes.txt"]`,
+		},
+		{
+			// Current directory is sent by name
+			RemotePath:      ".",
+			DestinationPath: []string{"."},
+			Messages:        []string{"D0755 0 user"},
+		},
+		{
+			// File size limit
+			Security:        Security{MaxFileSize: 5},
+			RemotePath:      "a.txt",
+			DestinationPath: []string{"."},
+			Messages:        []string{"C0644 10 a.txt"},
+			ExpectedError:   `Refusing file larger than 5 bytes: ["a.txt"]`,
+		},
+		{
+			// Total size limit
+			Security:        Security{MaxTotalSize: 15},
+			RemotePath:      "media",
+			DestinationPath: []string{"."},
+			Messages:        []string{"D0755 0 media", "C0644 10 a.txt", "C0644 10 b.txt"},
+			ExpectedError:   `Refusing more than 15 bytes in total: ["b.txt"]`,
+		},
+		{
+			// Entry count limit
+			Security:        Security{MaxEntries: 2},
+			RemotePath:      "media",
+			DestinationPath: []string{"."},
+			Messages:        []string{"D0755 0 media", "C0644 1 a.txt", "C0644 1 b.txt"},
+			ExpectedError:   `Refusing more than 2 entries: ["b.txt"]`,
+		},
+		{
+			// Depth limit
+			Security:        Security{MaxDepth: 1},
+			RemotePath:      "media",
+			DestinationPath: []string{"."},
+			Messages:        []string{"D0755 0 media", "D0755 0 nested"},
+			ExpectedError:   `Refusing directory beyond maximum depth 1: ["nested"]`,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: TransferOptions{Security: v.Security}}
+		c.path = []string{"."}
+		c.startSecurityCheck(v.RemotePath)
+		c.path = v.DestinationPath
+
+		var err error
+		for _, msg := range v.Messages {
+			m, _ := c.parseMessage(msg)
+			if err = c.checkMessage(m); err != nil {
+				break
+			}
+			if m.Type == 'D' {
+				c.path = append(c.path, m.Name)
+			}
+		}
+
+		if err == nil && v.ExpectedError != "" {
+			expectedError(t, nil, v.ExpectedError)
+		} else if err != nil && err.Error() != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+	}
+}
+
+func TestConfineToDestination(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-confine")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dest := filepath.Join(tmp, "dest")
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{filepath.Join(dest, "real"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dest, "real", "inside.txt"), nil, 0644); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	links := map[string]string{
+		"escape.txt":   filepath.Join(outside, "escape.txt"),
+		"dangling.txt": filepath.Join(outside, "missing", "x"),
+		"inside.txt":   filepath.Join(dest, "real", "inside.txt"),
+		"linkdir":      outside,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dest, name)); err != nil {
+			t.Skip("Symlinks not supported:", err)
+		}
+	}
+
+	tests := []struct {
+		Path     []string
+		Name     string
+		Expected bool
+	}{
+		{Path: []string{dest}, Name: "new.txt", Expected: true},
+		{Path: []string{dest, "real"}, Name: "new.txt", Expected: true},
+		{Path: []string{dest}, Name: "inside.txt", Expected: true},
+		{Path: []string{dest}, Name: "escape.txt", Expected: false},
+		{Path: []string{dest}, Name: "dangling.txt", Expected: false},
+		{Path: []string{dest, "linkdir"}, Name: "new.txt", Expected: false},
+	}
+
+	for _, v := range tests {
+		c := &transfer{opts: TransferOptions{Security: Security{ConfineToDestination: true}}}
+		c.path = v.Path
+		c.rootDepth = 1
+
+		err := c.confine(filepath.Join(append(v.Path, v.Name)...))
+		if (err == nil) != v.Expected {
+			t.Errorf("%s: received: %v, expected allowed: %v", v.Name, err, v.Expected)
+		}
+		if err != nil && !errors.Is(err, ErrRefused) {
+			expectedError(t, err, ErrRefused)
+		}
+	}
+
+	// A download writing through the link is stopped before the file is
+	// created outside
+	c := &transfer{
+		opts:          TransferOptions{Security: Security{AllowUnexpectedNames: true, ConfineToDestination: true}},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader("C0644 2 escape.txt\nhi\x00"))},
+	}
+	c.path = []string{dest}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	if len(c.errors) != 1 || !errors.Is(c.errors[0], ErrRefused) {
+		expectedError(t, c.errors, ErrRefused)
+	}
+	if _, err := os.Stat(links["escape.txt"]); !os.IsNotExist(err) {
+		expectedError(t, err, "escape.txt not created")
+	}
+}
+
+func TestSecurityCheckPaths(t *testing.T) {
+	c := &transfer{}
+	c.path = []string{"."}
+	c.startSecurityCheck("/var/log/syslog", "/etc/hosts")
+
+	for _, name := range []string{"syslog", "hosts"} {
+		if err := c.checkMessage(message{Type: 'C', Name: name}); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	}
+
+	expected := `Refusing unexpected name from remote: ["passwd"], requested ["syslog" "hosts"]`
+	if err := c.checkMessage(message{Type: 'C', Name: "passwd"}); err == nil || err.Error() != expected {
+		expectedError(t, err, expected)
+	}
+
+	// "." matches anything, so no name is checked
+	c.startSecurityCheck("/etc/hosts", "/srv/.")
+	if err := c.checkMessage(message{Type: 'C', Name: "passwd"}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
@@ -0,0 +1,23 @@
+package goscp
+
+import (
+	"testing"
+)
+
+func TestIsSafeNameWindows(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected bool
+	}{
+		{Input: `..\..\Users\me\.ssh\authorized_keys`, Expected: false},
+		{Input: `C:evil.exe`, Expected: false},
+		{Input: `\\server\share`, Expected: false},
+		{Input: "file.txt", Expected: true},
+	}
+
+	for _, v := range tests {
+		if output := isSafeName(v.Input); output != v.Expected {
+			t.Errorf("%q: received: %v, expected: %v", v.Input, output, v.Expected)
+		}
+	}
+}
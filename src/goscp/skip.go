@@ -0,0 +1,84 @@
+package goscp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// SkipCurrent skips the file in progress and goes on with the next one,
+// unlike Cancel which stops the whole transfer. The file ends with
+// ErrSkipFile in the results. A download discards the rest of the content
+// and removes the partial file. An upload sends zero bytes for the rest, as
+// the size was already announced, and tells the remote the file failed;
+// the remote may keep what it received.
+//
+// Returns false when no file is in progress.
+func (tr *Transfer) SkipCurrent() bool {
+	current := tr.t.progress.snapshot().Current
+	if current == "" {
+		return false
+	}
+
+	tr.t.mu.Lock()
+	defer tr.t.mu.Unlock()
+
+	tr.t.skipPath = current
+	return true
+}
+
+// Check if skipping relPath was requested.
+func (t *transfer) skipRequested(relPath string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.skipPath == relPath
+}
+
+// Clear a skip request once handled.
+func (t *transfer) clearSkip() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.skipPath = ""
+}
+
+// Read and discard the remaining bytes of a file skipped with SkipCurrent,
+// removing what was written of it when it was created here.
+func (t *transfer) skipReceivedFile(h *FileHeader, remaining int64, w io.Closer, created bool) error {
+	t.outputInfo(fmt.Sprintf("Skipping file: %s", h.RelPath))
+	t.clearSkip()
+	t.progress.abandonFile()
+
+	if _, err := io.CopyN(ioutil.Discard, t.scpStdoutPipe, remaining); err != nil {
+		return fmt.Errorf("Could not skip [%q]: %w", h.LocalPath, err)
+	}
+	if err := t.readAck(); err != nil {
+		return fmt.Errorf("Could not skip [%q]: %w", h.LocalPath, err)
+	}
+	t.sendAck(t.scpStdinPipe)
+
+	if err := t.padTarFile(h, remaining); err != nil {
+		return err
+	}
+	if created {
+		w.Close()
+		t.removeLocal(h.LocalPath)
+	}
+
+	return ErrSkipFile
+}
+
+// Fails with ErrSkipFile once skipping its file is requested.
+type skipReader struct {
+	r       io.Reader
+	t       *transfer
+	relPath string
+}
+
+func (r *skipReader) Read(p []byte) (int, error) {
+	if r.t.skipRequested(r.relPath) {
+		return 0, ErrSkipFile
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,95 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSkipCurrent(t *testing.T) {
+	tr := &Transfer{t: &transfer{}}
+	if tr.SkipCurrent() {
+		expectedError(t, true, false)
+	}
+
+	tr.t.progress.startFile("dir/a.txt")
+	if !tr.SkipCurrent() || !tr.t.skipRequested("dir/a.txt") {
+		expectedError(t, tr.t.skipPath, "dir/a.txt")
+	}
+	if tr.t.skipRequested("dir/b.txt") {
+		expectedError(t, tr.t.skipPath, "dir/a.txt")
+	}
+}
+
+func TestSkipDownload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-skip")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	out := &bytes.Buffer{}
+	input := "C0644 6 a.txt\nabcdef\x00C0644 2 b.txt\nbb\x00"
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.skipPath = "a.txt"
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+
+	// Initial ack, then two for each file
+	if out.String() != "\x00\x00\x00\x00\x00" {
+		expectedError(t, out.String(), "\x00\x00\x00\x00\x00")
+	}
+
+	if _, err := os.Stat(filepath.Join(tmp, "a.txt")); err == nil {
+		expectedError(t, "a.txt", "removed")
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(tmp, "b.txt")); string(content) != "bb" {
+		expectedError(t, string(content), "bb")
+	}
+
+	if len(c.results) != 2 || c.results[0].Err != ErrSkipFile || c.results[1].Err != nil {
+		expectedError(t, c.results, "a.txt skipped, b.txt received")
+	}
+	if c.skipped != 1 || c.skipPath != "" {
+		expectedError(t, c.skipped, 1)
+	}
+}
+
+func TestSkipUpload(t *testing.T) {
+	out := &bytes.Buffer{}
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	c.skipPath = "a.txt"
+
+	err := c.sendStream(strings.NewReader("abc"), "a.txt", "a.txt", "a.txt", 0644, 3)
+	if err != ErrSkipFile {
+		expectedError(t, err, ErrSkipFile)
+	}
+
+	// The announced size is padded and the remote told the file failed
+	expected := "C0644 3 a.txt\n\x00\x00\x00\x01scp: File skipped\n"
+	if out.String() != expected {
+		expectedError(t, out.String(), expected)
+	}
+
+	if err := c.uploadFailure(err); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if c.skipped != 1 || len(c.failures) != 0 {
+		expectedError(t, c.skipped, 1)
+	}
+}
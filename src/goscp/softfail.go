@@ -0,0 +1,112 @@
+package goscp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ItemFailure is an item skipped after an error, while the transfer went on.
+type ItemFailure struct {
+	// Path relative to the transfer root
+	RelPath string
+
+	Err error
+}
+
+// SoftFailError is returned by SoftFail transfers when more items failed
+// than MaxFailures allows.
+type SoftFailError struct {
+	Failures    []ItemFailure
+	MaxFailures int
+}
+
+func (e *SoftFailError) Error() string {
+	return fmt.Sprintf("%d items failed, more than the %d allowed", len(e.Failures), e.MaxFailures)
+}
+
+// Check if failed items are skipped rather than stopping the transfer.
+func (t *transfer) continueOnError() bool {
+	return t.opts.ContinueOnError || t.opts.SoftFail
+}
+
+// Record an error that only failed the current item.
+func (t *transfer) failItem(err error) {
+	t.outputInfo(fmt.Sprintf("Item failed: %s", err))
+	t.recordError(err, true)
+}
+
+// Decide whether an upload goes on after a file failed with err. Failures
+// that leave the stream in sync are recorded and nil is returned.
+func (t *transfer) uploadFailure(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	_, changed := err.(*SizeChangedError)
+	skippable, skip := err.(*skippableError)
+	switch {
+	case err == ErrSkipFile:
+		t.countSkipped()
+	case changed || errors.Is(err, ErrFileTimeout):
+		t.failItem(err)
+	case skip && t.opts.SoftFail:
+		t.failItem(skippable.err)
+	case skip:
+		return skippable.err
+	default:
+		return err
+	}
+
+	return nil
+}
+
+// Return the error of a SoftFail transfer: an error that stopped it, a
+// *SoftFailError when too many items failed, nil otherwise.
+func (t *transfer) softFailError(ctx context.Context) error {
+	if err := t.contextError(ctx); err == ctx.Err() && err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failed := make(map[int]bool)
+	for _, i := range t.failedIndex {
+		failed[i] = true
+	}
+
+	for i, err := range t.errors {
+		if failed[i] {
+			continue
+		}
+
+		// The remote scp exits with status 1 after skipping items
+		var exitErr *ssh.ExitError
+		if len(t.failures) > 0 && errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+			continue
+		}
+
+		return err
+	}
+
+	if len(t.failures) > t.opts.MaxFailures {
+		return &SoftFailError{
+			Failures:    append([]ItemFailure(nil), t.failures...),
+			MaxFailures: t.opts.MaxFailures,
+		}
+	}
+
+	return nil
+}
+
+// Failures returns the items that failed so far without stopping the
+// transfer.
+func (tr *Transfer) Failures() []ItemFailure {
+	tr.t.mu.Lock()
+	defer tr.t.mu.Unlock()
+
+	return append([]ItemFailure(nil), tr.t.failures...)
+}
@@ -0,0 +1,103 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSoftFailDownload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-softfail")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// A directory in the way makes a.txt fail
+	os.Mkdir(filepath.Join(tmp, "a.txt"), 0755)
+	input := "C0644 1 a.txt\n\x01scp: b.txt: Permission denied\nC0644 2 c.txt\nhi\x00"
+
+	tests := []struct {
+		MaxFailures      int
+		ExpectedFailures []string
+		ExpectedError    bool
+	}{
+		{
+			MaxFailures:      2,
+			ExpectedFailures: []string{"a.txt", ""},
+		},
+		{
+			MaxFailures:      1,
+			ExpectedFailures: []string{"a.txt", ""},
+			ExpectedError:    true,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{
+			opts:          TransferOptions{SoftFail: true, MaxFailures: v.MaxFailures},
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+		}
+		c.path = []string{tmp}
+		c.rootDepth = 1
+		c.handleDownload()
+
+		var failed []string
+		for _, f := range c.failures {
+			failed = append(failed, f.RelPath)
+		}
+		if strings.Join(failed, ",") != strings.Join(v.ExpectedFailures, ",") {
+			expectedError(t, failed, v.ExpectedFailures)
+		}
+
+		err := c.softFailError(context.Background())
+		if _, ok := err.(*SoftFailError); ok != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+
+		if content, _ := ioutil.ReadFile(filepath.Join(tmp, "c.txt")); string(content) != "hi" {
+			expectedError(t, string(content), "hi")
+		}
+	}
+}
+
+func TestSoftFailUpload(t *testing.T) {
+	f, err := ioutil.TempFile("", "goscp-softfail")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	f.Close()
+	info, _ := os.Stat(f.Name())
+
+	// Gone by the time it is sent
+	os.Remove(f.Name())
+
+	for _, softFail := range []bool{false, true} {
+		out := &bytes.Buffer{}
+		c := &transfer{
+			opts:          TransferOptions{SoftFail: softFail},
+			scpStdinPipe:  nopWriteCloser{out},
+			scpStdoutPipe: acceptingRemote(),
+		}
+
+		err := c.handleItem(f.Name(), info, nil)
+		if (err != nil) == softFail {
+			expectedError(t, err, softFail)
+		}
+		if softFail && len(c.failures) != 1 {
+			expectedError(t, c.failures, "one failure")
+		}
+		if out.Len() != 0 {
+			expectedError(t, out.String(), "")
+		}
+		if err := c.softFailError(context.Background()); softFail && err == nil {
+			expectedError(t, err, "*SoftFailError")
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package goscp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Directions of a JobSpec.
+const (
+	DirectionDownload = "download"
+	DirectionUpload   = "upload"
+)
+
+// TransferSpec declares transfer jobs, for config-driven transfers. It can be
+// built in Go or read from JSON with ParseTransferSpec, and is run with
+// Client.RunSpec.
+type TransferSpec struct {
+	// Jobs run in order
+	Jobs []JobSpec `json:"jobs"`
+
+	// Run the remaining jobs after one fails
+	ContinueOnJobError bool `json:"continueOnJobError,omitempty"`
+
+	// Hooks given to every job, only settable from Go
+	Hooks SpecHooks `json:"-"`
+}
+
+// JobSpec is a transfer of one or more sources to a destination. Unset
+// fields keep the Client's TransferOptions; pointer fields set to false or 0
+// turn the Client's option off.
+type JobSpec struct {
+	// Name used in errors, defaults to the job's position
+	Name string `json:"name,omitempty"`
+
+	// DirectionDownload or DirectionUpload
+	Direction string `json:"direction"`
+
+	// Remote paths for downloads, local paths for uploads
+	Sources []string `json:"sources"`
+
+	// Local directory for downloads, remote directory for uploads
+	Destination string `json:"destination"`
+
+	// Filters
+	ExcludeMarkers []string `json:"excludeMarkers,omitempty"`
+
+	// Policies, see the TransferOptions fields of the same names
+	ContinueOnError *bool     `json:"continueOnError,omitempty"`
+	StopOnOSError   *bool     `json:"stopOnOSError,omitempty"`
+	SoftFail        *bool     `json:"softFail,omitempty"`
+	MaxFailures     *int      `json:"maxFailures,omitempty"`
+	PreserveMode    *bool     `json:"preserveMode,omitempty"`
+	PreserveTimes   *bool     `json:"preserveTimes,omitempty"`
+	BandwidthLimit  *int64    `json:"bandwidthLimit,omitempty"`
+	PipelineDepth   *int      `json:"pipelineDepth,omitempty"`
+	Security        *Security `json:"security,omitempty"`
+
+	// Durations in time.ParseDuration form, e.g. "10m"
+	Timeout      string `json:"timeout,omitempty"`
+	FileTimeout  string `json:"fileTimeout,omitempty"`
+	StallTimeout string `json:"stallTimeout,omitempty"`
+}
+
+// SpecHooks are the TransferOptions hooks applied to the jobs of a
+// TransferSpec. Nil hooks keep the Client's.
+type SpecHooks struct {
+	BeforeFile func(h *FileHeader) error
+	OnError    func(relPath string, phase Phase, err error)
+	OnDirStart func(relPath string)
+	OnDirEnd   func(relPath string, stats DirStats)
+}
+
+// ParseTransferSpec reads a TransferSpec from JSON and validates it. Unknown
+// fields are rejected, so typos don't silently change a job.
+func ParseTransferSpec(r io.Reader) (*TransferSpec, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	spec := &TransferSpec{}
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("Could not parse transfer spec: %w", err)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Validate checks that every job of the spec can run.
+func (s *TransferSpec) Validate() error {
+	if len(s.Jobs) == 0 {
+		return fmt.Errorf("Transfer spec has no jobs")
+	}
+
+	for i, job := range s.Jobs {
+		if err := job.validate(); err != nil {
+			return fmt.Errorf("Job [%q]: %w", job.name(i), err)
+		}
+	}
+	return nil
+}
+
+// Name of the job at index i.
+func (j JobSpec) name(i int) string {
+	if j.Name != "" {
+		return j.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+func (j JobSpec) validate() error {
+	if j.Direction != DirectionDownload && j.Direction != DirectionUpload {
+		return fmt.Errorf("Unknown direction [%q]", j.Direction)
+	}
+	if len(j.Sources) == 0 {
+		return fmt.Errorf("No sources")
+	}
+	for _, source := range j.Sources {
+		if source == "" {
+			return fmt.Errorf("Empty source")
+		}
+	}
+	if j.Destination == "" {
+		return fmt.Errorf("No destination")
+	}
+
+	_, err := j.options(TransferOptions{}, SpecHooks{})
+	return err
+}
+
+// Build the options of the job on top of base.
+func (j JobSpec) options(base TransferOptions, hooks SpecHooks) (TransferOptions, error) {
+	opts := base
+	opts.DestinationPath = []string{j.Destination}
+
+	if j.ExcludeMarkers != nil {
+		opts.ExcludeMarkers = j.ExcludeMarkers
+	}
+	flags := []struct {
+		value *bool
+		opt   *bool
+	}{
+		{j.ContinueOnError, &opts.ContinueOnError},
+		{j.StopOnOSError, &opts.StopOnOSError},
+		{j.SoftFail, &opts.SoftFail},
+		{j.PreserveMode, &opts.PreserveMode},
+		{j.PreserveTimes, &opts.PreserveTimes},
+	}
+	for _, f := range flags {
+		if f.value != nil {
+			*f.opt = *f.value
+		}
+	}
+	if j.MaxFailures != nil {
+		opts.MaxFailures = *j.MaxFailures
+	}
+	if j.BandwidthLimit != nil {
+		opts.BandwidthLimit = *j.BandwidthLimit
+	}
+	if j.PipelineDepth != nil {
+		opts.PipelineDepth = *j.PipelineDepth
+	}
+	if j.Security != nil {
+		opts.Security = *j.Security
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		opt   *time.Duration
+	}{
+		{"timeout", j.Timeout, &opts.Timeout},
+		{"fileTimeout", j.FileTimeout, &opts.FileTimeout},
+		{"stallTimeout", j.StallTimeout, &opts.StallTimeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v < 0 {
+			return opts, fmt.Errorf("Invalid %s [%q]", d.name, d.value)
+		}
+		*d.opt = v
+	}
+
+	if hooks.BeforeFile != nil {
+		opts.BeforeFile = hooks.BeforeFile
+	}
+	if hooks.OnError != nil {
+		opts.OnError = hooks.OnError
+	}
+	if hooks.OnDirStart != nil {
+		opts.OnDirStart = hooks.OnDirStart
+	}
+	if hooks.OnDirEnd != nil {
+		opts.OnDirEnd = hooks.OnDirEnd
+	}
+
+	return opts, nil
+}
+
+// RunSpec validates spec and runs its jobs in order, each source as its own
+// transfer with the Client's TransferOptions as defaults. It stops at the
+// first failed job unless ContinueOnJobError is set, and returns the first
+// error; GetErrorStack has all of them.
+func (c *Client) RunSpec(ctx context.Context, spec *TransferSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	var first error
+	for i, job := range spec.Jobs {
+		opts, err := job.options(c.TransferOptions, spec.Hooks)
+		if err != nil {
+			return fmt.Errorf("Job [%q]: %w", job.name(i), err)
+		}
+
+		for _, source := range job.Sources {
+			if job.Direction == DirectionDownload {
+				err = c.DownloadWithOptions(ctx, source, opts)
+			} else {
+				err = c.UploadWithOptions(ctx, source, opts)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("Job [%q]: %w", job.name(i), err)
+		if first == nil {
+			first = err
+		}
+		if !spec.ContinueOnJobError || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,156 @@
+package goscp
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTransferSpec(t *testing.T) {
+	tests := []struct {
+		Input         string
+		ExpectedError string
+	}{
+		{
+			Input: `{"jobs": [
+				{"name": "www", "direction": "upload", "sources": ["./build"], "destination": "/srv/www",
+				 "excludeMarkers": [".nobackup"], "preserveTimes": true, "timeout": "10m",
+				 "security": {"maxFileSize": 1024}},
+				{"direction": "download", "sources": ["/var/log/app", "/etc/app"], "destination": "./backup"}
+			]}`,
+		},
+		{
+			Input:         `{"jobs": []}`,
+			ExpectedError: "Transfer spec has no jobs",
+		},
+		{
+			Input:         `{"jobs": [{"direction": "sideways", "sources": ["a"], "destination": "b"}]}`,
+			ExpectedError: `Job ["#1"]: Unknown direction ["sideways"]`,
+		},
+		{
+			Input:         `{"jobs": [{"name": "logs", "direction": "download", "destination": "b"}]}`,
+			ExpectedError: `Job ["logs"]: No sources`,
+		},
+		{
+			Input:         `{"jobs": [{"direction": "download", "sources": ["a"]}]}`,
+			ExpectedError: `Job ["#1"]: No destination`,
+		},
+		{
+			Input:         `{"jobs": [{"direction": "download", "sources": ["a"], "destination": "b", "timeout": "soon"}]}`,
+			ExpectedError: `Job ["#1"]: Invalid timeout ["soon"]`,
+		},
+		{
+			Input:         `{"jobs": [{"direction": "download", "sources": ["a"], "destination": "b", "presrveMode": true}]}`,
+			ExpectedError: `Could not parse transfer spec: json: unknown field "presrveMode"`,
+		},
+	}
+
+	for _, v := range tests {
+		_, err := ParseTransferSpec(strings.NewReader(v.Input))
+		if v.ExpectedError == "" {
+			if err != nil {
+				t.Error("Unexpected error:", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+	}
+}
+
+func TestJobSpecOptions(t *testing.T) {
+	var started []string
+	base := TransferOptions{
+		DestinationPath: []string{"/ignored"},
+		Verbose:         true,
+		BandwidthLimit:  1000,
+		OnDirStart:      func(string) {},
+	}
+	hooks := SpecHooks{OnDirStart: func(relPath string) { started = append(started, relPath) }}
+	preserveMode := true
+
+	job := JobSpec{
+		Direction:      DirectionUpload,
+		Sources:        []string{"./build"},
+		Destination:    "/srv/www",
+		ExcludeMarkers: []string{".nobackup"},
+		PreserveMode:   &preserveMode,
+		FileTimeout:    "30s",
+		Security:       &Security{MaxEntries: 10},
+	}
+
+	opts, err := job.options(base, hooks)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if !reflect.DeepEqual(opts.DestinationPath, []string{"/srv/www"}) {
+		expectedError(t, opts.DestinationPath, []string{"/srv/www"})
+	}
+	if !reflect.DeepEqual(opts.ExcludeMarkers, []string{".nobackup"}) {
+		expectedError(t, opts.ExcludeMarkers, []string{".nobackup"})
+	}
+	if !opts.PreserveMode || !opts.Verbose {
+		expectedError(t, opts, "PreserveMode and Verbose set")
+	}
+	if opts.BandwidthLimit != 1000 {
+		expectedError(t, opts.BandwidthLimit, 1000)
+	}
+	if opts.FileTimeout != 30*time.Second {
+		expectedError(t, opts.FileTimeout, 30*time.Second)
+	}
+	if opts.Security.MaxEntries != 10 {
+		expectedError(t, opts.Security.MaxEntries, 10)
+	}
+
+	opts.OnDirStart("dir")
+	if !reflect.DeepEqual(started, []string{"dir"}) {
+		expectedError(t, started, []string{"dir"})
+	}
+}
+
+func TestJobSpecOverrides(t *testing.T) {
+	input := `{"jobs": [{"direction": "upload", "sources": ["a"], "destination": "b",
+		"softFail": false, "preserveTimes": false, "pipelineDepth": 0,
+		"security": {"confineToDestination": true}}]}`
+
+	spec, err := ParseTransferSpec(strings.NewReader(input))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	base := TransferOptions{SoftFail: true, PreserveTimes: true, PreserveMode: true, PipelineDepth: 4}
+	opts, err := spec.Jobs[0].options(base, SpecHooks{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if opts.SoftFail || opts.PreserveTimes || opts.PipelineDepth != 0 {
+		expectedError(t, opts, "SoftFail, PreserveTimes and PipelineDepth turned off")
+	}
+	if !opts.PreserveMode {
+		expectedError(t, opts.PreserveMode, true)
+	}
+	if !opts.Security.ConfineToDestination {
+		expectedError(t, opts.Security.ConfineToDestination, true)
+	}
+
+	out, err := json.Marshal(spec.Jobs[0].Security)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected := `{"confineToDestination":true}`; string(out) != expected {
+		expectedError(t, string(out), expected)
+	}
+}
+
+func TestRunSpecInvalid(t *testing.T) {
+	c := NewClient(nil)
+	err := c.RunSpec(context.Background(), &TransferSpec{Jobs: []JobSpec{{Direction: DirectionUpload}}})
+	if err == nil || err.Error() != `Job ["#1"]: No sources` {
+		expectedError(t, err, `Job ["#1"]: No sources`)
+	}
+}
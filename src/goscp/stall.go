@@ -0,0 +1,72 @@
+package goscp
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Time of the last data moved over the session, in Unix nanoseconds.
+type activity struct {
+	last int64
+}
+
+// Record data moving now.
+func (a *activity) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+// Time since data last moved.
+func (a *activity) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// Reader recording activity on every read.
+type activityReader struct {
+	r io.Reader
+	a *activity
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.a.touch()
+	}
+	return n, err
+}
+
+// WriteCloser recording activity on every write.
+type activityWriteCloser struct {
+	io.WriteCloser
+	a *activity
+}
+
+func (w *activityWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if n > 0 {
+		w.a.touch()
+	}
+	return n, err
+}
+
+// Cancel the transfer with ErrStalled once no data has moved over the
+// session for timeout.
+func (t *transfer) watchStall(timeout time.Duration, stop <-chan struct{}) {
+	// Tiny timeouts are checked every millisecond, a zero tick would panic
+	ticker := time.NewTicker(max(timeout/4, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if idle := t.activity.idle(); idle >= timeout {
+				t.addError(fmt.Errorf("%w: no data moved for %s", ErrStalled, idle.Round(time.Second)))
+				t.cancel()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
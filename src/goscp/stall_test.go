@@ -0,0 +1,50 @@
+package goscp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchStall(t *testing.T) {
+	// Stalled
+	tr := (&Client{}).startTransfer(TransferOptions{})
+	tr.activity.touch()
+	tr.watchStall(20*time.Millisecond, make(chan struct{}))
+
+	if len(tr.errors) != 1 || !errors.Is(tr.errors[0], ErrStalled) {
+		expectedError(t, tr.errors, ErrStalled)
+	}
+	if !tr.isCancelled() {
+		expectedError(t, "running", "cancelled")
+	}
+
+	// Shorter than the ticks it's checked in
+	tr = (&Client{}).startTransfer(TransferOptions{})
+	tr.activity.touch()
+	tr.watchStall(3*time.Nanosecond, make(chan struct{}))
+
+	if len(tr.errors) != 1 || !errors.Is(tr.errors[0], ErrStalled) {
+		expectedError(t, tr.errors, ErrStalled)
+	}
+
+	// Data keeps moving until the transfer ends
+	tr = (&Client{}).startTransfer(TransferOptions{})
+	tr.activity.touch()
+
+	stop := make(chan struct{})
+	go func() {
+		r := &activityReader{r: strings.NewReader(strings.Repeat("x", 10)), a: &tr.activity}
+		for i := 0; i < 10; i++ {
+			time.Sleep(5 * time.Millisecond)
+			r.Read(make([]byte, 1))
+		}
+		close(stop)
+	}()
+	tr.watchStall(20*time.Millisecond, stop)
+
+	if len(tr.errors) != 0 || tr.isCancelled() {
+		expectedError(t, tr.errors, "no errors")
+	}
+}
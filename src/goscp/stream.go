@@ -0,0 +1,185 @@
+package goscp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// DownloadTo streams the remote file remotePath into w, without creating a
+// local file. Directories and further files from the remote are refused.
+func (c *Client) DownloadTo(ctx context.Context, remotePath string, w io.Writer) error {
+	return c.StartDownloadTo(ctx, remotePath, w, c.TransferOptions).Wait()
+}
+
+// StartDownloadTo starts streaming remotePath into w with opts in the
+// background and returns a handle to supervise it. The remote scp runs
+// without -r.
+func (c *Client) StartDownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) *Transfer {
+	opts.NonRecursive = true
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.stream = &streamTarget{w: w}
+		t.download(ctx, []string{remotePath})
+	})
+}
+
+// Cat streams the remote file remotePath into w, e.g. os.Stdout to view a
+// remote log. Progress is shown as usual with ShowProgressBar or
+// PlainProgress, on os.Stderr unless ProgressOutputs is set, so it doesn't
+// mix with the content.
+func (c *Client) Cat(remotePath string, w io.Writer) error {
+	return c.CatContext(context.Background(), remotePath, w)
+}
+
+// CatContext works like Cat, closing the session and returning ctx.Err() if
+// ctx is done before it completes.
+func (c *Client) CatContext(ctx context.Context, remotePath string, w io.Writer) error {
+	return c.StartDownloadTo(ctx, remotePath, w, catOptions(c.TransferOptions)).Wait()
+}
+
+// Options of Cat, with progress kept apart from the content.
+func catOptions(opts TransferOptions) TransferOptions {
+	if len(opts.ProgressOutputs) == 0 {
+		opts.ProgressOutputs = []io.Writer{os.Stderr}
+	}
+	return opts
+}
+
+// DownloadBytes returns the content of the remote file remotePath, for
+// small files such as configs and keys.
+func (c *Client) DownloadBytes(remotePath string) ([]byte, error) {
+	return c.DownloadBytesContext(context.Background(), remotePath)
+}
+
+// DownloadBytesContext works like DownloadBytes, closing the session and
+// returning ctx.Err() if ctx is done before it completes.
+func (c *Client) DownloadBytesContext(ctx context.Context, remotePath string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := c.DownloadTo(ctx, remotePath, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UploadFrom uploads size bytes of r as the file name in
+// c.DestinationPath, without a local file. mode defaults to 0644 when zero.
+// A reader ending early is padded with zeros and reported as a
+// *SizeChangedError. r isn't closed.
+func (c *Client) UploadFrom(ctx context.Context, r io.Reader, name string, size int64, mode os.FileMode) error {
+	return c.StartUploadFrom(ctx, r, name, size, mode, c.TransferOptions).Wait()
+}
+
+// StartUploadFrom starts uploading r with opts in the background and
+// returns a handle to supervise it.
+func (c *Client) StartUploadFrom(ctx context.Context, r io.Reader, name string, size int64, mode os.FileMode, opts TransferOptions) *Transfer {
+	item := readerItem{r: r, name: name, size: size, mode: mode}
+	return c.StartUploadItems(ctx, []Uploadable{item}, opts)
+}
+
+// UploadBytes uploads data as the file remoteName in c.DestinationPath, for
+// small generated files such as configs and certificates. mode defaults to
+// 0644 when zero.
+func (c *Client) UploadBytes(data []byte, remoteName string, mode os.FileMode) error {
+	return c.UploadBytesContext(context.Background(), data, remoteName, mode)
+}
+
+// UploadBytesContext works like UploadBytes, closing the session and
+// returning ctx.Err() if ctx is done before it completes.
+func (c *Client) UploadBytesContext(ctx context.Context, data []byte, remoteName string, mode os.FileMode) error {
+	return c.UploadFrom(ctx, bytes.NewReader(data), remoteName, int64(len(data)), mode)
+}
+
+// UploadStream uploads everything read from r as the file name in
+// c.DestinationPath, e.g. the output of pg_dump. scp needs the size before
+// the content, so r is spooled to a temporary file first, which is removed
+// afterwards. mode defaults to 0644 when zero.
+func (c *Client) UploadStream(ctx context.Context, r io.Reader, name string, mode os.FileMode) error {
+	f, size, err := spool(r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	return c.UploadFrom(ctx, f, name, size, mode)
+}
+
+// Copy r to a temporary file, returned with its size and rewound.
+func spool(r io.Reader) (*os.File, int64, error) {
+	f, err := ioutil.TempFile("", "goscp-spool")
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not create spool file: %w", err)
+	}
+
+	size, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, fmt.Errorf("Could not spool [%q]: %w", f.Name(), err)
+	}
+
+	return f, size, nil
+}
+
+// Uploadable for the reader of UploadFrom.
+type readerItem struct {
+	r    io.Reader
+	name string
+	size int64
+	mode os.FileMode
+}
+
+func (i readerItem) Name() string {
+	return i.name
+}
+
+func (i readerItem) Size() int64 {
+	return i.size
+}
+
+func (i readerItem) Mode() os.FileMode {
+	return i.mode
+}
+
+func (i readerItem) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(i.r), nil
+}
+
+// Downloadable for the writer of DownloadTo, which stays open.
+type streamTarget struct {
+	w io.Writer
+
+	// Set once a file was announced
+	used bool
+}
+
+func (s *streamTarget) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *streamTarget) Close() error {
+	return nil
+}
+
+// Refuse anything but a single file when downloading to a writer.
+func (t *transfer) checkStream(m message) error {
+	if t.stream == nil {
+		return nil
+	}
+
+	if m.Type == 'D' {
+		return refusedErrorf("Refusing directory when downloading to a writer: [%q]", m.Name)
+	}
+	if t.stream.used {
+		return refusedErrorf("Refusing more than one file when downloading to a writer: [%q]", m.Name)
+	}
+
+	t.stream.used = true
+	return nil
+}
@@ -0,0 +1,152 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDownloadToWriter(t *testing.T) {
+	tests := []struct {
+		Input         string
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Input:    "C0644 11 hosts\n127.0.0.1 a\x00",
+			Expected: "127.0.0.1 a",
+		},
+		{
+			Input:         "D0755 0 hosts\nC0644 1 a\na\x00E\n",
+			ExpectedError: `Refusing directory when downloading to a writer: ["hosts"]`,
+		},
+		{
+			Input:         "C0644 1 hosts\na\x00C0644 1 hosts\nb\x00",
+			Expected:      "a",
+			ExpectedError: `Refusing more than one file when downloading to a writer: ["hosts"]`,
+		},
+	}
+
+	for _, v := range tests {
+		tmp, err := ioutil.TempDir("", "goscp-stream")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		h := sha256.New()
+		c := &transfer{
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(v.Input))},
+			stream:        &streamTarget{w: h},
+		}
+		c.path = []string{tmp}
+		c.startSecurityCheck("/etc/hosts")
+		c.handleDownload()
+
+		expectedSum := fmt.Sprintf("%x", sha256.Sum256([]byte(v.Expected)))
+		if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != expectedSum {
+			expectedError(t, sum, expectedSum)
+		}
+
+		if v.ExpectedError == "" && len(c.errors) != 0 {
+			t.Error("Unexpected errors:", c.errors)
+		}
+		if v.ExpectedError != "" {
+			if len(c.errors) != 1 || c.errors[0].Error() != v.ExpectedError || !errors.Is(c.errors[0], ErrRefused) {
+				expectedError(t, c.errors, v.ExpectedError)
+			}
+		}
+
+		// Nothing is written locally
+		if entries, _ := ioutil.ReadDir(tmp); len(entries) != 0 {
+			expectedError(t, len(entries), 0)
+		}
+	}
+}
+
+func TestUploadFromReader(t *testing.T) {
+	tests := []struct {
+		Item           readerItem
+		ExpectedOutput string
+		ExpectedSize   int64
+	}{
+		{
+			Item:           readerItem{r: strings.NewReader("id,total\n"), name: "report.csv", size: 9},
+			ExpectedOutput: "C0644 9 report.csv\nid,total\n\x00",
+		},
+		{
+			Item:           readerItem{r: strings.NewReader("dump"), name: "db.sql", size: 4, mode: 0600},
+			ExpectedOutput: "C0600 4 db.sql\ndump\x00",
+		},
+		{
+			// The reader ends before size, the rest is padded
+			Item:           readerItem{r: strings.NewReader("ab"), name: "short", size: 4},
+			ExpectedOutput: "C0644 4 short\nab\x00\x00\x00",
+			ExpectedSize:   2,
+		},
+	}
+
+	for _, v := range tests {
+		out := &bytes.Buffer{}
+		c := &transfer{scpStdinPipe: nopWriteCloser{out}, scpStdoutPipe: acceptingRemote()}
+
+		if err := c.sendItems([]Uploadable{v.Item}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if out.String() != v.ExpectedOutput {
+			expectedError(t, out.String(), v.ExpectedOutput)
+		}
+
+		var sizeErr *SizeChangedError
+		if v.ExpectedSize > 0 && (len(c.errors) != 1 || !errors.As(c.errors[0], &sizeErr) || sizeErr.CurrentSize != v.ExpectedSize) {
+			expectedError(t, c.errors, v.ExpectedSize)
+		}
+		if v.ExpectedSize == 0 && len(c.errors) != 0 {
+			t.Error("Unexpected errors:", c.errors)
+		}
+	}
+}
+
+func TestCatOptions(t *testing.T) {
+	opts := catOptions(TransferOptions{ShowProgressBar: true})
+	if len(opts.ProgressOutputs) != 1 || opts.ProgressOutputs[0] != os.Stderr {
+		expectedError(t, opts.ProgressOutputs, os.Stderr)
+	}
+
+	// Explicit outputs are kept
+	buf := &bytes.Buffer{}
+	opts = catOptions(TransferOptions{ProgressOutputs: []io.Writer{buf}})
+	if len(opts.ProgressOutputs) != 1 || opts.ProgressOutputs[0] != buf {
+		expectedError(t, opts.ProgressOutputs, buf)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	f, size, err := spool(strings.NewReader("pg_dump output"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if size != 14 {
+		expectedError(t, size, 14)
+	}
+	if content, _ := ioutil.ReadAll(f); string(content) != "pg_dump output" {
+		expectedError(t, string(content), "pg_dump output")
+	}
+
+	// Read errors are returned
+	if _, _, err := spool(iotest.ErrReader(io.ErrClosedPipe)); !errors.Is(err, io.ErrClosedPipe) {
+		expectedError(t, err, io.ErrClosedPipe)
+	}
+}
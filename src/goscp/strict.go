@@ -0,0 +1,58 @@
+package goscp
+
+import (
+	"strings"
+)
+
+// Check a raw message line from the remote with the StrictProtocol option.
+// Without it, stray NUL bytes and whitespace around messages are ignored,
+// as are shell locale warnings.
+func (t *transfer) checkStrictMessage(raw string) error {
+	if !t.opts.StrictProtocol {
+		return nil
+	}
+
+	if !strings.HasSuffix(raw, "\n") {
+		return protocolErrorf("Truncated message: [%q]", raw)
+	}
+
+	line := raw[:len(raw)-1]
+	if line == "" || strings.TrimSpace(line) != line || strings.IndexByte(line, 0) >= 0 {
+		return protocolErrorf("Malformed message: [%q]", raw)
+	}
+
+	switch line[0] {
+	case 'C', 'D', 'T', '\x01', '\x02':
+	case 'E':
+		if line != endDir {
+			return protocolErrorf("Malformed message: [%q]", raw)
+		}
+		if len(t.dirStack) == 0 {
+			return protocolErrorf("End of directory outside a directory: [%q]", raw)
+		}
+	default:
+		return protocolErrorf("Unexpected message: [%q]", raw)
+	}
+
+	if t.times != nil && line[0] != 'C' && line[0] != 'D' {
+		return protocolErrorf("Times not followed by a file or directory: [%q]", raw)
+	}
+
+	return nil
+}
+
+// Check how the remote ended a download with the StrictProtocol option. It
+// must not stop within a message or a directory.
+func (t *transfer) checkStrictEnd(partial string) error {
+	if !t.opts.StrictProtocol {
+		return nil
+	}
+
+	if partial != "" {
+		return protocolErrorf("Truncated message: [%q]", partial)
+	}
+	if len(t.dirStack) > 0 || t.times != nil {
+		return protocolErrorf("Transfer ended before the end of [%q]", t.downloadRelPath())
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStrictProtocol(t *testing.T) {
+	tests := []struct {
+		Input         string
+		ExpectedError string
+	}{
+		{
+			// Well formed
+			Input: "T1 0 2 0\nD0755 0 dir\nC0644 2 a.txt\nhi\x00E\n",
+		},
+		{
+			Input:         "\x00C0644 2 a.txt\nhi\x00",
+			ExpectedError: `Malformed message: ["\x00C0644 2 a.txt\n"]`,
+		},
+		{
+			Input:         "C0644 2 a.txt \nhi\x00",
+			ExpectedError: `Malformed message: ["C0644 2 a.txt \n"]`,
+		},
+		{
+			Input:         "perl: warning: Setting locale failed.\nC0644 2 a.txt\nhi\x00",
+			ExpectedError: `Unexpected message: ["perl: warning: Setting locale failed.\n"]`,
+		},
+		{
+			Input:         "E\n",
+			ExpectedError: `End of directory outside a directory: ["E\n"]`,
+		},
+		{
+			Input:         "T1 0 2 0\nE\n",
+			ExpectedError: `End of directory outside a directory: ["E\n"]`,
+		},
+		{
+			Input:         "D0755 0 dir\nT1 0 2 0\nE\n",
+			ExpectedError: `Times not followed by a file or directory: ["E\n"]`,
+		},
+		{
+			Input:         "D0755 0 dir\nC0644 2 a.txt\nhi\x00",
+			ExpectedError: `Transfer ended before the end of ["dir"]`,
+		},
+		{
+			Input:         "C0644 2 a.txt\nhi\x00C0644",
+			ExpectedError: `Truncated message: ["C0644"]`,
+		},
+		{
+			Input:         "C0644 2 a.txt\nhi\x01scp: a.txt: read error",
+			ExpectedError: `Truncated response: ["\x01scp: a.txt: read error"]`,
+		},
+	}
+
+	for _, v := range tests {
+		tmp, err := ioutil.TempDir("", "goscp-strict")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		c := &transfer{
+			opts:          TransferOptions{StrictProtocol: true, PreserveTimes: true},
+			scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(v.Input))},
+		}
+		c.path = []string{tmp}
+		c.rootDepth = 1
+		c.handleDownload()
+
+		err = nil
+		if len(c.errors) > 0 {
+			err = c.errors[0]
+		}
+		if v.ExpectedError == "" {
+			if err != nil {
+				t.Error("Unexpected error:", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), v.ExpectedError) {
+			expectedError(t, err, v.ExpectedError)
+		}
+		if !errors.Is(err, ErrProtocol) {
+			expectedError(t, err, ErrProtocol)
+		}
+	}
+}
+
+func TestLenientProtocol(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-lenient")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// What strict mode refuses is accepted by default
+	input := "perl: warning: Setting locale failed.\n\x00C0644 2 a.txt \nhi\x00"
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{tmp}
+	c.rootDepth = 1
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Error("Unexpected errors:", c.errors)
+	}
+}
@@ -0,0 +1,80 @@
+package goscp
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Prompt sudo prints when it needs the password
+	sudoPrompt = "goscp sudo password: "
+
+	// Line printed to stderr once sudo has started the remote command
+	sudoReadyLine = "goscp-sudo-ready"
+)
+
+// Wrap a remote command line in sudo with the Sudo option. sudo reads the
+// password from stdin; the command announces on stderr when it starts, so
+// the protocol only begins once sudo is done with stdin.
+func (t *transfer) sudo(cmd string) string {
+	if !t.opts.Sudo {
+		return cmd
+	}
+
+	script := fmt.Sprintf("echo %s >&2 && exec %s", sudoReadyLine, cmd)
+	return fmt.Sprintf("sudo -S -p %s sh -c %s", QuotePOSIX(sudoPrompt), QuotePOSIX(script))
+}
+
+// Wait until sudo has started the remote scp, so that nothing meant for scp
+// is read by sudo as a password. Returns false if the session ended or the
+// transfer was cancelled first.
+func (t *transfer) waitSudo(ended <-chan struct{}) bool {
+	if !t.opts.Sudo {
+		return true
+	}
+
+	select {
+	case <-t.sudoReady:
+		return true
+	case <-ended:
+	case <-t.cancelled:
+	}
+	return false
+}
+
+// Check a stderr line for the start of the command run by sudo.
+func (t *transfer) checkSudoReady(line string) bool {
+	if !t.opts.Sudo || line != sudoReadyLine {
+		return false
+	}
+
+	// Only the stderr watcher closes it
+	select {
+	case <-t.sudoReady:
+	default:
+		close(t.sudoReady)
+	}
+	return true
+}
+
+// Answer sudo's password prompt with the SudoPassword option. handled is
+// false for other prompts.
+func (t *transfer) answerSudo(prompt string) (handled bool, err error) {
+	if !t.opts.Sudo || t.opts.SudoPassword == nil || prompt != strings.TrimSpace(sudoPrompt) {
+		return false, nil
+	}
+
+	// sudo asks again after a wrong password
+	t.sudoAttempts++
+	if t.sudoAttempts > 1 {
+		return true, ErrSudoRejected
+	}
+
+	password, err := t.opts.SudoPassword()
+	if err != nil {
+		return true, err
+	}
+
+	_, err = fmt.Fprintln(t.scpStdinPipe, password)
+	return true, err
+}
@@ -0,0 +1,99 @@
+package goscp
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DownloadTar downloads remotePath as a tar archive written to w, without
+// touching the local filesystem. Entries are named by their path relative
+// to the transfer root, e.g. "images/logo.png", and get times from the
+// remote with PreserveTimes, the time they arrived otherwise. The archive is
+// only finished when the download succeeds. Files skipped with
+// Transfer.SkipCurrent keep their entry, filled up with zeros.
+func (c *Client) DownloadTar(ctx context.Context, remotePath string, w io.Writer) error {
+	return c.StartDownloadTar(ctx, remotePath, w, c.TransferOptions).Wait()
+}
+
+// StartDownloadTar starts downloading remotePath as a tar archive into w
+// with opts in the background and returns a handle to supervise it.
+func (c *Client) StartDownloadTar(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) *Transfer {
+	return c.start(ctx, opts, func(ctx context.Context, t *transfer) {
+		t.tar = &tarTarget{tw: tar.NewWriter(w)}
+		t.download(ctx, []string{remotePath})
+
+		if t.contextError(ctx) == nil {
+			if err := t.tar.tw.Close(); err != nil {
+				t.addError(fmt.Errorf("Could not finish archive: %w", err))
+			}
+		}
+	})
+}
+
+// Downloadable writing files into a tar archive. Each file's header is
+// written by beginTarFile before its content.
+type tarTarget struct {
+	tw *tar.Writer
+}
+
+func (a *tarTarget) Write(p []byte) (int, error) {
+	return a.tw.Write(p)
+}
+
+func (a *tarTarget) Close() error {
+	return nil
+}
+
+// Write the header of a directory.
+func (a *tarTarget) writeDir(relPath string, mode os.FileMode, times *fileTimes) error {
+	return a.writeHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     relPath + "/",
+		Mode:     int64(unixFromFileMode(mode)),
+	}, times)
+}
+
+func (a *tarTarget) writeHeader(h *tar.Header, times *fileTimes) error {
+	h.ModTime = time.Now()
+	if times != nil {
+		h.ModTime = times.mtime
+		h.AccessTime = times.atime
+	}
+
+	if err := a.tw.WriteHeader(h); err != nil {
+		return fmt.Errorf("Could not archive [%q]: %w", h.Name, err)
+	}
+	return nil
+}
+
+// Write the header of an incoming file going to the archive.
+func (t *transfer) beginTarFile(h *FileHeader, times *fileTimes) error {
+	if t.tar == nil || h.Target != t.tar {
+		return nil
+	}
+
+	return t.tar.writeHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     h.RelPath,
+		Mode:     int64(unixFromFileMode(h.Mode)),
+		Size:     h.Size,
+	}, times)
+}
+
+// Fill up the entry of a file skipped with remaining bytes left, as its
+// header already announced the full size.
+func (t *transfer) padTarFile(h *FileHeader, remaining int64) error {
+	if t.tar == nil || h.Target != t.tar || remaining <= 0 {
+		return nil
+	}
+
+	if _, err := io.CopyN(t.tar.tw, zeroReader{}, remaining); err != nil {
+		return fmt.Errorf("Could not archive [%q]: %w", h.RelPath, err)
+	}
+	t.addWarning("", fmt.Sprintf("Archived [%q] with %d zero bytes after skipping it", h.RelPath, remaining))
+	return nil
+}
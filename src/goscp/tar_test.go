@@ -0,0 +1,147 @@
+package goscp
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadTar(t *testing.T) {
+	input := "T1700000000 0 1700000000 0\nD0750 0 media\nC0600 3 a.txt\nabc\x00" +
+		"D0755 0 img\nT1600000000 0 1600000000 0\nC0644 0 empty.png\n\x00E\nE\n"
+
+	buf := &bytes.Buffer{}
+	c := &transfer{
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+		tar:           &tarTarget{tw: tar.NewWriter(buf)},
+	}
+	c.path = []string{"goscp-tar"}
+	c.startSecurityCheck("/srv/media")
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if err := c.tar.tw.Close(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	type entry struct {
+		Name    string
+		Mode    int64
+		Content string
+		Mtime   int64
+	}
+	var entries []entry
+	r := tar.NewReader(buf)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		content, _ := ioutil.ReadAll(r)
+		e := entry{Name: h.Name, Mode: h.Mode, Content: string(content)}
+		if h.ModTime.Before(time.Unix(1700000001, 0)) {
+			e.Mtime = h.ModTime.Unix()
+		}
+		entries = append(entries, e)
+	}
+
+	// Items without times get the time they arrived
+	expected := []entry{
+		{Name: "media/", Mode: 0750, Mtime: 1700000000},
+		{Name: "media/a.txt", Mode: 0600, Content: "abc"},
+		{Name: "media/img/", Mode: 0755},
+		{Name: "media/img/empty.png", Mode: 0644, Mtime: 1600000000},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		expectedError(t, entries, expected)
+	}
+
+	// Nothing is written locally
+	if _, err := os.Stat("goscp-tar"); !os.IsNotExist(err) {
+		expectedError(t, err, os.ErrNotExist)
+	}
+}
+
+// Reader requesting a skip of relPath once after bytes have been read.
+type skipAfterReader struct {
+	r       io.Reader
+	t       *transfer
+	relPath string
+	after   int
+	read    int
+}
+
+func (r *skipAfterReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		// One byte at a time, so the skip lands mid-file
+		p = p[:1]
+	}
+	n, err := r.r.Read(p)
+	r.read += n
+	if r.read == r.after {
+		r.t.mu.Lock()
+		r.t.skipPath = r.relPath
+		r.t.mu.Unlock()
+	}
+	return n, err
+}
+
+func TestDownloadTarSkip(t *testing.T) {
+	input := "D0755 0 media\nC0644 6 a.txt\nabcdef\x00C0644 2 b.txt\nbb\x00E\n"
+
+	buf := &bytes.Buffer{}
+	c := &transfer{
+		scpStdinPipe: nopWriteCloser{&bytes.Buffer{}},
+		tar:          &tarTarget{tw: tar.NewWriter(buf)},
+	}
+	// Skipped after "ab"
+	r := &skipAfterReader{r: strings.NewReader(input), t: c, relPath: "media/a.txt", after: len("D0755 0 media\nC0644 6 a.txt\nab")}
+	c.scpStdoutPipe = &readCanceller{Reader: bufio.NewReaderSize(r, 16)}
+	c.path = []string{"goscp-tar"}
+	c.startSecurityCheck("/srv/media")
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+	if err := c.tar.tw.Close(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(c.results) != 2 || c.results[0].Err != ErrSkipFile {
+		expectedError(t, c.results, "a.txt skipped")
+	}
+
+	// The skipped entry keeps its size, the archive stays readable
+	contents := map[string]string{}
+	tr := tar.NewReader(buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		contents[h.Name] = string(content)
+	}
+
+	expected := map[string]string{"media/": "", "media/a.txt": "ab\x00\x00\x00\x00", "media/b.txt": "bb"}
+	if !reflect.DeepEqual(contents, expected) {
+		expectedError(t, contents, expected)
+	}
+}
@@ -0,0 +1,65 @@
+package goscp
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Times of the next file or directory, from a T message.
+type fileTimes struct {
+	mtime time.Time
+	atime time.Time
+}
+
+// Send a timestamp message with the times of info and wait for the remote
+// to accept it.
+func (t *transfer) sendTimes(path string, info os.FileInfo) error {
+	msg := fmt.Sprintf("T%d 0 %d 0", info.ModTime().Unix(), fileAtime(info).Unix())
+	fmt.Fprintln(t.scpStdinPipe, msg)
+	t.outputInfo(fmt.Sprintf("Sent: %s", msg))
+	t.trace(traceSent, fmt.Sprintf("%q", msg))
+
+	return t.expectAck(pendingAck{action: fmt.Sprintf("set times of [%q]", path)})
+}
+
+// Handle a timestamp message, kept for the next file or directory.
+func (t *transfer) timestamp(msg string) error {
+	m, err := t.parseMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	t.times = &fileTimes{mtime: time.Unix(m.Mtime, 0), atime: time.Unix(m.Atime, 0)}
+	t.sendAck(t.scpStdinPipe)
+
+	return nil
+}
+
+// Return and clear the times sent for the current item.
+func (t *transfer) takeTimes() *fileTimes {
+	times := t.times
+	t.times = nil
+
+	return times
+}
+
+// Apply received times to a local file or directory with the PreserveTimes
+// option. Failures are warnings, the content is there.
+func (t *transfer) applyTimes(relPath, localPath string, times *fileTimes) {
+	if times == nil || !t.opts.PreserveTimes {
+		return
+	}
+
+	if err := t.writeFS().Chtimes(localPath, times.atime, times.mtime); err != nil {
+		t.addWarning(relPath, fmt.Sprintf("Could not set times: %s", err))
+	}
+}
+
+// Add the flag that makes the remote scp send and apply times.
+func (t *transfer) preserveFlags(cmd Command) Command {
+	if t.opts.PreserveTimes {
+		cmd.Flags = append([]string{FlagPreserve}, cmd.Flags...)
+	}
+	return cmd
+}
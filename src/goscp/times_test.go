@@ -0,0 +1,104 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreserveTimesDownload(t *testing.T) {
+	for _, preserve := range []bool{true, false} {
+		tmp, err := ioutil.TempDir("", "goscp-times")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		out := &bytes.Buffer{}
+		input := "T1000000000 0 1000000100 0\nD0755 0 dir\n" +
+			"T1500000000 0 1500000100 0\nC0644 2 a.txt\nhi\x00" +
+			"C0644 2 b.txt\nbb\x00E\n"
+		c := &transfer{
+			opts:          TransferOptions{PreserveTimes: preserve},
+			scpStdinPipe:  nopWriteCloser{out},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+		}
+		c.path = []string{tmp}
+		c.rootDepth = 1
+		c.handleDownload()
+
+		if len(c.errors) != 0 {
+			t.Fatal("Unexpected errors:", c.errors)
+		}
+
+		// Timestamp messages are acknowledged like the others
+		if out.String() != strings.Repeat("\x00", 9) {
+			expectedError(t, out.String(), strings.Repeat("\x00", 9))
+		}
+
+		tests := []struct {
+			Path  string
+			Mtime int64
+		}{
+			{Path: "dir", Mtime: 1000000000},
+			{Path: "dir/a.txt", Mtime: 1500000000},
+		}
+		for _, v := range tests {
+			info, err := os.Stat(filepath.Join(tmp, v.Path))
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if (info.ModTime().Unix() == v.Mtime) != preserve {
+				expectedError(t, info.ModTime().Unix(), v.Mtime)
+			}
+		}
+
+		// Times only apply to the file that follows them
+		if info, _ := os.Stat(filepath.Join(tmp, "dir", "b.txt")); info.ModTime().Unix() == 1500000000 {
+			expectedError(t, info.ModTime().Unix(), "current time")
+		}
+	}
+}
+
+func TestPreserveTimesUpload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "goscp-times")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	filePath := filepath.Join(tmp, "a.txt")
+	ioutil.WriteFile(filePath, []byte("abc"), 0644)
+	os.Chtimes(filePath, time.Unix(1500000100, 0), time.Unix(1500000000, 0))
+	os.Chtimes(tmp, time.Unix(1000000100, 0), time.Unix(1000000000, 0))
+
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts:          TransferOptions{PreserveTimes: true},
+		scpStdinPipe:  nopWriteCloser{out},
+		scpStdoutPipe: acceptingRemote(),
+	}
+	if err := c.sendTree(tmp); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "T1000000000 0 ") || !strings.HasPrefix(lines[2], "T1500000000 0 ") {
+		expectedError(t, lines, "times before the D and C messages")
+	}
+
+	// Access times are sent where they are known
+	if lines[2] != "T1500000000 0 1500000100 0" && lines[2] != "T1500000000 0 1500000000 0" {
+		expectedError(t, lines[2], "T1500000000 0 1500000100 0")
+	}
+
+	cmd := c.preserveFlags(SinkCommand("/srv")).String()
+	if cmd != "scp -p -r -t /srv" {
+		expectedError(t, cmd, "scp -p -r -t /srv")
+	}
+}
@@ -0,0 +1,52 @@
+package goscp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Directions of protocol traffic in trace output.
+const (
+	traceSent     = "->"
+	traceReceived = "<-"
+)
+
+// Log a protocol message or acknowledgement when the Trace option is set,
+// with the time elapsed since the previous one.
+func (t *transfer) trace(direction, msg string) {
+	if !t.opts.Trace {
+		return
+	}
+
+	now := time.Now()
+	if t.traceLast.IsZero() {
+		t.traceLast = now
+	}
+	line := fmt.Sprintf("scp %s %s (+%s)", direction, msg, now.Sub(t.traceLast))
+	t.traceLast = now
+
+	if t.opts.TraceLogger != nil {
+		t.opts.TraceLogger.Println(line)
+	} else {
+		log.Println(line)
+	}
+}
+
+// Trace a raw message received from the remote side. Acknowledgements
+// left over from the previous step arrive as leading NUL bytes.
+func (t *transfer) traceReceived(raw string) {
+	if !t.opts.Trace {
+		return
+	}
+
+	for strings.HasPrefix(raw, "\x00") {
+		t.trace(traceReceived, "ack")
+		raw = raw[1:]
+	}
+
+	if msg := strings.TrimSpace(raw); msg != "" {
+		t.trace(traceReceived, fmt.Sprintf("%q", msg))
+	}
+}
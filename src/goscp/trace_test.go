@@ -0,0 +1,56 @@
+package goscp
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	logs := &bytes.Buffer{}
+	out := &bytes.Buffer{}
+	c := &transfer{
+		opts: TransferOptions{
+			Trace:       true,
+			TraceLogger: log.New(logs, "", 0),
+		},
+		scpStdinPipe: nopWriteCloser{out},
+	}
+
+	c.sendFileMessage(c.scpStdinPipe, 0644, 5, "file.txt")
+	c.scpStdinPipe.Write([]byte("hello"))
+	c.sendAck(c.scpStdinPipe)
+	c.traceReceived("\x00\x00D0755 0 dir\n")
+	c.sendEndOfDirectoryMessage(c.scpStdinPipe)
+
+	expected := []string{
+		`scp -> "C0644 5 file.txt"`,
+		`scp -> ack`,
+		`scp <- ack`,
+		`scp <- ack`,
+		`scp <- "D0755 0 dir"`,
+		`scp -> "E"`,
+	}
+
+	timing := regexp.MustCompile(` \(\+[0-9.]+[a-zµ]+\)$`)
+	lines := strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		expectedError(t, lines, expected)
+		return
+	}
+	for i, line := range lines {
+		if !timing.MatchString(line) {
+			expectedError(t, line, expected[i]+" (+...)")
+		}
+		if line = timing.ReplaceAllString(line, ""); line != expected[i] {
+			expectedError(t, line, expected[i])
+		}
+	}
+
+	// File content is never traced
+	if strings.Contains(logs.String(), "hello") {
+		expectedError(t, logs.String(), "no file content")
+	}
+}
@@ -0,0 +1,428 @@
+package goscp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// State of a single download or upload.
+type transfer struct {
+	c *Client
+
+	// Options the transfer was started with
+	opts TransferOptions
+
+	// Capabilities of the remote scp with the ProbeRemote option
+	caps *Capabilities
+
+	// Guards errors, warnings and results, which are read while the
+	// transfer runs, the phase and the session
+	mu sync.Mutex
+
+	// Errors and warnings of this transfer, also added to the Client's
+	// own lists
+	errors   []error
+	warnings []Warning
+
+	// Outcome of each file
+	results []FileResult
+
+	// Serializes writes to the ProgressOutputs writers
+	progressMu sync.Mutex
+
+	// When the transfer started and finished
+	startedAt  time.Time
+	finishedAt time.Time
+
+	// Items left out other than failures
+	skipped int
+
+	// Last warning, error or stderr line from the remote scp
+	remoteText string
+
+	// Upload messages sent ahead of their acknowledgement
+	pendingAcks []pendingAck
+
+	// File to skip, set by SkipCurrent
+	skipPath string
+
+	// Times from the last timestamp message of a download
+	times *fileTimes
+
+	// Closed once sudo has started the remote scp, with the Sudo option
+	sudoReady    chan struct{}
+	sudoAttempts int
+
+	// Items skipped after an error, and the index of their errors
+	failures    []ItemFailure
+	failedIndex []int
+
+	// Where the transfer is, reported with errors to OnError
+	phase Phase
+	item  string
+
+	// Read by Transfer.Progress while the transfer runs
+	progress progressCounter
+
+	// Closed once the remote scp speaks the protocol
+	started   chan struct{}
+	startOnce sync.Once
+
+	// When data last moved, for StallTimeout
+	activity activity
+
+	// Closed by Cancel
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+
+	// Set while file content is only partly sent, when the remote can't be
+	// told about a cancellation
+	midContent bool
+
+	// Set when an abandoned read from the remote may still complete, when
+	// the session can't be drained
+	readAbandoned bool
+
+	// Directory path of the transfer, starting at the destination path for
+	// downloads and at the local path for uploads
+	path []string
+
+	// Time of the last traced message
+	traceLast time.Time
+
+	// Directories currently open
+	dirStack []dirFrame
+
+	// Local path being uploaded
+	uploadRoot string
+
+	// Local duplicates skipped during upload, mapped to their original
+	duplicates map[string]string
+
+	// Receives the single file of a download to a writer
+	stream *streamTarget
+
+	// Receives a download as a tar archive
+	tar *tarTarget
+
+	// Download security bookkeeping
+	rootDepth     int
+	expectedNames []string
+	entries       int
+	received      int64
+
+	// Session of the transfer, closed by Client.Close
+	session io.Closer
+
+	// Closed by endTransfer
+	done chan struct{}
+
+	// Stdin for SSH session
+	scpStdinPipe io.WriteCloser
+
+	// Stdout for SSH session
+	scpStdoutPipe *readCanceller
+}
+
+// Create a transfer with opts and track it for Cancel.
+func (c *Client) startTransfer(opts TransferOptions) *transfer {
+	t := &transfer{
+		c:         c,
+		opts:      opts,
+		phase:     PhaseStart,
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transfers == nil {
+		c.transfers = make(map[*transfer]struct{})
+	}
+	c.transfers[t] = struct{}{}
+
+	return t
+}
+
+// Stop tracking a finished transfer.
+func (c *Client) endTransfer(t *transfer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.transfers, t)
+	close(t.done)
+}
+
+// Check if Close has been called.
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// Open a session for the transfer, which Client.Close can close.
+func (t *transfer) openSession() (*ssh.Session, error) {
+	session, err := t.c.SSHClient.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Could not open session: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.session = session
+
+	return session, nil
+}
+
+// Close the transfer's session, if it has one.
+func (t *transfer) closeSession() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.session != nil {
+		t.session.Close()
+	}
+}
+
+// Record an error for the transfer and the Client.
+func (t *transfer) addError(err error) {
+	t.recordError(err, false)
+}
+
+// Record err, itemOnly when it only failed the current item.
+func (t *transfer) recordError(err error, itemOnly bool) {
+	t.mu.Lock()
+	if itemOnly {
+		t.failures = append(t.failures, ItemFailure{RelPath: t.item, Err: err})
+		t.failedIndex = append(t.failedIndex, len(t.errors))
+	}
+	t.errors = append(t.errors, err)
+	phase, item := t.phase, t.item
+	t.mu.Unlock()
+
+	if t.c != nil {
+		t.c.addError(err)
+	}
+
+	if t.opts.OnError != nil {
+		t.opts.OnError(item, phase, err)
+	}
+}
+
+// Build an error from a remote message, keeping its text for RemoteError.
+func (t *transfer) remoteMessage(msg string) *RemoteMessageError {
+	e := newRemoteMessageError(msg)
+	t.setRemoteText(e.Message)
+	return e
+}
+
+// Keep the last text sent by the remote scp.
+func (t *transfer) setRemoteText(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remoteText = text
+}
+
+// Track where the transfer is, item relative to the transfer root.
+func (t *transfer) setPhase(phase Phase, item string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.phase = phase
+	t.item = item
+}
+
+// Return ctx.Err() if it stopped the transfer, otherwise its first error.
+func (t *transfer) contextError(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		for _, err := range t.errors {
+			if err == ctxErr {
+				return err
+			}
+		}
+	}
+
+	if len(t.errors) > 0 {
+		return t.errors[0]
+	}
+	return nil
+}
+
+// Record that the remote scp has started speaking the protocol.
+func (t *transfer) markStarted() {
+	if t.started == nil {
+		return
+	}
+
+	t.startOnce.Do(func() {
+		close(t.started)
+	})
+}
+
+// Stop the transfer's next read from the remote.
+func (t *transfer) cancel() {
+	if t.cancelled == nil {
+		return
+	}
+
+	t.cancelOnce.Do(func() {
+		close(t.cancelled)
+	})
+}
+
+// Check if the transfer has been cancelled.
+func (t *transfer) isCancelled() bool {
+	select {
+	case <-t.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// Tell the remote scp that a cancelled transfer is over and read what it
+// still sends, so it can exit instead of waiting for the session to die.
+func (t *transfer) notifyCancel() {
+	if !t.isCancelled() {
+		return
+	}
+
+	if !t.midContent {
+		fmt.Fprintf(t.scpStdinPipe, "\x02scp: %s\n", ErrCancelled)
+		t.trace(traceSent, "error")
+	}
+	t.scpStdinPipe.Close()
+
+	if t.readAbandoned {
+		return
+	}
+
+	// Bypasses the cancellation check, the session is closed if the remote
+	// doesn't stop in time
+	io.Copy(ioutil.Discard, t.scpStdoutPipe.Reader)
+}
+
+// Transfer is a handle to a download or upload running in the background.
+type Transfer struct {
+	t      *transfer
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// Set before done is closed
+	err error
+}
+
+// Run a transfer with opts in the background.
+func (c *Client) start(ctx context.Context, opts TransferOptions, run func(context.Context, *transfer)) *Transfer {
+	ctx, cancel := context.WithCancel(ctx)
+	tr := &Transfer{
+		t:      c.startTransfer(opts),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(tr.done)
+		defer cancel()
+		defer c.endTransfer(tr.t)
+
+		tr.t.mu.Lock()
+		tr.t.startedAt = time.Now()
+		tr.t.mu.Unlock()
+
+		if opts.Timeout > 0 {
+			timer := time.AfterFunc(opts.Timeout, func() {
+				tr.t.addError(fmt.Errorf("%w: exceeded %s", ErrTimeout, opts.Timeout))
+				tr.t.cancel()
+			})
+			defer timer.Stop()
+		}
+
+		if err := ctx.Err(); err != nil {
+			tr.t.addError(err)
+		} else if c.isClosed() {
+			tr.t.addError(ErrClientClosed)
+		} else {
+			tr.t.probe(ctx)
+			run(ctx, tr.t)
+		}
+		tr.t.mu.Lock()
+		tr.t.finishedAt = time.Now()
+		tr.t.mu.Unlock()
+
+		if opts.SoftFail {
+			tr.err = tr.t.softFailError(ctx)
+		} else {
+			tr.err = tr.t.contextError(ctx)
+		}
+
+		if tr.err == nil && c.Stats != nil {
+			if host := c.statsHost(); host != "" {
+				c.Stats.Record(host, tr.Report())
+			}
+		}
+	}()
+
+	return tr
+}
+
+// Wait for the transfer to finish and return its first error.
+func (tr *Transfer) Wait() error {
+	<-tr.done
+	return tr.err
+}
+
+// Done returns a channel that is closed when the transfer finishes.
+func (tr *Transfer) Done() <-chan struct{} {
+	return tr.done
+}
+
+// Err returns the transfer's first error once it has finished, nil while it
+// is still running.
+func (tr *Transfer) Err() error {
+	select {
+	case <-tr.done:
+		return tr.err
+	default:
+		return nil
+	}
+}
+
+// Errors returns the errors of this transfer so far, in the order they
+// occurred.
+func (tr *Transfer) Errors() []error {
+	tr.t.mu.Lock()
+	defer tr.t.mu.Unlock()
+
+	return append([]error(nil), tr.t.errors...)
+}
+
+// Cancel the transfer, telling the remote scp to stop before the session is
+// closed. Wait returns context.Canceled.
+func (tr *Transfer) Cancel() {
+	tr.cancel()
+}
+
+// Progress returns how far the transfer has got.
+func (tr *Transfer) Progress() Progress {
+	return tr.t.progress.snapshot()
+}
@@ -0,0 +1,327 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelTransfers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscp-parallel")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bb"), 0644)
+
+	c := &Client{}
+	outputs := make([]*bytes.Buffer, 8)
+
+	var wg sync.WaitGroup
+	for i := range outputs {
+		outputs[i] = &bytes.Buffer{}
+
+		tr := c.startTransfer(TransferOptions{})
+		tr.scpStdinPipe = nopWriteCloser{outputs[i]}
+		tr.scpStdoutPipe = acceptingRemote()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.endTransfer(tr)
+
+			if err := tr.sendTree(dir); err != nil {
+				tr.addError(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errs := c.GetErrorStack(); len(errs) != 0 {
+		t.Fatal("Unexpected errors:", errs)
+	}
+
+	// Every transfer sends the same stream, none mixed with another
+	expected := "D0700 0 " + filepath.Base(dir) + "\nC0644 1 a.txt\na\x00D0755 0 sub\nC0644 2 b.txt\nbb\x00E\nE\n"
+	for _, out := range outputs {
+		if out.String() != expected {
+			expectedError(t, out.String(), expected)
+		}
+	}
+}
+
+func TestTransferHandle(t *testing.T) {
+	c := &Client{}
+
+	// Runs until cancelled
+	started := make(chan struct{})
+	tr := c.start(context.Background(), TransferOptions{}, func(ctx context.Context, t *transfer) {
+		close(started)
+		<-ctx.Done()
+		t.addError(ctx.Err())
+	})
+	<-started
+
+	if err := tr.Err(); err != nil {
+		expectedError(t, err, nil)
+	}
+
+	tr.Cancel()
+	if err := tr.Wait(); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+	<-tr.Done()
+	if err := tr.Err(); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+
+	// Not started when the context is already done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.StartDownload(ctx, "/remote", TransferOptions{}).Wait(); err != context.Canceled {
+		expectedError(t, err, context.Canceled)
+	}
+}
+
+func TestTransferTimeout(t *testing.T) {
+	c := &Client{}
+
+	// Runs until cancelled by the timeout
+	tr := c.start(context.Background(), TransferOptions{Timeout: 10 * time.Millisecond}, func(ctx context.Context, t *transfer) {
+		<-t.cancelled
+		t.addError(ErrCancelled)
+	})
+	if err := tr.Wait(); !errors.Is(err, ErrTimeout) {
+		expectedError(t, err, ErrTimeout)
+	}
+
+	// Finishes in time
+	tr = c.start(context.Background(), TransferOptions{Timeout: time.Minute}, func(ctx context.Context, t *transfer) {})
+	if err := tr.Wait(); err != nil {
+		expectedError(t, err, nil)
+	}
+}
+
+func TestTransferProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscp-progress")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("abc"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("de"), 0644)
+
+	c := &Client{}
+	tr := &Transfer{t: c.startTransfer(TransferOptions{})}
+	tr.t.scpStdinPipe = nopWriteCloser{&bytes.Buffer{}}
+	tr.t.scpStdoutPipe = acceptingRemote()
+	if err := tr.t.sendTree(dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := Progress{Files: 2, Dirs: 1, Bytes: 5}
+	if p := tr.Progress(); p != expected {
+		expectedError(t, p, expected)
+	}
+}
+
+// Records whether Close was called.
+type closeRecorder struct {
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestClientClose(t *testing.T) {
+	c := &Client{}
+
+	// Runs until its session is closed
+	session := &closeRecorder{closed: make(chan struct{})}
+	started := make(chan struct{})
+	tr := c.start(context.Background(), TransferOptions{}, func(ctx context.Context, t *transfer) {
+		t.mu.Lock()
+		t.session = session
+		t.mu.Unlock()
+		close(started)
+
+		<-session.closed
+		t.addError(ErrCancelled)
+	})
+	<-started
+
+	c.Close()
+
+	select {
+	case <-tr.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Transfer didn't end")
+	}
+	if err := tr.Err(); err != ErrCancelled {
+		expectedError(t, err, ErrCancelled)
+	}
+
+	// Closed clients don't start transfers
+	err := c.start(context.Background(), TransferOptions{}, func(ctx context.Context, t *transfer) {}).Wait()
+	if err != ErrClientClosed {
+		expectedError(t, err, ErrClientClosed)
+	}
+}
+
+func TestWatchStart(t *testing.T) {
+	c := &Client{}
+
+	// Remote never speaks
+	tr := c.startTransfer(TransferOptions{})
+	session := &closeRecorder{closed: make(chan struct{})}
+	tr.watchStart(session, 10*time.Millisecond, make(chan struct{}))
+	<-session.closed
+	if err := tr.contextError(context.Background()); !errors.Is(err, ErrStartTimeout) {
+		expectedError(t, err, ErrStartTimeout)
+	}
+
+	// Remote started in time
+	tr = c.startTransfer(TransferOptions{})
+	tr.markStarted()
+	tr.markStarted()
+	tr.watchStart(nil, time.Hour, make(chan struct{}))
+	if err := tr.contextError(context.Background()); err != nil {
+		expectedError(t, err, nil)
+	}
+}
+
+func TestReadAck(t *testing.T) {
+	tests := []struct {
+		Input          string
+		StrictProtocol bool
+		ExpectedError  string
+	}{
+		{
+			Input: "\x00",
+		},
+		{
+			Input:         "\x01scp: /data: No such file or directory\n",
+			ExpectedError: `Warning message: ["scp: /data: No such file or directory"]`,
+		},
+		{
+			Input:         "\x02scp: /data: Permission denied\n",
+			ExpectedError: `Error message: ["scp: /data: Permission denied"]`,
+		},
+		{
+			Input:         "Welcome to host\n",
+			ExpectedError: `Unexpected response: ["Welcome to host\n"]`,
+		},
+		{
+			// Printed by the remote shell before scp starts
+			Input: "perl: warning: Setting locale failed.\n\x00",
+		},
+		{
+			Input:          "perl: warning: Setting locale failed.\n\x00",
+			StrictProtocol: true,
+			ExpectedError:  `Unexpected response: ["perl: warning: Setting locale failed.\n"]`,
+		},
+	}
+
+	for _, v := range tests {
+		c := &transfer{
+			opts:          TransferOptions{StrictProtocol: v.StrictProtocol},
+			scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(v.Input))},
+		}
+		err := c.readAck()
+		if v.ExpectedError == "" {
+			if err != nil {
+				t.Error("Unexpected error:", err)
+			}
+		} else if err == nil || err.Error() != v.ExpectedError {
+			expectedError(t, err, v.ExpectedError)
+		}
+	}
+}
+
+func TestTransferResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscp-results")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("abc"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("de"), 0000)
+
+	c := &Client{}
+	tr := &Transfer{t: c.startTransfer(TransferOptions{})}
+	tr.t.scpStdinPipe = nopWriteCloser{&bytes.Buffer{}}
+	tr.t.scpStdoutPipe = acceptingRemote()
+	err = tr.t.sendTree(dir)
+
+	results := tr.Results()
+	if len(results) != 2 {
+		t.Fatal("Unexpected results:", results)
+	}
+
+	root := filepath.Base(dir)
+	if r := results[0]; r.RelPath != root+"/a.txt" || r.Size != 3 || r.Err != nil {
+		expectedError(t, r, FileResult{RelPath: root + "/a.txt", Size: 3})
+	}
+
+	// Unreadable, unless running as root
+	if r := results[1]; r.RelPath != root+"/b.txt" || r.Err != err {
+		expectedError(t, r, FileResult{RelPath: root + "/b.txt", Err: err})
+	}
+
+	// Download refused by the security policy
+	tr = &Transfer{t: c.startTransfer(TransferOptions{})}
+	tr.t.scpStdinPipe = nopWriteCloser{&bytes.Buffer{}}
+	tr.t.scpStdoutPipe = &readCanceller{Reader: bufio.NewReader(strings.NewReader("C0644 5 ../evil\n"))}
+	tr.t.handleDownload()
+
+	results = tr.Results()
+	if len(results) != 1 || results[0].RelPath != "../evil" || !errors.Is(results[0].Err, ErrRefused) {
+		expectedError(t, results, "../evil refused")
+	}
+}
+
+func TestMaxResults(t *testing.T) {
+	errFailed := errors.New("failed")
+	c := &transfer{opts: TransferOptions{MaxResults: 4}}
+	for i := 0; i < 10; i++ {
+		var err error
+		if i == 1 {
+			err = errFailed
+		}
+		c.addResult(strconv.Itoa(i), 1, 0, err)
+	}
+
+	// The failure outlives older successful files
+	var names []string
+	for _, r := range c.results {
+		names = append(names, r.RelPath)
+	}
+	if expected := "1 7 8 9"; strings.Join(names, " ") != expected {
+		expectedError(t, names, expected)
+	}
+
+	// Unlimited
+	c = &transfer{opts: TransferOptions{MaxResults: -1}}
+	for i := 0; i < DefaultMaxResults+1; i++ {
+		c.addResult("a", 1, 0, nil)
+	}
+	if len(c.results) != DefaultMaxResults+1 {
+		expectedError(t, len(c.results), DefaultMaxResults+1)
+	}
+}
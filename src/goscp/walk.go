@@ -0,0 +1,72 @@
+package goscp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Number of directory entries read at a time while walking.
+const walkBatchSize = 256
+
+// Walk the tree at root in depth-first pre-order like filepath.Walk, but
+// read directories in batches so that memory use doesn't grow with the
+// number of entries in a directory. Entries are sorted within each batch,
+// so directories smaller than a batch are walked in lexical order.
+func walkStream(root string, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = walkStreamItem(root, info, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkStreamItem(p string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if err := fn(p, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return fn(p, info, err)
+	}
+	defer f.Close()
+
+	for {
+		entries, readErr := f.ReadDir(walkBatchSize)
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+
+		for _, entry := range entries {
+			child := filepath.Join(p, entry.Name())
+
+			childInfo, err := entry.Info()
+			if err != nil {
+				err = fn(child, nil, err)
+			} else {
+				err = walkStreamItem(child, childInfo, fn)
+			}
+
+			if err == filepath.SkipDir {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fn(p, info, readErr)
+		}
+	}
+}
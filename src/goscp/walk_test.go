@@ -0,0 +1,191 @@
+package goscp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// Collect the paths visited by a walk function.
+func walkPaths(walk func(string, filepath.WalkFunc) error, root string) ([]string, error) {
+	var paths []string
+	err := walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	return paths, err
+}
+
+func TestWalkStream(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscp-walk")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"b/x", "b/y/z", "a", "c"} {
+		os.MkdirAll(filepath.Join(root, name), 0755)
+	}
+	for _, name := range []string{"b/x/1.txt", "b/2.txt", "c/3.txt", "4.txt"} {
+		ioutil.WriteFile(filepath.Join(root, name), nil, 0644)
+	}
+
+	// Same order as filepath.Walk for small directories
+	expected, _ := walkPaths(filepath.Walk, root)
+	output, err := walkPaths(walkStream, root)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !reflect.DeepEqual(output, expected) {
+		expectedError(t, output, expected)
+	}
+
+	// Missing root
+	_, err = walkPaths(walkStream, filepath.Join(root, "missing"))
+	if !os.IsNotExist(err) {
+		expectedError(t, err, "not exist")
+	}
+}
+
+func TestWalkStreamLargeDirectory(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscp-walk")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	count := walkBatchSize*2 + 7
+	for i := 0; i < count; i++ {
+		ioutil.WriteFile(filepath.Join(root, strconv.Itoa(i)), nil, 0644)
+	}
+
+	output, err := walkPaths(walkStream, root)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range output {
+		seen[p] = true
+	}
+	if len(output) != count+1 || len(seen) != count+1 {
+		expectedError(t, len(output), count+1)
+	}
+}
+
+func TestHandleItemSymlink(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscp-walk")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	target := filepath.Join(root, "target.txt")
+	ioutil.WriteFile(target, []byte("linked content"), 0644)
+	if err := os.Symlink(target, filepath.Join(root, "link.txt")); err != nil {
+		t.Skip("Symlinks not supported:", err)
+	}
+	os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "dangling"))
+
+	out := &bytes.Buffer{}
+	c := &transfer{scpStdinPipe: nopWriteCloser{out}, scpStdoutPipe: acceptingRemote()}
+	if err := c.sendTree(root); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// The link is sent with the target's size and content, the dangling
+	// link is skipped
+	expected := fmt.Sprintf("D0700 0 %s\n", filepath.Base(root)) +
+		"C0644 14 link.txt\nlinked content\x00" +
+		"C0644 14 target.txt\nlinked content\x00" +
+		"E\n"
+	if out.String() != expected {
+		expectedError(t, out.String(), expected)
+	}
+}
+
+// Counts bytes written without keeping them.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func (w *countingWriter) Close() error {
+	return nil
+}
+
+func TestUploadStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Stress test skipped in short mode")
+	}
+
+	// GOSCP_STRESS_FILES=1000000 for a full sized run
+	files := 20000
+	if n, err := strconv.Atoi(os.Getenv("GOSCP_STRESS_FILES")); err == nil {
+		files = n
+	}
+	perDir := 1000
+
+	root, err := ioutil.TempDir("", "goscp-stress")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(root)
+
+	for i := 0; i < files; i++ {
+		dir := filepath.Join(root, strconv.Itoa(i/perDir))
+		if i%perDir == 0 {
+			os.Mkdir(dir, 0755)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), nil, 0644); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+	peak := baseline
+
+	c := &transfer{scpStdinPipe: &countingWriter{}, scpStdoutPipe: acceptingRemote()}
+	sent := 0
+	c.opts.OnDirEnd = func(relPath string, dirStats DirStats) {
+		if relPath == filepath.Base(root) {
+			sent = dirStats.Files
+			return
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > peak {
+			peak = stats.HeapAlloc
+		}
+	}
+
+	if err := c.sendTree(root); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if sent != files {
+		expectedError(t, sent, files)
+	}
+
+	// Memory must not grow with the number of files sent
+	if growth := int64(peak) - int64(baseline); growth > 4<<20 {
+		t.Errorf("Heap grew by %d bytes uploading %d files", growth, files)
+	}
+}
@@ -0,0 +1,77 @@
+package goscp
+
+import (
+	"os"
+)
+
+// Warning is a non-fatal condition noted during a transfer, such as a
+// skipped special file. A transfer with only warnings still succeeds.
+type Warning struct {
+	// Item relative to the transfer root, empty if none
+	RelPath string
+
+	// What happened
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.RelPath == "" {
+		return w.Message
+	}
+	return w.RelPath + ": " + w.Message
+}
+
+// Describe the type of a file that isn't a regular file or directory.
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "dangling symlink"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	}
+	return "special file"
+}
+
+// Record a warning for the transfer and the Client.
+func (t *transfer) addWarning(relPath, message string) {
+	w := Warning{RelPath: relPath, Message: message}
+	t.outputInfo("Warning: " + w.String())
+
+	t.mu.Lock()
+	t.warnings = append(t.warnings, w)
+	t.mu.Unlock()
+
+	if t.c != nil {
+		t.c.mu.Lock()
+		t.c.warnings = append(t.c.warnings, w)
+		t.c.mu.Unlock()
+	}
+}
+
+// GetWarnings returns all warnings noted so far, from every transfer.
+func (c *Client) GetWarnings() []Warning {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Warning(nil), c.warnings...)
+}
+
+// ClearWarnings empties the Client's warnings.
+func (c *Client) ClearWarnings() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.warnings = nil
+}
+
+// Warnings returns the warnings of this transfer so far.
+func (tr *Transfer) Warnings() []Warning {
+	tr.t.mu.Lock()
+	defer tr.t.mu.Unlock()
+
+	return append([]Warning(nil), tr.t.warnings...)
+}
@@ -0,0 +1,52 @@
+package goscp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUploadWarnings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscp-warnings")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "dangling")); err != nil {
+		t.Skip("Symlinks not supported:", err)
+	}
+
+	c := &Client{}
+	tr := &Transfer{t: c.startTransfer(TransferOptions{})}
+	tr.t.scpStdinPipe = nopWriteCloser{&bytes.Buffer{}}
+	tr.t.scpStdoutPipe = acceptingRemote()
+	if err := tr.t.sendTree(dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	root := filepath.Base(dir)
+	expected := []Warning{
+		{RelPath: root + "/dangling", Message: "Skipped dangling symlink"},
+	}
+	if !reflect.DeepEqual(tr.Warnings(), expected) {
+		expectedError(t, tr.Warnings(), expected)
+	}
+
+	// Also kept by the client, apart from errors
+	if !reflect.DeepEqual(c.GetWarnings(), expected) || c.ErrorCount() != 0 {
+		expectedError(t, c.GetWarnings(), expected)
+	}
+	c.ClearWarnings()
+	if len(c.GetWarnings()) != 0 {
+		expectedError(t, c.GetWarnings(), nil)
+	}
+
+	if s := expected[0].String(); s != root+"/dangling: Skipped dangling symlink" {
+		expectedError(t, s, root+"/dangling: Skipped dangling symlink")
+	}
+}
@@ -0,0 +1,84 @@
+package goscp
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// WriteFS is the filesystem downloads write to with the WriteFS option,
+// e.g. an in-memory one for tests or a sandbox. Names are local paths built
+// from DestinationPath with filepath.Join.
+//
+// Implementations with a Remove(name string) error method get partly
+// written files removed, and those with a Stat(name string) (os.FileInfo,
+// error) method get directory modes adjusted like local ones.
+type WriteFS interface {
+	// Create or truncate the file name for writing
+	Create(name string) (io.WriteCloser, error)
+
+	// Create the directory name, whose parent exists. Fails if name
+	// exists already, like os.Mkdir, and the directory is then skipped.
+	Mkdir(name string, perm os.FileMode) error
+
+	// Set the access and modification times of name
+	Chtimes(name string, atime, mtime time.Time) error
+
+	// Set the mode of name
+	Chmod(name string, mode os.FileMode) error
+}
+
+// The local filesystem.
+type osFS struct{}
+
+func (osFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (osFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Filesystem downloads write to, from the WriteFS option.
+func (t *transfer) writeFS() WriteFS {
+	if t.opts.WriteFS != nil {
+		return t.opts.WriteFS
+	}
+	return osFS{}
+}
+
+// Remove a file the download created, if the filesystem can.
+func (t *transfer) removeLocal(name string) {
+	if fsys, ok := t.writeFS().(interface{ Remove(string) error }); ok {
+		fsys.Remove(name)
+	}
+}
+
+// Stat a downloaded directory, if the filesystem can.
+func (t *transfer) statLocal(name string) (os.FileInfo, bool, error) {
+	fsys, ok := t.writeFS().(interface {
+		Stat(string) (os.FileInfo, error)
+	})
+	if !ok {
+		return nil, false, nil
+	}
+
+	info, err := fsys.Stat(name)
+	return info, true, err
+}
@@ -0,0 +1,111 @@
+package goscp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// WriteFS in memory, recording what was done.
+type memFS struct {
+	files map[string]*bytes.Buffer
+	dirs  map[string]os.FileMode
+	modes map[string]os.FileMode
+	times map[string]time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		files: make(map[string]*bytes.Buffer),
+		dirs:  make(map[string]os.FileMode),
+		modes: make(map[string]os.FileMode),
+		times: make(map[string]time.Time),
+	}
+}
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	m.files[filepath.ToSlash(name)] = &bytes.Buffer{}
+	return nopWriteCloser{m.files[filepath.ToSlash(name)]}, nil
+}
+
+func (m *memFS) Mkdir(name string, perm os.FileMode) error {
+	if _, ok := m.dirs[filepath.ToSlash(name)]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	m.dirs[filepath.ToSlash(name)] = perm
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.times[filepath.ToSlash(name)] = mtime
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode os.FileMode) error {
+	m.modes[filepath.ToSlash(name)] = mode
+	return nil
+}
+
+func TestDownloadWriteFS(t *testing.T) {
+	fsys := newMemFS()
+
+	input := "T1700000000 0 1700000000 0\nD0500 0 media\nC0600 3 a.txt\nabc\x00T1600000000 0 1600000000 0\nC0644 2 b.txt\nhi\x00E\n"
+	c := &transfer{
+		opts: TransferOptions{
+			WriteFS:       fsys,
+			PreserveTimes: true,
+			PreserveMode:  true,
+			Security:      Security{ConfineToDestination: true},
+		},
+		scpStdinPipe:  nopWriteCloser{&bytes.Buffer{}},
+		scpStdoutPipe: &readCanceller{Reader: bufio.NewReader(strings.NewReader(input))},
+	}
+	c.path = []string{"goscp-memfs"}
+	c.startSecurityCheck("/srv/media")
+	c.handleDownload()
+
+	if len(c.errors) != 0 {
+		t.Fatal("Unexpected errors:", c.errors)
+	}
+
+	files := map[string]string{}
+	for name, buf := range fsys.files {
+		files[name] = buf.String()
+	}
+	expectedFiles := map[string]string{"goscp-memfs/media/a.txt": "abc", "goscp-memfs/media/b.txt": "hi"}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		expectedError(t, files, expectedFiles)
+	}
+
+	// Created with owner access, given its mode once written
+	expectedDirs := map[string]os.FileMode{"goscp-memfs/media": 0700}
+	if !reflect.DeepEqual(fsys.dirs, expectedDirs) {
+		expectedError(t, fsys.dirs, expectedDirs)
+	}
+	expectedModes := map[string]os.FileMode{
+		"goscp-memfs/media":       0500,
+		"goscp-memfs/media/a.txt": 0600,
+		"goscp-memfs/media/b.txt": 0644,
+	}
+	if !reflect.DeepEqual(fsys.modes, expectedModes) {
+		expectedError(t, fsys.modes, expectedModes)
+	}
+	expectedTimes := map[string]time.Time{
+		"goscp-memfs/media":       time.Unix(1700000000, 0),
+		"goscp-memfs/media/b.txt": time.Unix(1600000000, 0),
+	}
+	if !reflect.DeepEqual(fsys.times, expectedTimes) {
+		expectedError(t, fsys.times, expectedTimes)
+	}
+
+	// Nothing is written locally
+	if _, err := os.Stat("goscp-memfs"); !os.IsNotExist(err) {
+		expectedError(t, err, os.ErrNotExist)
+	}
+}